@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+	"github.com/healthcareblocks/ebs_snapshotter/ebs"
+)
+
+// auditExitCode is returned by the audit command when coverage SLOs are violated,
+// to distinguish violations from errors running the audit
+const auditExitCode = 2
+
+// auditCommand reports the snapshot coverage of every volume in the given regions,
+// exiting with auditExitCode if any snapshotted volume has no recent completed snapshot
+func auditCommand(args []string) {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	regions := flags.String("regions", "", "AWS EC2 regions (comma delimited) to audit, or \"all\" for every enabled region.\n\tIf not set this value is determined using the host machine's EC2 metadata.")
+	maxAge := flags.Duration("max_age", 26*time.Hour, "Maximum age of a volume's newest completed snapshot")
+	includeUnattached := flags.Bool("include_unattached", false, "Audit unattached (available) volumes instead of excluding them, as when\n\tsnapshotting with -include_unattached")
+	backupKeyNameDevice := flags.Bool("backup_key_name_device", false, "Group volumes without an ebs_snapshotter:backup_key tag by their Name tag and device,\n\tas when snapshotting with -backup_key_name_device")
+	format := flags.String("format", "table", "Output format: table or json")
+	excludeRegions := flags.String("exclude_regions", "", "AWS EC2 regions (comma delimited) to skip, e.g. with -regions=all")
+	debug := flags.Bool("d", false, "Turns on AWS request profiling")
+	flags.Parse(args)
+
+	if *format != "table" && *format != "json" {
+		log.Fatalf("unknown -format %q, must be table or json", *format)
+	}
+
+	regionNames := regionList(*regions, *excludeRegions)
+	coverages := make([]*ebs.Coverage, len(regionNames))
+
+	var wg sync.WaitGroup
+	for i, region := range regionNames {
+		wg.Add(1)
+		go func(i int, region string) {
+			defer wg.Done()
+
+			mgr, err := ebs.NewSnapshotManager(region, "", false, 1, *debug)
+			awserror.HandleError(err)
+			mgr.IncludeUnattached = *includeUnattached
+			mgr.BackupKeyFromNameAndDevice = *backupKeyNameDevice
+			coverage, err := mgr.AuditCoverage(*maxAge)
+			awserror.HandleError(err)
+			coverages[i] = coverage
+		}(i, region)
+	}
+	wg.Wait()
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(coverages); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		printCoverage(coverages)
+	}
+
+	for _, coverage := range coverages {
+		if coverage.Violated() {
+			os.Exit(auditExitCode)
+		}
+	}
+}
+
+func printCoverage(coverages []*ebs.Coverage) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REGION\tVOLUME\tNAME\tATTACHED\tSNAPSHOTS\tNEWEST COMPLETED\tSTATUS")
+	for _, coverage := range coverages {
+		for _, volume := range coverage.Volumes {
+			newest := "-"
+			if volume.NewestSnapshotTime != nil {
+				newest = fmt.Sprintf("%s (%s ago)", volume.NewestSnapshotID, time.Since(*volume.NewestSnapshotTime).Round(time.Minute))
+			}
+
+			status := volume.Status
+			if volume.ExcludedReason != "" {
+				status = fmt.Sprintf("%s (%s)", status, volume.ExcludedReason)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%d\t%s\t%s\n",
+				coverage.Region, volume.VolumeID, volume.Name, volume.Attached, volume.Snapshots, newest, status)
+		}
+	}
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REGION\tATTACHED\tUNATTACHED\tEXCLUDED\tNO SNAPSHOT\tSTALE")
+	for _, coverage := range coverages {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\n",
+			coverage.Region, coverage.Attached, coverage.Unattached, coverage.Excluded, coverage.NoSnapshot, coverage.Stale)
+	}
+	w.Flush()
+}
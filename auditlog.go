@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/healthcareblocks/ebs_snapshotter/auditlog"
+	"github.com/healthcareblocks/ebs_snapshotter/sts"
+)
+
+// auditLogFlags holds the audit log destination flags, shared by the top level flags and the
+// commands that change resources
+type auditLogFlags struct {
+	path   *string
+	group  *string
+	bucket *string
+	prefix *string
+	region *string
+}
+
+// newAuditLogFlags defines the audit log flags on the flag set
+func newAuditLogFlags(flags *flag.FlagSet) *auditLogFlags {
+	return &auditLogFlags{
+		path:   flags.String("audit_log", "", "Path of a local file to append a JSON-lines audit log of every change made to\n\tsnapshots and volumes to"),
+		group:  flags.String("audit_log_group", "", "Optional CloudWatch Logs group to also send the audit log to. A stream is created\n\tfor each run."),
+		bucket: flags.String("audit_log_bucket", "", "Optional S3 bucket to also upload the audit log of each run to"),
+		prefix: flags.String("audit_log_prefix", "ebs_snapshotter/", "Key prefix of audit logs uploaded to -audit_log_bucket"),
+		region: flags.String("audit_log_region", "", "AWS region of -audit_log_group and -audit_log_bucket. Defaults to the first\n\tsnapshot region."),
+	}
+}
+
+// enabled returns true if an audit log destination is set
+func (f *auditLogFlags) enabled() bool {
+	return *f.path != "" || *f.group != "" || *f.bucket != ""
+}
+
+// open returns a new audit log writing to the configured destinations, or nil if none are
+// set. The caller identity is looked up in region, which is also the default region of the
+// CloudWatch Logs and S3 destinations. It exits if the local file can't be opened, so that
+// no change is made without being recorded.
+func (f *auditLogFlags) open(region string) *auditlog.Logger {
+	if !f.enabled() {
+		return nil
+	}
+
+	destinationRegion := *f.region
+	if destinationRegion == "" {
+		destinationRegion = region
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	run := fmt.Sprintf("%s-%s", hostname, time.Now().UTC().Format("20060102T150405Z"))
+
+	var sinks []auditlog.Sink
+	if *f.path != "" {
+		file, err := auditlog.OpenFile(*f.path)
+		if err != nil {
+			log.Fatalf("can't open audit log: %v", err)
+		}
+		sinks = append(sinks, file)
+	}
+
+	if *f.group != "" {
+		sinks = append(sinks, &auditlog.CloudWatchLogs{Region: destinationRegion, Group: *f.group, Stream: run})
+	}
+
+	if *f.bucket != "" {
+		sinks = append(sinks, &auditlog.S3{Region: destinationRegion, Bucket: *f.bucket, Key: *f.prefix + run + ".jsonl"})
+	}
+
+	identity, err := sts.CallerIdentity(region)
+	if err != nil {
+		log.Printf("can't determine AWS caller identity for audit log: %v", err)
+		identity = &sts.Identity{}
+	}
+
+	return auditlog.New(identity.Account, identity.Arn, sinks...)
+}
+
+// closeAuditLog flushes the audit log, if any, logging any error
+func closeAuditLog(logger *auditlog.Logger) {
+	if logger == nil {
+		return
+	}
+	if err := logger.Close(); err != nil {
+		log.Print(err)
+	}
+}
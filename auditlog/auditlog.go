@@ -0,0 +1,186 @@
+// Package auditlog records every mutating AWS action as an append-only JSON-lines audit
+// trail, written to a local file and optionally to CloudWatch Logs or S3
+package auditlog
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Outcomes of an audited action
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Entry describes a single mutating action
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Region string    `json:"region"`
+
+	// The AWS account and the ARN of the identity that performed the action
+	Account string `json:"account,omitempty"`
+	Caller  string `json:"caller,omitempty"`
+
+	// The API operation, e.g. CreateSnapshot
+	Action string `json:"action"`
+
+	// IDs of the resources the action read from or changed, including created resources
+	Resources []string `json:"resources"`
+
+	// OutcomeSuccess or OutcomeFailure
+	Outcome string `json:"outcome"`
+
+	// The AWS error code and message if the action failed
+	Error string `json:"error,omitempty"`
+
+	// The AWS request ID, for correlation with CloudTrail
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Sink receives audit log entries. Sinks may buffer entries until they are closed.
+type Sink interface {
+	Write(entry Entry) error
+	Close() error
+}
+
+// Logger writes audit log entries to one or more sinks. It is safe for concurrent use.
+type Logger struct {
+	// The AWS account and caller ARN added to every entry, see sts.CallerIdentity
+	Account string
+	Caller  string
+
+	sinks []Sink
+	mu    sync.Mutex
+}
+
+// New returns a new Logger pointer that writes to the given sinks
+func New(account string, caller string, sinks ...Sink) *Logger {
+	return &Logger{Account: account, Caller: caller, sinks: sinks}
+}
+
+// Record writes an entry to every sink, filling in its time, account and caller. Sink errors
+// are logged rather than returned, so that a failing audit destination doesn't stop backups.
+func (l *Logger) Record(entry Entry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	entry.Account = l.Account
+	entry.Caller = l.Caller
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, sink := range l.sinks {
+		if err := sink.Write(entry); err != nil {
+			log.Printf("can't write audit log entry: %v", err)
+		}
+	}
+}
+
+// Close flushes and closes every sink, returning the errors encountered
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var errs []string
+	for _, sink := range l.sinks {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("can't close audit log: " + strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Handler returns an AWS SDK request handler that records every mutating EC2 request made
+// in the region once it completes. Add it to a client's Complete handlers:
+//
+//	client.Handlers.Complete.PushBackNamed(logger.Handler("us-east-1"))
+func (l *Logger) Handler(region string) request.NamedHandler {
+	return request.NamedHandler{
+		Name: "auditlog.Handler",
+		Fn: func(r *request.Request) {
+			if !mutating(r.Operation.Name) {
+				return
+			}
+
+			entry := Entry{
+				Region:    region,
+				Action:    r.Operation.Name,
+				Resources: resources(r.Params, r.Data),
+				Outcome:   OutcomeSuccess,
+				RequestID: r.RequestID,
+			}
+
+			if r.Error != nil {
+				entry.Outcome = OutcomeFailure
+				if awsErr, ok := r.Error.(awserr.Error); ok {
+					entry.Error = awsErr.Code() + ": " + awsErr.Message()
+				} else {
+					entry.Error = r.Error.Error()
+				}
+			}
+
+			l.Record(entry)
+		},
+	}
+}
+
+// mutating returns true if the operation changes resources, rather than reading them
+func mutating(operation string) bool {
+	for _, prefix := range []string{"Describe", "Get", "List"} {
+		if strings.HasPrefix(operation, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// resources returns the IDs of the resources in an EC2 request and its response
+func resources(params interface{}, data interface{}) (ids []string) {
+	switch input := params.(type) {
+	case *ec2.CreateSnapshotInput:
+		ids = append(ids, aws.StringValue(input.VolumeId))
+		if output, ok := data.(*ec2.Snapshot); ok && output.SnapshotId != nil {
+			ids = append(ids, *output.SnapshotId)
+		}
+	case *ec2.CopySnapshotInput:
+		ids = append(ids, aws.StringValue(input.SourceSnapshotId))
+		if output, ok := data.(*ec2.CopySnapshotOutput); ok && output.SnapshotId != nil {
+			ids = append(ids, *output.SnapshotId)
+		}
+	case *ec2.CreateVolumeInput:
+		ids = append(ids, aws.StringValue(input.SnapshotId))
+		if output, ok := data.(*ec2.Volume); ok && output.VolumeId != nil {
+			ids = append(ids, *output.VolumeId)
+		}
+	case *ec2.CreateTagsInput:
+		ids = aws.StringValueSlice(input.Resources)
+	case *ec2.DeleteTagsInput:
+		ids = aws.StringValueSlice(input.Resources)
+	case *ec2.DeleteSnapshotInput:
+		ids = append(ids, aws.StringValue(input.SnapshotId))
+	case *ec2.ModifySnapshotAttributeInput:
+		ids = append(ids, aws.StringValue(input.SnapshotId))
+	case *ec2.ResetSnapshotAttributeInput:
+		ids = append(ids, aws.StringValue(input.SnapshotId))
+	case *ec2.AttachVolumeInput:
+		ids = append(ids, aws.StringValue(input.VolumeId), aws.StringValue(input.InstanceId))
+	case *ec2.DetachVolumeInput:
+		ids = append(ids, aws.StringValue(input.VolumeId))
+	case *ec2.DeleteVolumeInput:
+		ids = append(ids, aws.StringValue(input.VolumeId))
+	}
+	return ids
+}
@@ -0,0 +1,176 @@
+package auditlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+// memorySink collects entries in memory
+type memorySink struct {
+	entries []Entry
+	closed  bool
+}
+
+func (s *memorySink) Write(entry Entry) error {
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestFileAppendsJSONLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditlog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	for i := 0; i < 2; i++ {
+		file, err := OpenFile(path)
+		assert.NoError(t, err)
+
+		logger := New("123456789012", "arn:aws:iam::123456789012:role/backup", file)
+		logger.Record(Entry{Region: "us-west-1", Action: "DeleteSnapshot", Resources: []string{"snap-1"}, Outcome: OutcomeSuccess})
+		assert.NoError(t, logger.Close())
+	}
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry Entry
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "123456789012", entries[0].Account)
+	assert.Equal(t, "arn:aws:iam::123456789012:role/backup", entries[0].Caller)
+	assert.Equal(t, []string{"snap-1"}, entries[0].Resources)
+	assert.False(t, entries[0].Time.IsZero())
+}
+
+func TestHandlerRecordsMutatingRequests(t *testing.T) {
+	sink := &memorySink{}
+	logger := New("123456789012", "arn", sink)
+	handler := logger.Handler("us-west-1")
+
+	handler.Fn(&request.Request{
+		Operation: &request.Operation{Name: "DescribeSnapshots"},
+		Params:    &ec2.DescribeSnapshotsInput{},
+	})
+
+	handler.Fn(&request.Request{
+		Operation: &request.Operation{Name: "CreateSnapshot"},
+		Params:    &ec2.CreateSnapshotInput{VolumeId: aws.String("vol-1")},
+		Data:      &ec2.Snapshot{SnapshotId: aws.String("snap-2")},
+		RequestID: "req-1",
+	})
+
+	handler.Fn(&request.Request{
+		Operation: &request.Operation{Name: "DeleteSnapshot"},
+		Params:    &ec2.DeleteSnapshotInput{SnapshotId: aws.String("snap-1")},
+		Data:      &ec2.DeleteSnapshotOutput{},
+		Error:     awserr.New("InvalidSnapshot.InUse", "in use", nil),
+	})
+
+	assert.NoError(t, logger.Close())
+	assert.True(t, sink.closed)
+	assert.Len(t, sink.entries, 2)
+
+	created := sink.entries[0]
+	assert.Equal(t, "us-west-1", created.Region)
+	assert.Equal(t, "CreateSnapshot", created.Action)
+	assert.Equal(t, []string{"vol-1", "snap-2"}, created.Resources)
+	assert.Equal(t, OutcomeSuccess, created.Outcome)
+	assert.Equal(t, "req-1", created.RequestID)
+
+	deleted := sink.entries[1]
+	assert.Equal(t, []string{"snap-1"}, deleted.Resources)
+	assert.Equal(t, OutcomeFailure, deleted.Outcome)
+	assert.Equal(t, "InvalidSnapshot.InUse: in use", deleted.Error)
+}
+
+func TestBatchesStayWithinPutLogEventsLimits(t *testing.T) {
+	start := time.Date(2017, 3, 1, 9, 0, 0, 0, time.UTC)
+	event := func(at time.Time, size int) *cloudwatchlogs.InputLogEvent {
+		return &cloudwatchlogs.InputLogEvent{
+			Message:   aws.String(strings.Repeat("x", size)),
+			Timestamp: aws.Int64(at.UnixNano() / 1e6),
+		}
+	}
+
+	var events []*cloudwatchlogs.InputLogEvent
+	for i := 0; i < maxEventsPerRequest+1; i++ {
+		events = append(events, event(start, 10))
+	}
+	sizes := func(batches [][]*cloudwatchlogs.InputLogEvent) []int {
+		var sizes []int
+		for _, batch := range batches {
+			sizes = append(sizes, len(batch))
+		}
+		return sizes
+	}
+	assert.Equal(t, []int{maxEventsPerRequest, 1}, sizes(batches(events)))
+
+	// five events of 256KB exceed the request size with their overhead
+	events = nil
+	for i := 0; i < 5; i++ {
+		events = append(events, event(start, 262144-eventOverhead))
+	}
+	assert.Equal(t, []int{4, 1}, sizes(batches(events)))
+
+	events = []*cloudwatchlogs.InputLogEvent{
+		event(start, 10),
+		event(start.Add(23*time.Hour), 10),
+		event(start.Add(24*time.Hour), 10),
+	}
+	assert.Equal(t, []int{2, 1}, sizes(batches(events)))
+	assert.Empty(t, batches(nil))
+}
+
+func TestCloudWatchLogsUsesGivenSession(t *testing.T) {
+	var actions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actions = append(actions, r.Header.Get("X-Amz-Target"))
+		fmt.Fprintln(w, "{}")
+	}))
+	defer server.Close()
+
+	sess, err := session.NewSession(aws.NewConfig().
+		WithEndpoint(server.URL).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	assert.NoError(t, err)
+
+	sink := &CloudWatchLogs{Region: "us-west-1", Group: "audit", Stream: "run", Session: sess}
+	assert.NoError(t, sink.Write(Entry{Time: time.Now(), Action: "DeleteSnapshot"}))
+	assert.NoError(t, sink.Close())
+	assert.Equal(t, []string{"Logs_20140328.CreateLogStream", "Logs_20140328.PutLogEvents"}, actions)
+}
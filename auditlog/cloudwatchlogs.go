@@ -0,0 +1,118 @@
+package auditlog
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+)
+
+// PutLogEvents limits on each request: the number of events, their total size counting
+// eventOverhead bytes for each event, and the time span they cover
+const (
+	maxEventsPerRequest = 10000
+	maxBytesPerRequest  = 1048576
+	eventOverhead       = 26
+	maxRequestSpan      = 24 * time.Hour
+)
+
+// CloudWatchLogs buffers entries and sends them to a CloudWatch Logs stream when closed.
+// The log group must already exist. The host environment needs the logs:CreateLogStream and
+// logs:PutLogEvents permissions.
+type CloudWatchLogs struct {
+	// The AWS region of the log group. This parameter is required.
+	Region string
+
+	// The log group and stream names. The stream is created if it doesn't exist, and should be
+	// unique to each run so that no sequence token is needed.
+	Group  string
+	Stream string
+
+	// The session to create the client from, for example one configured with custom
+	// credentials. A new default session is used if it is nil. Region is applied on top of
+	// its configuration.
+	Session *session.Session
+
+	events []*cloudwatchlogs.InputLogEvent
+}
+
+// Write buffers the entry until Close is called
+func (c *CloudWatchLogs) Write(entry Entry) error {
+	message, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	c.events = append(c.events, &cloudwatchlogs.InputLogEvent{
+		Message:   aws.String(string(message)),
+		Timestamp: aws.Int64(entry.Time.UnixNano() / 1e6),
+	})
+	return nil
+}
+
+// Close creates the log stream and sends the buffered entries
+func (c *CloudWatchLogs) Close() error {
+	if len(c.events) == 0 {
+		return nil
+	}
+
+	sess := c.Session
+	if sess == nil {
+		var err error
+		if sess, err = session.NewSession(); err != nil {
+			return err
+		}
+	}
+	svc := cloudwatchlogs.New(sess, aws.NewConfig().WithRegion(c.Region))
+
+	_, err := svc.CreateLogStream(&cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(c.Group),
+		LogStreamName: aws.String(c.Stream),
+	})
+	if err != nil && !awserror.HasCode(err, cloudwatchlogs.ErrCodeResourceAlreadyExistsException) {
+		return err
+	}
+
+	var sequenceToken *string
+	for _, batch := range batches(c.events) {
+		resp, err := svc.PutLogEvents(&cloudwatchlogs.PutLogEventsInput{
+			LogGroupName:  aws.String(c.Group),
+			LogStreamName: aws.String(c.Stream),
+			LogEvents:     batch,
+			SequenceToken: sequenceToken,
+		})
+		if err != nil {
+			return err
+		}
+		sequenceToken = resp.NextSequenceToken
+	}
+
+	c.events = nil
+	return nil
+}
+
+// batches splits the events, sorted by time, into batches within the PutLogEvents limits
+func batches(events []*cloudwatchlogs.InputLogEvent) [][]*cloudwatchlogs.InputLogEvent {
+	var batches [][]*cloudwatchlogs.InputLogEvent
+	var batch []*cloudwatchlogs.InputLogEvent
+	size := 0
+	for _, event := range events {
+		eventSize := len(aws.StringValue(event.Message)) + eventOverhead
+		if len(batch) > 0 {
+			span := time.Duration(aws.Int64Value(event.Timestamp)-aws.Int64Value(batch[0].Timestamp)) * time.Millisecond
+			if len(batch) == maxEventsPerRequest || size+eventSize > maxBytesPerRequest || span >= maxRequestSpan {
+				batches = append(batches, batch)
+				batch, size = nil, 0
+			}
+		}
+		batch = append(batch, event)
+		size += eventSize
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
@@ -0,0 +1,36 @@
+package auditlog
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// File appends entries to a local file as JSON lines
+type File struct {
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// OpenFile opens path for appending, creating it with owner-only permissions if it
+// doesn't exist
+func OpenFile(path string) (*File, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &File{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Write appends the entry to the file
+func (f *File) Write(entry Entry) error {
+	return f.encoder.Encode(entry)
+}
+
+// Close syncs and closes the file
+func (f *File) Close() error {
+	if err := f.file.Sync(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
@@ -0,0 +1,63 @@
+package auditlog
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3 buffers entries and uploads them as a single JSON-lines object when closed. Enable
+// versioning or Object Lock on the bucket to keep the log append-only. The host environment
+// needs the s3:PutObject permission.
+type S3 struct {
+	// The AWS region of the bucket. This parameter is required.
+	Region string
+
+	// The bucket and object key to upload to. The key should be unique to each run.
+	Bucket string
+	Key    string
+
+	// The session to create the client from, for example one configured with custom
+	// credentials. A new default session is used if it is nil. Region is applied on top of
+	// its configuration.
+	Session *session.Session
+
+	buf bytes.Buffer
+}
+
+// Write buffers the entry until Close is called
+func (s *S3) Write(entry Entry) error {
+	return json.NewEncoder(&s.buf).Encode(entry)
+}
+
+// Close uploads the buffered entries
+func (s *S3) Close() error {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+
+	sess := s.Session
+	if sess == nil {
+		var err error
+		if sess, err = session.NewSession(); err != nil {
+			return err
+		}
+	}
+
+	_, err := s3.New(sess, aws.NewConfig().WithRegion(s.Region)).PutObject(&s3.PutObjectInput{
+		Bucket:               aws.String(s.Bucket),
+		Key:                  aws.String(s.Key),
+		Body:                 bytes.NewReader(s.buf.Bytes()),
+		ContentType:          aws.String("application/x-ndjson"),
+		ServerSideEncryption: aws.String(s3.ServerSideEncryptionAes256),
+	})
+	if err != nil {
+		return err
+	}
+
+	s.buf.Reset()
+	return nil
+}
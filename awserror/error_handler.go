@@ -14,6 +14,16 @@ func HandleError(err error) {
 		return
 	}
 
+	LogError(err)
+	os.Exit(1)
+}
+
+// LogError parses an AWS error and logs its details without exiting
+func LogError(err error) {
+	if err == nil {
+		return
+	}
+
 	if awsErr, ok := err.(awserr.Error); ok {
 		log.Print("Code: " + awsErr.Code())
 		log.Print("Message: " + awsErr.Message())
@@ -32,5 +42,10 @@ func HandleError(err error) {
 	} else {
 		log.Print(err.Error())
 	}
-	os.Exit(1)
+}
+
+// HasCode returns true if err is an AWS error with the given error code
+func HasCode(err error, code string) bool {
+	awsErr, ok := err.(awserr.Error)
+	return ok && awsErr.Code() == code
 }
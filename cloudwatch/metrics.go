@@ -0,0 +1,146 @@
+// Package cloudwatch publishes snapshot run metrics to Amazon CloudWatch
+//
+// Note: this package relies on the AWS SDK, thus the host environment should
+// either have an associated IAM role or user with the cloudwatch:PutMetricData permission.
+package cloudwatch
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/healthcareblocks/ebs_snapshotter/report"
+)
+
+// DefaultNamespace is the CloudWatch namespace metrics are published to by default
+const DefaultNamespace = "EBSSnapshotter"
+
+// maxDatumsPerRequest is the PutMetricData limit on metric datums per request
+const maxDatumsPerRequest = 20
+
+// Publisher publishes the metrics of a snapshot run. Each region's metrics are published
+// to CloudWatch in that region, with Region and Policy dimensions:
+//
+//   - SnapshotsCreated, SnapshotsFailed and SnapshotsDeleted (Count)
+//   - RunDuration (Seconds)
+//   - OldestSnapshotAge and NewestSnapshotAge (Seconds), with an additional VolumeId dimension.
+//     NewestSnapshotAge is the age of the newest completed snapshot.
+//
+// SnapshotsFailed includes failures that affect the whole region. Volumes without a newest
+// snapshot, and regions or runs that stop publishing, leave gaps in NewestSnapshotAge, so
+// alarms on it should treat missing data as breaching.
+//
+// If VolumeMetrics is set, the Count metrics are also published per volume with a
+// VolumeId dimension.
+type Publisher struct {
+	// The CloudWatch namespace. Defaults to DefaultNamespace if empty.
+	Namespace string
+
+	// Whether to publish per volume counts in addition to per region counts
+	VolumeMetrics bool
+}
+
+// Publish sends the report's metrics to CloudWatch
+func (p *Publisher) Publish(r *report.Report) error {
+	now := time.Now()
+	for _, region := range r.Regions {
+		if err := p.put(region.Name, p.datums(r.Policy, region, now)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Publisher) datums(policy string, region *report.Region, now time.Time) (datums []*cloudwatch.MetricDatum) {
+	dimensions := []*cloudwatch.Dimension{
+		dimension("Region", region.Name),
+		dimension("Policy", policy),
+	}
+
+	datums = append(datums,
+		datum("SnapshotsCreated", float64(len(region.Created)), cloudwatch.StandardUnitCount, dimensions, now),
+		datum("SnapshotsFailed", float64(region.SnapshotsFailed()), cloudwatch.StandardUnitCount, dimensions, now),
+		datum("SnapshotsDeleted", float64(len(region.Deleted)), cloudwatch.StandardUnitCount, dimensions, now),
+		datum("RunDuration", region.Duration.Seconds(), cloudwatch.StandardUnitSeconds, dimensions, now),
+	)
+
+	for _, volume := range region.Volumes {
+		volumeDimensions := append([]*cloudwatch.Dimension{dimension("VolumeId", volume.ID)}, dimensions...)
+
+		if !volume.OldestSnapshot.IsZero() {
+			age := now.Sub(volume.OldestSnapshot).Seconds()
+			datums = append(datums, datum("OldestSnapshotAge", age, cloudwatch.StandardUnitSeconds, volumeDimensions, now))
+		}
+
+		if !volume.NewestSnapshot.IsZero() {
+			age := now.Sub(volume.NewestSnapshot).Seconds()
+			datums = append(datums, datum("NewestSnapshotAge", age, cloudwatch.StandardUnitSeconds, volumeDimensions, now))
+		}
+
+		if p.VolumeMetrics {
+			var created, failed float64
+			if volume.SnapshotID != "" {
+				created = 1
+			}
+			if volume.Failed() {
+				failed = 1
+			}
+
+			datums = append(datums,
+				datum("SnapshotsCreated", created, cloudwatch.StandardUnitCount, volumeDimensions, now),
+				datum("SnapshotsFailed", failed, cloudwatch.StandardUnitCount, volumeDimensions, now),
+				datum("SnapshotsDeleted", float64(len(volume.Deleted)), cloudwatch.StandardUnitCount, volumeDimensions, now),
+			)
+		}
+	}
+
+	return datums
+}
+
+// put publishes datums to CloudWatch in the given region, in batches of maxDatumsPerRequest
+func (p *Publisher) put(region string, datums []*cloudwatch.MetricDatum) error {
+	namespace := p.Namespace
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	sess, err := session.NewSession(aws.NewConfig().WithRegion(region))
+	if err != nil {
+		return err
+	}
+	svc := cloudwatch.New(sess)
+
+	for len(datums) > 0 {
+		n := len(datums)
+		if n > maxDatumsPerRequest {
+			n = maxDatumsPerRequest
+		}
+
+		_, err := svc.PutMetricData(&cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(namespace),
+			MetricData: datums[:n],
+		})
+		if err != nil {
+			return err
+		}
+
+		datums = datums[n:]
+	}
+
+	return nil
+}
+
+func dimension(name string, value string) *cloudwatch.Dimension {
+	return &cloudwatch.Dimension{Name: aws.String(name), Value: aws.String(value)}
+}
+
+func datum(name string, value float64, unit string, dimensions []*cloudwatch.Dimension, timestamp time.Time) *cloudwatch.MetricDatum {
+	return &cloudwatch.MetricDatum{
+		MetricName: aws.String(name),
+		Value:      aws.Float64(value),
+		Unit:       aws.String(unit),
+		Dimensions: dimensions,
+		Timestamp:  aws.Time(timestamp),
+	}
+}
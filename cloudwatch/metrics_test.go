@@ -0,0 +1,64 @@
+package cloudwatch
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/healthcareblocks/ebs_snapshotter/report"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatumsIncludeVolumeMetricsWhenEnabled(t *testing.T) {
+	now := time.Now()
+
+	region := report.NewRegion("us-east-1")
+	region.AddVolume(report.Volume{ID: "vol-1", SnapshotID: "snap-2", Deleted: []string{"snap-1"}, OldestSnapshot: now.Add(-time.Hour), NewestSnapshot: now.Add(-time.Minute)})
+	region.AddVolume(report.Volume{ID: "vol-2", Error: "throttled"})
+
+	p := &Publisher{}
+	datums := p.datums("daily", region, now)
+	assert.Len(t, datums, 6)
+	assert.Equal(t, 1.0, value(datums, "SnapshotsFailed", ""))
+	assert.Equal(t, 3600.0, value(datums, "OldestSnapshotAge", "vol-1"))
+	assert.Equal(t, 60.0, value(datums, "NewestSnapshotAge", "vol-1"))
+	assert.Equal(t, -1.0, value(datums, "NewestSnapshotAge", "vol-2"))
+
+	p.VolumeMetrics = true
+	datums = p.datums("daily", region, now)
+	assert.Len(t, datums, 12)
+	assert.Equal(t, 1.0, value(datums, "SnapshotsDeleted", "vol-1"))
+	assert.Equal(t, 1.0, value(datums, "SnapshotsFailed", "vol-2"))
+}
+
+// value returns the value of the named metric, for a specific volume or the region
+// as a whole if volumeID is empty
+func value(datums []*cloudwatch.MetricDatum, name string, volumeID string) float64 {
+	for _, datum := range datums {
+		if *datum.MetricName != name {
+			continue
+		}
+
+		var datumVolumeID string
+		for _, dimension := range datum.Dimensions {
+			if *dimension.Name == "VolumeId" {
+				datumVolumeID = *dimension.Value
+			}
+		}
+
+		if datumVolumeID == volumeID {
+			return aws.Float64Value(datum.Value)
+		}
+	}
+	return -1
+}
+
+func TestSnapshotsFailedIncludesRegionFailures(t *testing.T) {
+	region := report.NewRegion("us-east-1")
+	region.AddFailure(report.Volume{}, errors.New("AuthFailure"))
+
+	datums := (&Publisher{}).datums("daily", region, time.Now())
+	assert.Equal(t, 1.0, value(datums, "SnapshotsFailed", ""))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+	"github.com/healthcareblocks/ebs_snapshotter/ebs"
+	"github.com/healthcareblocks/ec2_metrics_publisher/metadata"
+)
+
+// commands are run as "ebs_snapshotter <command> [flags]". Without a command,
+// volumes are snapshotted using the top level flags.
+var commands = map[string]func(args []string){
+	"audit":    auditCommand,
+	"cost":     costCommand,
+	"exposure": exposureCommand,
+	"list":     listCommand,
+	"prune":    pruneCommand,
+	"reap":     reapCommand,
+	"restore":  restoreCommand,
+}
+
+// usage prints the top level flags followed by the available commands
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags]\n       %s <command> [flags]\n\nFlags:\n", os.Args[0], os.Args[0])
+	flag.PrintDefaults()
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "\nCommands (run \"%s <command> -h\" for flags):\n  %s\n", os.Args[0], strings.Join(names, "\n  "))
+}
+
+// allRegions is the -regions value that selects every region enabled for the account
+const allRegions = "all"
+
+// defaultDiscoveryRegion is queried for the enabled regions when -regions=all, unless
+// the AWS_REGION environment variable is set
+const defaultDiscoveryRegion = "us-east-1"
+
+// regionList returns the regions selected by resolveRegions, exiting if they can't be determined
+func regionList(regions string, exclude string) []string {
+	names, err := resolveRegions(regions, exclude)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return names
+}
+
+// resolveRegions splits a comma delimited list of regions, removing those in the comma
+// delimited exclude list. If regions is "all", every region enabled for the account is
+// returned. If regions is empty, the host machine's region is determined using EC2 metadata.
+func resolveRegions(regions string, exclude string) ([]string, error) {
+	var names []string
+
+	switch regions {
+	case "":
+		machine := metadata.Machine{}
+		if err := machine.LoadFromMetadata(); err != nil {
+			return nil, errors.New("can't get EC2 metadata, must set -regions explicitly")
+		}
+		names = []string{machine.Region}
+	case allRegions:
+		discoveryRegion := os.Getenv("AWS_REGION")
+		if discoveryRegion == "" {
+			discoveryRegion = defaultDiscoveryRegion
+		}
+
+		mgr, err := ebs.NewSnapshotManager(discoveryRegion, "", false, 1, false)
+		if err == nil {
+			names, err = mgr.EnabledRegions()
+		}
+		if err != nil {
+			awserror.LogError(err)
+			return nil, errors.New("can't describe enabled regions")
+		}
+	default:
+		names = strings.Split(regions, ",")
+	}
+
+	if exclude != "" {
+		excluded := strings.Split(exclude, ",")
+		selected := names[:0]
+		for _, name := range names {
+			if !contains(excluded, name) {
+				selected = append(selected, name)
+			}
+		}
+		names = selected
+	}
+
+	if len(names) == 0 {
+		return nil, errors.New("no regions selected, check -regions and -exclude_regions")
+	}
+	return names, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
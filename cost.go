@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+	"github.com/healthcareblocks/ebs_snapshotter/ebs"
+)
+
+// untagged labels the costs of volumes without the -group_by_tag tag
+const untagged = "(untagged)"
+
+// tagCost is the estimated monthly cost of the volumes with the same -group_by_tag value
+type tagCost struct {
+	Tag             string  `json:"tag"`
+	Volumes         int     `json:"volumes"`
+	Snapshots       int     `json:"snapshots"`
+	Monthly         float64 `json:"monthly"`
+	ProposedMonthly float64 `json:"proposed_monthly,omitempty"`
+}
+
+// costReport is the output of the cost command
+type costReport struct {
+	TagKey          string              `json:"tag_key,omitempty"`
+	Monthly         float64             `json:"monthly"`
+	ProposedMonthly float64             `json:"proposed_monthly,omitempty"`
+	Regions         []*ebs.CostEstimate `json:"regions"`
+	Tags            []tagCost           `json:"tags,omitempty"`
+}
+
+// costCommand estimates the monthly snapshot storage cost of each volume, tag and region,
+// and projects the cost of a proposed retention policy
+func costCommand(args []string) {
+	flags := flag.NewFlagSet("cost", flag.ExitOnError)
+	regions := flags.String("regions", "", "AWS EC2 regions (comma delimited) to estimate, or \"all\" for every enabled region.\n\tIf not set this value is determined using the host machine's EC2 metadata.")
+	excludeRegions := flags.String("exclude_regions", "", "AWS EC2 regions (comma delimited) to skip, e.g. with -regions=all")
+	price := flags.Float64("price", ebs.DefaultSnapshotPrice, "Snapshot storage price in USD per GiB-month")
+	regionPrices := flags.String("region_prices", "", "Snapshot storage prices (comma delimited region=price pairs) overriding -price,\n\te.g. sa-east-1=0.068,ap-northeast-1=0.05")
+	changeRate := flags.Float64("change_rate", 1, "Fraction of a volume's size stored by each snapshot after the oldest. Snapshots\n\tare incremental, so the default of 1 estimates the upper bound.")
+	groupByTag := flags.String("group_by_tag", "", "Volume tag to total costs by, e.g. CostCenter")
+	proposedRetain := flags.Int("proposed_retain", 0, "Retention policy to project costs for in place of the current snapshots")
+	includeUnattached := flags.Bool("include_unattached", false, "Project unattached (available) volumes as snapshotted, as when snapshotting\n\twith -include_unattached")
+	backupKeyNameDevice := flags.Bool("backup_key_name_device", false, "Group volumes without an ebs_snapshotter:backup_key tag by their Name tag and device,\n\tas when snapshotting with -backup_key_name_device")
+	requireEncryption := flags.Bool("require_encryption", false, "Count unencrypted snapshots and their pending encrypted copies once, as when\n\tsnapshotting with -require_encryption")
+	format := flags.String("format", "table", "Output format: table or json")
+	debug := flags.Bool("d", false, "Turns on AWS request profiling")
+	flags.Parse(args)
+
+	if *format != "table" && *format != "json" {
+		log.Fatalf("unknown -format %q, must be table or json", *format)
+	}
+	if *changeRate < 0 || *changeRate > 1 {
+		log.Fatal("-change_rate should be between 0 and 1")
+	}
+	if *proposedRetain < 0 {
+		log.Fatal("-proposed_retain should not be negative")
+	}
+	prices := mustParsePrices(*regionPrices)
+
+	regionNames := regionList(*regions, *excludeRegions)
+	estimates := make([]*ebs.CostEstimate, len(regionNames))
+
+	var wg sync.WaitGroup
+	for i, region := range regionNames {
+		wg.Add(1)
+		go func(i int, region string) {
+			defer wg.Done()
+
+			opts := ebs.CostOptions{
+				Price:          *price,
+				ChangeRate:     *changeRate,
+				TagKey:         *groupByTag,
+				ProposedRetain: *proposedRetain,
+			}
+			if regionPrice, ok := prices[region]; ok {
+				opts.Price = regionPrice
+			}
+
+			mgr, err := ebs.NewSnapshotManager(region, "", false, 1, *debug)
+			awserror.HandleError(err)
+			mgr.IncludeUnattached = *includeUnattached
+			mgr.BackupKeyFromNameAndDevice = *backupKeyNameDevice
+			mgr.RequireEncryption = *requireEncryption
+			estimate, err := mgr.EstimateCost(opts)
+			awserror.HandleError(err)
+			estimates[i] = estimate
+		}(i, region)
+	}
+	wg.Wait()
+
+	result := costReport{TagKey: *groupByTag, Regions: estimates}
+	for _, estimate := range estimates {
+		result.Monthly += estimate.Monthly
+		result.ProposedMonthly += estimate.ProposedMonthly
+	}
+	if *groupByTag != "" {
+		result.Tags = costsByTag(estimates)
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(result); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	printCost(result, *proposedRetain > 0)
+}
+
+// mustParsePrices parses the -region_prices list into prices by region, exiting if it
+// is invalid
+func mustParsePrices(list string) map[string]float64 {
+	prices := map[string]float64{}
+	for _, pair := range splitList(list) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			log.Fatalf("invalid -region_prices entry %q, must be region=price", pair)
+		}
+
+		price, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || price < 0 {
+			log.Fatalf("invalid -region_prices price %q for %s", parts[1], parts[0])
+		}
+		prices[parts[0]] = price
+	}
+	return prices
+}
+
+// costsByTag totals the volume costs of every region by tag value, most expensive first
+func costsByTag(estimates []*ebs.CostEstimate) []tagCost {
+	totals := map[string]*tagCost{}
+	for _, estimate := range estimates {
+		for _, volume := range estimate.Volumes {
+			tag := volume.Tag
+			if tag == "" {
+				tag = untagged
+			}
+
+			total, ok := totals[tag]
+			if !ok {
+				total = &tagCost{Tag: tag}
+				totals[tag] = total
+			}
+			total.Volumes++
+			total.Snapshots += volume.Snapshots
+			total.Monthly += volume.Monthly
+			total.ProposedMonthly += volume.ProposedMonthly
+		}
+	}
+
+	costs := make([]tagCost, 0, len(totals))
+	for _, total := range totals {
+		costs = append(costs, *total)
+	}
+	sort.Slice(costs, func(i, j int) bool {
+		if costs[i].Monthly != costs[j].Monthly {
+			return costs[i].Monthly > costs[j].Monthly
+		}
+		return costs[i].Tag < costs[j].Tag
+	})
+	return costs
+}
+
+func printCost(result costReport, proposed bool) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	header := "REGION\tVOLUME\tNAME\tSIZE (GiB)\tSNAPSHOTS\tSTORED (GiB)\tMONTHLY"
+	if result.TagKey != "" {
+		header = "REGION\tVOLUME\tNAME\t" + strings.ToUpper(result.TagKey) + "\tSIZE (GiB)\tSNAPSHOTS\tSTORED (GiB)\tMONTHLY"
+	}
+	if proposed {
+		header += "\tPROPOSED SNAPSHOTS\tPROPOSED MONTHLY\tCHANGE"
+	}
+	fmt.Fprintln(w, header)
+
+	for _, estimate := range result.Regions {
+		for _, volume := range estimate.Volumes {
+			name := volume.Name
+			if name == "" {
+				name = "-"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t", estimate.Region, volume.VolumeID, name)
+			if result.TagKey != "" {
+				tag := volume.Tag
+				if tag == "" {
+					tag = untagged
+				}
+				fmt.Fprintf(w, "%s\t", tag)
+			}
+			fmt.Fprintf(w, "%d\t%d\t%.1f\t%s", volume.SizeGiB, volume.Snapshots, volume.StoredGiB, formatUSD(volume.Monthly))
+			if proposed {
+				fmt.Fprintf(w, "\t%d\t%s\t%s", volume.ProposedSnapshots, formatUSD(volume.ProposedMonthly), formatChange(volume.Monthly, volume.ProposedMonthly))
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	w.Flush()
+	fmt.Println()
+
+	if len(result.Tags) > 0 {
+		header = strings.ToUpper(result.TagKey) + "\tVOLUMES\tSNAPSHOTS\tMONTHLY"
+		if proposed {
+			header += "\tPROPOSED MONTHLY\tCHANGE"
+		}
+		fmt.Fprintln(w, header)
+		for _, tag := range result.Tags {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s", tag.Tag, tag.Volumes, tag.Snapshots, formatUSD(tag.Monthly))
+			if proposed {
+				fmt.Fprintf(w, "\t%s\t%s", formatUSD(tag.ProposedMonthly), formatChange(tag.Monthly, tag.ProposedMonthly))
+			}
+			fmt.Fprintln(w)
+		}
+		w.Flush()
+		fmt.Println()
+	}
+
+	header = "REGION\tPRICE (GiB-MONTH)\tVOLUMES\tSNAPSHOTS\tMONTHLY"
+	if proposed {
+		header += "\tPROPOSED MONTHLY\tCHANGE"
+	}
+	fmt.Fprintln(w, header)
+	for _, estimate := range result.Regions {
+		fmt.Fprintf(w, "%s\t$%.3f\t%d\t%d\t%s", estimate.Region, estimate.Price, len(estimate.Volumes), estimate.Snapshots, formatUSD(estimate.Monthly))
+		if proposed {
+			fmt.Fprintf(w, "\t%s\t%s", formatUSD(estimate.ProposedMonthly), formatChange(estimate.Monthly, estimate.ProposedMonthly))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "TOTAL\t\t\t\t%s", formatUSD(result.Monthly))
+	if proposed {
+		fmt.Fprintf(w, "\t%s\t%s", formatUSD(result.ProposedMonthly), formatChange(result.Monthly, result.ProposedMonthly))
+	}
+	fmt.Fprintln(w)
+	w.Flush()
+}
+
+func formatUSD(amount float64) string {
+	return fmt.Sprintf("$%.2f", amount)
+}
+
+// formatChange renders the difference between the current and proposed cost
+func formatChange(current float64, proposed float64) string {
+	sign := "+"
+	if proposed < current {
+		sign = "-"
+	}
+	change := sign + formatUSD(math.Abs(proposed-current))
+	if current > 0 {
+		change += fmt.Sprintf(" (%+.0f%%)", (proposed-current)/current*100)
+	}
+	return change
+}
@@ -0,0 +1,189 @@
+package ebs
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Coverage statuses of a volume, see VolumeCoverage
+const (
+	CoverageOK         = "ok"
+	CoverageNoSnapshot = "no-snapshot"
+	CoverageStale      = "stale"
+	CoverageExcluded   = "excluded"
+)
+
+// VolumeCoverage describes the snapshots protecting a single EBS volume
+type VolumeCoverage struct {
+	VolumeID string `json:"volume_id"`
+	Name     string `json:"name,omitempty"`
+	State    string `json:"state"`
+	Attached bool   `json:"attached"`
+
+	// Why the volume is not snapshotted by the SnapshotManager, if it is excluded
+	ExcludedReason string `json:"excluded_reason,omitempty"`
+
+	// Number of snapshots of the volume, in any state, including the snapshots of other
+	// volumes with the same backup key
+	Snapshots int `json:"snapshots"`
+
+	// The newest completed snapshot of the volume or its backup key, if any
+	NewestSnapshotID   string     `json:"newest_snapshot_id,omitempty"`
+	NewestSnapshotTime *time.Time `json:"newest_snapshot_time,omitempty"`
+
+	// One of CoverageOK, CoverageNoSnapshot, CoverageStale or CoverageExcluded
+	Status string `json:"status"`
+}
+
+// Coverage describes the snapshot coverage of every EBS volume in a region
+type Coverage struct {
+	Region string `json:"region"`
+
+	// The maximum age of a volume's newest completed snapshot before it is stale
+	MaxAge time.Duration `json:"max_age"`
+
+	Attached   int `json:"attached"`
+	Unattached int `json:"unattached"`
+	Excluded   int `json:"excluded"`
+	NoSnapshot int `json:"no_snapshot"`
+	Stale      int `json:"stale"`
+
+	Volumes []VolumeCoverage `json:"volumes"`
+}
+
+// Violated returns true if any volume managed by the SnapshotManager has no completed
+// snapshot or only stale ones
+func (c *Coverage) Violated() bool {
+	return c.NoSnapshot > 0 || c.Stale > 0
+}
+
+// AuditCoverage reports which volumes in the SnapshotManager's region are protected by a
+// completed snapshot newer than maxAge. Snapshots are grouped the way retention groups them,
+// so a volume that replaced another with the same backup key is protected by its snapshots.
+// Volumes the SnapshotManager would not snapshot are reported as excluded rather than as
+// violations.
+func (mgr *SnapshotManager) AuditCoverage(maxAge time.Duration) (*Coverage, error) {
+	var volumes []*ec2.Volume
+	err := mgr.ec2.DescribeVolumesPages(&ec2.DescribeVolumesInput{}, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		volumes = append(volumes, page.Volumes...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	owned, err := mgr.describeOwnedSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	coverage := &Coverage{Region: mgr.Region, MaxAge: maxAge}
+	now := time.Now()
+
+	for _, volume := range volumes {
+		vc := VolumeCoverage{
+			VolumeID: *volume.VolumeId,
+			Name:     volumeName(volume),
+			State:    aws.StringValue(volume.State),
+			Attached: isAttached(volume),
+		}
+
+		if vc.Attached {
+			coverage.Attached++
+		} else {
+			coverage.Unattached++
+		}
+
+		snapshots := owned.group(volume, mgr.backupKey(volume))
+		vc.Snapshots = len(snapshots)
+		for _, snapshot := range snapshots {
+			if aws.StringValue(snapshot.State) != ec2.SnapshotStateCompleted {
+				continue
+			}
+			if vc.NewestSnapshotTime == nil || snapshot.StartTime.After(*vc.NewestSnapshotTime) {
+				vc.NewestSnapshotID = *snapshot.SnapshotId
+				vc.NewestSnapshotTime = snapshot.StartTime
+			}
+		}
+
+		switch {
+		case !vc.Attached && !(mgr.IncludeUnattached && vc.State == ec2.VolumeStateAvailable):
+			vc.ExcludedReason = "unattached"
+		case isExcluded(volume):
+			vc.ExcludedReason = ExcludeTag + " tag"
+		}
+
+		switch {
+		case vc.ExcludedReason != "":
+			vc.Status = CoverageExcluded
+			coverage.Excluded++
+		case vc.NewestSnapshotTime == nil:
+			vc.Status = CoverageNoSnapshot
+			coverage.NoSnapshot++
+		case now.Sub(*vc.NewestSnapshotTime) > maxAge:
+			vc.Status = CoverageStale
+			coverage.Stale++
+		default:
+			vc.Status = CoverageOK
+		}
+
+		coverage.Volumes = append(coverage.Volumes, vc)
+	}
+
+	return coverage, nil
+}
+
+// ownedSnapshots indexes the snapshots owned by the account, so that the snapshots
+// describeSnapshots would return for each volume can be found with a single paged request
+type ownedSnapshots struct {
+	// Snapshots keyed by volume ID. Encrypted copies are keyed by the volume of the snapshot
+	// they were copied from.
+	byVolume map[string][]*ec2.Snapshot
+
+	// Snapshots keyed by their BackupKeyTag value
+	byKey map[string][]*ec2.Snapshot
+}
+
+// describeOwnedSnapshots returns all snapshots owned by the account
+func (mgr *SnapshotManager) describeOwnedSnapshots() (*ownedSnapshots, error) {
+	params := &ec2.DescribeSnapshotsInput{
+		OwnerIds: []*string{aws.String("self")},
+	}
+
+	owned := &ownedSnapshots{byVolume: map[string][]*ec2.Snapshot{}, byKey: map[string][]*ec2.Snapshot{}}
+	err := mgr.ec2.DescribeSnapshotsPages(params, func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+		for _, snapshot := range page.Snapshots {
+			volumeID := sourceVolumeID(snapshot)
+			owned.byVolume[volumeID] = append(owned.byVolume[volumeID], snapshot)
+			if key := tagValue(snapshot.Tags, BackupKeyTag); key != "" {
+				owned.byKey[key] = append(owned.byKey[key], snapshot)
+			}
+		}
+		return true
+	})
+	return owned, err
+}
+
+// group returns the snapshots of the volume merged with those of its backup key, if any,
+// sorted oldest first, as describeSnapshots returns them
+func (owned *ownedSnapshots) group(volume *ec2.Volume, key string) []*ec2.Snapshot {
+	snapshots := mergeSnapshots(owned.byVolume[*volume.VolumeId], nil)
+	if key != "" {
+		snapshots = mergeSnapshots(snapshots, owned.byKey[key])
+	}
+	sort.Sort(ByStartTime(snapshots))
+	return snapshots
+}
+
+// isAttached returns true if the volume is attached to an instance
+func isAttached(volume *ec2.Volume) bool {
+	for _, attachment := range volume.Attachments {
+		if aws.StringValue(attachment.State) == ec2.VolumeAttachmentStateAttached {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package ebs
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// BackupKeyTag identifies the logical backup a volume belongs to. It is propagated onto
+// snapshots, and retention counts and deletes across all snapshots with the same key, so
+// that a rebuilt instance's new volume continues the retention of the volume it replaced.
+const BackupKeyTag = "ebs_snapshotter:backup_key"
+
+// backupKey returns the volume's BackupKeyTag value. If it has none and
+// BackupKeyFromNameAndDevice is set, its Name tag and attachment device are used.
+// An empty key groups snapshots by volume ID.
+func (mgr *SnapshotManager) backupKey(volume *ec2.Volume) string {
+	for _, tag := range volume.Tags {
+		if *tag.Key == BackupKeyTag && aws.StringValue(tag.Value) != "" {
+			return *tag.Value
+		}
+	}
+
+	if mgr.BackupKeyFromNameAndDevice && len(volume.Attachments) > 0 {
+		name := volumeName(volume)
+		device := aws.StringValue(volume.Attachments[0].Device)
+		if name != "" && device != "" {
+			return name + ":" + device
+		}
+	}
+	return ""
+}
+
+// describeBackupKeySnapshots returns the snapshots owned by the account tagged with the backup key
+func (mgr *SnapshotManager) describeBackupKeySnapshots(key string) ([]*ec2.Snapshot, error) {
+	params := &ec2.DescribeSnapshotsInput{
+		OwnerIds: []*string{aws.String("self")},
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag:" + BackupKeyTag),
+				Values: []*string{aws.String(key)},
+			},
+		},
+	}
+
+	var snapshots []*ec2.Snapshot
+	err := mgr.ec2.DescribeSnapshotsPages(params, func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+		snapshots = append(snapshots, page.Snapshots...)
+		return true
+	})
+	return snapshots, err
+}
+
+// mergeSnapshots returns the snapshots in a and b, without duplicates
+func mergeSnapshots(a []*ec2.Snapshot, b []*ec2.Snapshot) []*ec2.Snapshot {
+	seen := make(map[string]bool, len(a))
+	merged := make([]*ec2.Snapshot, 0, len(a)+len(b))
+	for _, snapshot := range append(a, b...) {
+		if !seen[*snapshot.SnapshotId] {
+			seen[*snapshot.SnapshotId] = true
+			merged = append(merged, snapshot)
+		}
+	}
+	return merged
+}
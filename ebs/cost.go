@@ -0,0 +1,217 @@
+package ebs
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// DefaultSnapshotPrice is the standard tier price of EBS snapshot storage in USD per
+// GiB-month in us-east-1
+const DefaultSnapshotPrice = 0.05
+
+// CostOptions configures EstimateCost
+type CostOptions struct {
+	// Price of snapshot storage in USD per GiB-month in the SnapshotManager's region
+	Price float64
+
+	// Fraction of a volume's size stored by each of its snapshots after the oldest, which
+	// is stored in full. Snapshots are incremental, so 1 estimates the upper bound.
+	ChangeRate float64
+
+	// Volume tag to group costs by, such as CostCenter. Snapshot tags are used for
+	// volumes that no longer exist.
+	TagKey string
+
+	// Retention policy to project costs for in place of NumSnapshotsToRetain, or 0 to
+	// skip the projection
+	ProposedRetain int
+}
+
+// VolumeCost is the estimated monthly snapshot storage cost of a volume
+type VolumeCost struct {
+	VolumeID string `json:"volume_id"`
+	Name     string `json:"name,omitempty"`
+
+	// Value of the volume's CostOptions.TagKey tag, if any
+	Tag string `json:"tag,omitempty"`
+
+	// Whether the volume still exists and the SnapshotManager snapshots it
+	Managed bool `json:"managed"`
+
+	SizeGiB   int64   `json:"size_gib"`
+	Snapshots int     `json:"snapshots"`
+	StoredGiB float64 `json:"stored_gib"`
+	Monthly   float64 `json:"monthly"`
+
+	// The steady state under CostOptions.ProposedRetain, if set
+	ProposedSnapshots int     `json:"proposed_snapshots,omitempty"`
+	ProposedStoredGiB float64 `json:"proposed_stored_gib,omitempty"`
+	ProposedMonthly   float64 `json:"proposed_monthly,omitempty"`
+}
+
+// CostEstimate is the estimated monthly snapshot storage cost of a region
+type CostEstimate struct {
+	Region         string  `json:"region"`
+	Price          float64 `json:"price"`
+	ChangeRate     float64 `json:"change_rate"`
+	ProposedRetain int     `json:"proposed_retain,omitempty"`
+
+	Snapshots       int     `json:"snapshots"`
+	Monthly         float64 `json:"monthly"`
+	ProposedMonthly float64 `json:"proposed_monthly,omitempty"`
+
+	Volumes []VolumeCost `json:"volumes"`
+}
+
+// EstimateCost estimates the monthly storage cost of the snapshots owned by the account in
+// the SnapshotManager's region, by volume. Snapshots of volumes that no longer exist are
+// included, as they are still billed. If opts.ProposedRetain is set, the snapshots are also
+// projected under that retention policy: the snapshots Run would delete from the retention
+// group of each volume the SnapshotManager snapshots are removed, including those of earlier
+// volumes with the same backup key and their pending encrypted copies, and the volumes grow
+// to the new count, or to the retention floor. Other snapshots keep their current cost.
+func (mgr *SnapshotManager) EstimateCost(opts CostOptions) (*CostEstimate, error) {
+	var volumes []*ec2.Volume
+	err := mgr.ec2.DescribeVolumesPages(&ec2.DescribeVolumesInput{}, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		volumes = append(volumes, page.Volumes...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	owned, err := mgr.describeOwnedSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	snapshotsByVolume := owned.byVolume
+
+	estimate := &CostEstimate{
+		Region:         mgr.Region,
+		Price:          opts.Price,
+		ChangeRate:     opts.ChangeRate,
+		ProposedRetain: opts.ProposedRetain,
+	}
+
+	// managed volumes grow to the proposed retention, or to the retention floor if it is higher
+	target := opts.ProposedRetain
+	if floorCount > target {
+		target = floorCount
+	}
+
+	volumesByID := make(map[string]*ec2.Volume, len(volumes))
+	for _, volume := range volumes {
+		volumesByID[*volume.VolumeId] = volume
+	}
+
+	// the projection applies Run's retention to each managed volume's retention group, which
+	// can include the snapshots of earlier volumes, so deletions are collected by snapshot ID
+	// before the snapshots are billed to the volumes they were taken from
+	projected := map[string]bool{}
+	retained := map[string]int{}
+	if opts.ProposedRetain > 0 {
+		for _, volume := range volumes {
+			if !mgr.manages(volume) {
+				continue
+			}
+			group := owned.group(volume, mgr.backupKey(volume))
+			expired, kept := mgr.projectedDeletions(group, opts.ProposedRetain)
+			for id := range expired {
+				projected[id] = true
+			}
+			retained[*volume.VolumeId] = kept
+		}
+	}
+
+	volumeIDs := make([]string, 0, len(snapshotsByVolume))
+	for volumeID := range snapshotsByVolume {
+		volumeIDs = append(volumeIDs, volumeID)
+	}
+	sort.Strings(volumeIDs)
+
+	for _, volumeID := range volumeIDs {
+		snapshots := snapshotsByVolume[volumeID]
+		sort.Sort(ByStartTime(snapshots))
+		newest := snapshots[len(snapshots)-1]
+
+		vc := VolumeCost{
+			VolumeID:  volumeID,
+			SizeGiB:   aws.Int64Value(newest.VolumeSize),
+			Snapshots: len(snapshots),
+			StoredGiB: storedGiB(snapshots, opts.ChangeRate),
+		}
+
+		tags := newest.Tags
+		if volume, ok := volumesByID[volumeID]; ok {
+			tags = volume.Tags
+			vc.Name = volumeName(volume)
+			vc.SizeGiB = aws.Int64Value(volume.Size)
+			vc.Managed = mgr.manages(volume)
+		}
+		if opts.TagKey != "" {
+			vc.Tag = tagValue(tags, opts.TagKey)
+		}
+		vc.Monthly = vc.StoredGiB * opts.Price
+
+		if opts.ProposedRetain > 0 {
+			kept := withoutSnapshots(snapshots, projected)
+			vc.ProposedSnapshots = len(kept)
+			vc.ProposedStoredGiB = storedGiB(kept, opts.ChangeRate)
+			if count, ok := retained[volumeID]; ok && count < target {
+				vc.ProposedSnapshots += target - count
+				vc.ProposedStoredGiB += float64(target-count) * float64(vc.SizeGiB) * opts.ChangeRate
+			}
+			vc.ProposedMonthly = vc.ProposedStoredGiB * opts.Price
+		}
+
+		estimate.Snapshots += vc.Snapshots
+		estimate.Monthly += vc.Monthly
+		estimate.ProposedMonthly += vc.ProposedMonthly
+		estimate.Volumes = append(estimate.Volumes, vc)
+	}
+
+	return estimate, nil
+}
+
+// manages returns true if the SnapshotManager snapshots the volume
+func (mgr *SnapshotManager) manages(volume *ec2.Volume) bool {
+	unattached := !isAttached(volume) && !(mgr.IncludeUnattached && aws.StringValue(volume.State) == ec2.VolumeStateAvailable)
+	return !unattached && !isExcluded(volume)
+}
+
+// withoutSnapshots returns the snapshots whose IDs are not in ids, in the same order
+func withoutSnapshots(snapshots []*ec2.Snapshot, ids map[string]bool) []*ec2.Snapshot {
+	var remaining []*ec2.Snapshot
+	for _, snapshot := range snapshots {
+		if !ids[*snapshot.SnapshotId] {
+			remaining = append(remaining, snapshot)
+		}
+	}
+	return remaining
+}
+
+// storedGiB estimates the storage used by a volume's snapshots, sorted oldest first. The
+// oldest is stored in full and each later snapshot stores changeRate of the volume's size.
+func storedGiB(snapshots []*ec2.Snapshot, changeRate float64) float64 {
+	var stored float64
+	for i, snapshot := range snapshots {
+		size := float64(aws.Int64Value(snapshot.VolumeSize))
+		if i > 0 {
+			size *= changeRate
+		}
+		stored += size
+	}
+	return stored
+}
+
+// tagValue returns the value of the tag with the given key, or "" if there is none
+func tagValue(tags []*ec2.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
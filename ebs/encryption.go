@@ -0,0 +1,177 @@
+package ebs
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// SourceVolumeTag records the volume an encrypted copy of a snapshot was taken from, as
+// copied snapshots don't keep the source snapshot's volume ID
+const SourceVolumeTag = "ebs_snapshotter:volume_id"
+
+// SourceSnapshotTag records the unencrypted snapshot an encrypted copy was made from, which
+// is deleted once the copy completes
+const SourceSnapshotTag = "ebs_snapshotter:source_snapshot"
+
+// encryptSnapshots replaces the volume's unencrypted snapshots with encrypted copies using
+// KmsKeyID, or the account's default EBS key if it is empty, without waiting for snapshots to
+// complete. Each completed snapshot without a copy is copied and the copy is tagged like the
+// original, and each snapshot whose copy has completed is deleted unless the retention floor
+// keeps it. An unencrypted snapshot therefore normally exists until the second run after the
+// one that took it: the next run copies it once it has completed, and the run after that
+// deletes it once the copy has completed. Slow snapshots or copies, and a compiled in minimum
+// retention age, keep it longer.
+func (mgr *SnapshotManager) encryptSnapshots(volume *ec2.Volume) error {
+	snapshots, err := mgr.describeSnapshots(volume)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	copies := copiesBySource(snapshots)
+	for _, snapshot := range snapshots {
+		if aws.BoolValue(snapshot.Encrypted) || aws.StringValue(snapshot.VolumeId) != *volume.VolumeId || !isCompleted(snapshot) {
+			continue
+		}
+
+		copied, ok := copies[*snapshot.SnapshotId]
+		if !ok {
+			if _, err := mgr.copySnapshot(volume, snapshot); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !isCompleted(copied) {
+			continue
+		}
+
+		// the copy takes the original's place, so the floor's count is unchanged and only its
+		// minimum age can keep the original
+		replaced := withoutSnapshots(snapshots, map[string]bool{*snapshot.SnapshotId: true})
+		if floorProtected(replaced, snapshot, now) {
+			mgr.floorKept(snapshot, "its encrypted copy")
+			continue
+		}
+
+		mgr.observe(func(o Observer) { o.OnSnapshotReplaced(mgr.Region, snapshot, *copied.SnapshotId) })
+		if _, err := mgr.deleteSnapshot(snapshot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copySnapshot starts an encrypted copy of a completed snapshot, tagged like the original plus
+// SourceVolumeTag and SourceSnapshotTag, and returns the pending copy
+func (mgr *SnapshotManager) copySnapshot(volume *ec2.Volume, snapshot *ec2.Snapshot) (*ec2.Snapshot, error) {
+	params := &ec2.CopySnapshotInput{
+		SourceRegion:     aws.String(mgr.Region),
+		SourceSnapshotId: snapshot.SnapshotId,
+		Description:      snapshot.Description,
+		Encrypted:        aws.Bool(true),
+	}
+	if mgr.KmsKeyID != "" {
+		params.KmsKeyId = aws.String(mgr.KmsKeyID)
+	}
+
+	resp, err := mgr.ec2.CopySnapshot(params)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := &ec2.Snapshot{
+		SnapshotId: resp.SnapshotId,
+		VolumeId:   snapshot.VolumeId,
+		StartTime:  aws.Time(time.Now()),
+		State:      aws.String(ec2.SnapshotStatePending),
+		Encrypted:  aws.Bool(true),
+	}
+	mgr.observe(func(o Observer) { o.OnSnapshotCopied(mgr.Region, volume, snapshot, *copied.SnapshotId) })
+	mgr.observe(func(o Observer) { o.OnSnapshotCreated(mgr.Region, volume, copied) })
+
+	tags := append([]*ec2.Tag{}, filterReservedTags(snapshot.Tags)...)
+	tags = setTag(tags, SourceVolumeTag, *volume.VolumeId)
+	tags = setTag(tags, SourceSnapshotTag, *snapshot.SnapshotId)
+	copied.Tags = tags
+	return copied, mgr.tagSnapshot(copied.SnapshotId, tags)
+}
+
+// withoutPendingCopies returns the snapshots without the encrypted copies of snapshots that
+// are still present, so that the retention policy counts each pair once, and those copies by
+// the ID of their source
+func withoutPendingCopies(snapshots []*ec2.Snapshot) ([]*ec2.Snapshot, map[string]*ec2.Snapshot) {
+	present := snapshotIDSet(snapshots)
+	pending := map[string]*ec2.Snapshot{}
+
+	var remaining []*ec2.Snapshot
+	for _, snapshot := range snapshots {
+		if source := tagValue(snapshot.Tags, SourceSnapshotTag); present[source] {
+			pending[source] = snapshot
+			continue
+		}
+		remaining = append(remaining, snapshot)
+	}
+	return remaining, pending
+}
+
+// copiesBySource returns the encrypted copies among the snapshots by the ID of the snapshot
+// they were copied from
+func copiesBySource(snapshots []*ec2.Snapshot) map[string]*ec2.Snapshot {
+	copies := map[string]*ec2.Snapshot{}
+	for _, snapshot := range snapshots {
+		if source := tagValue(snapshot.Tags, SourceSnapshotTag); source != "" {
+			copies[source] = snapshot
+		}
+	}
+	return copies
+}
+
+// isCompleted returns true if the snapshot has completed
+func isCompleted(snapshot *ec2.Snapshot) bool {
+	return aws.StringValue(snapshot.State) == ec2.SnapshotStateCompleted
+}
+
+// describeCopiedSnapshots returns the encrypted copies of the volume's snapshots
+func (mgr *SnapshotManager) describeCopiedSnapshots(volumeID string) ([]*ec2.Snapshot, error) {
+	params := &ec2.DescribeSnapshotsInput{
+		OwnerIds: []*string{aws.String("self")},
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag:" + SourceVolumeTag),
+				Values: []*string{aws.String(volumeID)},
+			},
+		},
+	}
+
+	var snapshots []*ec2.Snapshot
+	err := mgr.ec2.DescribeSnapshotsPages(params, func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+		snapshots = append(snapshots, page.Snapshots...)
+		return true
+	})
+	return snapshots, err
+}
+
+// unencryptedSnapshots returns the IDs of the snapshots that are not encrypted
+func unencryptedSnapshots(snapshots []*ec2.Snapshot) []string {
+	var ids []string
+	for _, snapshot := range snapshots {
+		if !aws.BoolValue(snapshot.Encrypted) {
+			ids = append(ids, *snapshot.SnapshotId)
+		}
+	}
+	return ids
+}
+
+// sourceVolumeID returns the ID of the volume a snapshot was taken from, following the
+// SourceVolumeTag of encrypted copies
+func sourceVolumeID(snapshot *ec2.Snapshot) string {
+	for _, tag := range snapshot.Tags {
+		if *tag.Key == SourceVolumeTag {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return aws.StringValue(snapshot.VolumeId)
+}
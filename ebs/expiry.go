@@ -0,0 +1,124 @@
+package ebs
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ExpiresAtTag is added to snapshots when RetentionPeriod is set, with the RFC3339 time
+// after which PruneExpired deletes the snapshot
+const ExpiresAtTag = "ebs_snapshotter:expires_at"
+
+// PruneExpired deletes the snapshots owned by the account whose ExpiresAtTag time is before
+// now, regardless of which volume they belong to or whether it still exists. If dryRun is
+// set, the expired snapshots are returned without being deleted. Snapshots in use, such as
+// those backing AMIs, and snapshots protected by the retention floor are skipped.
+func (mgr *SnapshotManager) PruneExpired(now time.Time, dryRun bool) (expired []string, err error) {
+	params := &ec2.DescribeSnapshotsInput{
+		OwnerIds: []*string{aws.String("self")},
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []*string{aws.String(ExpiresAtTag)},
+			},
+		},
+	}
+
+	var candidates []*ec2.Snapshot
+	err = mgr.ec2.DescribeSnapshotsPages(params, func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+		for _, snapshot := range page.Snapshots {
+			if expiresAt, ok := snapshotExpiry(snapshot); ok && expiresAt.Before(now) {
+				candidates = append(candidates, snapshot)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]*ec2.Snapshot)
+	for _, snapshot := range candidates {
+		protected, err := mgr.pruneFloorProtected(snapshot, groups, now)
+		if err != nil {
+			return expired, err
+		}
+		if protected {
+			mgr.floorKept(snapshot, "its expiry tag")
+			continue
+		}
+
+		if dryRun {
+			expired = append(expired, *snapshot.SnapshotId)
+			continue
+		}
+
+		mgr.observe(func(o Observer) { o.OnSnapshotExpired(mgr.Region, snapshot) })
+
+		deleted, err := mgr.deleteSnapshot(snapshot)
+		if err != nil {
+			return expired, err
+		}
+		if deleted {
+			expired = append(expired, *snapshot.SnapshotId)
+		}
+	}
+
+	return expired, nil
+}
+
+// snapshotExpiry returns the time in the snapshot's ExpiresAtTag, if it has a valid one
+func snapshotExpiry(snapshot *ec2.Snapshot) (time.Time, bool) {
+	for _, tag := range snapshot.Tags {
+		if *tag.Key != ExpiresAtTag {
+			continue
+		}
+
+		expiresAt, err := time.Parse(time.RFC3339, aws.StringValue(tag.Value))
+		if err != nil {
+			log.Printf("Ignoring invalid %s tag %q on snapshot %s", ExpiresAtTag, aws.StringValue(tag.Value), *snapshot.SnapshotId)
+			return time.Time{}, false
+		}
+		return expiresAt, true
+	}
+	return time.Time{}, false
+}
+
+// unexpired returns true if the snapshot has an ExpiresAtTag time after now
+func unexpired(snapshot *ec2.Snapshot, now time.Time) bool {
+	expiresAt, ok := snapshotExpiry(snapshot)
+	return ok && expiresAt.After(now)
+}
+
+// pruneFloorProtected returns true if the retention floor protects an expired snapshot. The
+// snapshots of its volume, or of its backup key, are described once and cached in groups.
+func (mgr *SnapshotManager) pruneFloorProtected(snapshot *ec2.Snapshot, groups map[string][]*ec2.Snapshot, now time.Time) (bool, error) {
+	if floorCount == 0 {
+		return floorProtected(nil, snapshot, now), nil
+	}
+
+	volume := &ec2.Volume{VolumeId: aws.String(sourceVolumeID(snapshot))}
+	for _, tag := range snapshot.Tags {
+		if *tag.Key == BackupKeyTag {
+			volume.Tags = []*ec2.Tag{tag}
+		}
+	}
+
+	group := *volume.VolumeId
+	if key := mgr.backupKey(volume); key != "" {
+		group = BackupKeyTag + "=" + key
+	}
+
+	snapshots, ok := groups[group]
+	if !ok {
+		var err error
+		if snapshots, err = mgr.describeSnapshots(volume); err != nil {
+			return false, err
+		}
+		groups[group] = snapshots
+	}
+	return floorProtected(snapshots, snapshot, now), nil
+}
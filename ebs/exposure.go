@@ -0,0 +1,159 @@
+package ebs
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+)
+
+// SnapshotExposure describes a snapshot whose createVolumePermission allows it to be restored
+// outside the account
+type SnapshotExposure struct {
+	SnapshotID string `json:"snapshot_id"`
+	VolumeID   string `json:"volume_id"`
+
+	// Whether the snapshot is shared with the "all" group, making it public
+	Public bool `json:"public"`
+
+	// IDs of the accounts the snapshot is shared with that are not approved
+	Accounts []string `json:"accounts,omitempty"`
+
+	// Whether the exposing permissions were removed
+	Remediated bool `json:"remediated"`
+
+	// A description of the error encountered checking or remediating the snapshot, if any
+	Error string `json:"error,omitempty"`
+}
+
+// ExposureOptions configures AuditExposure
+type ExposureOptions struct {
+	// IDs of the accounts snapshots may be shared with
+	ApprovedAccounts []string
+
+	// Whether to remove the exposing permissions
+	Remediate bool
+
+	// Whether to check every snapshot owned by the account, rather than only the snapshots of
+	// the volumes the SnapshotManager snapshots and those tagged by it
+	AllSnapshots bool
+}
+
+// AuditExposure checks the createVolumePermission of the snapshots the SnapshotManager manages,
+// or of every snapshot owned by the account with AllSnapshots set, and returns those that are
+// public or shared with accounts not in ApprovedAccounts. Managed snapshots are those of the
+// volumes Run would snapshot and those tagged with BackupKeyTag, SourceVolumeTag or
+// FinalSnapshotTag. If Remediate is set, the exposing permissions are removed: snapshots with
+// no approved shares are reset to private with ResetSnapshotAttribute, otherwise only the
+// public and unapproved shares are removed.
+func (mgr *SnapshotManager) AuditExposure(opts ExposureOptions) ([]SnapshotExposure, error) {
+	var managed map[string]bool
+	if !opts.AllSnapshots {
+		volumes, err := mgr.describeVolumes(nil)
+		if err != nil {
+			return nil, err
+		}
+		managed = map[string]bool{}
+		for _, volume := range volumes {
+			if !isExcluded(volume) {
+				managed[*volume.VolumeId] = true
+			}
+		}
+	}
+
+	var snapshots []*ec2.Snapshot
+	params := &ec2.DescribeSnapshotsInput{OwnerIds: []*string{aws.String("self")}}
+	err := mgr.ec2.DescribeSnapshotsPages(params, func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+		for _, snapshot := range page.Snapshots {
+			if opts.AllSnapshots || managed[sourceVolumeID(snapshot)] || isManagedSnapshot(snapshot) {
+				snapshots = append(snapshots, snapshot)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var exposures []SnapshotExposure
+	for _, snapshot := range snapshots {
+		resp, err := mgr.ec2.DescribeSnapshotAttribute(&ec2.DescribeSnapshotAttributeInput{
+			SnapshotId: snapshot.SnapshotId,
+			Attribute:  aws.String(ec2.SnapshotAttributeNameCreateVolumePermission),
+		})
+		if awserror.HasCode(err, "InvalidSnapshot.NotFound") {
+			continue
+		}
+		if err != nil {
+			return exposures, err
+		}
+
+		exposure := SnapshotExposure{SnapshotID: *snapshot.SnapshotId, VolumeID: sourceVolumeID(snapshot)}
+		approvedShares := false
+		for _, permission := range resp.CreateVolumePermissions {
+			switch {
+			case aws.StringValue(permission.Group) == ec2.PermissionGroupAll:
+				exposure.Public = true
+			case permission.UserId == nil:
+			case contains(opts.ApprovedAccounts, *permission.UserId):
+				approvedShares = true
+			default:
+				exposure.Accounts = append(exposure.Accounts, *permission.UserId)
+			}
+		}
+
+		if !exposure.Public && len(exposure.Accounts) == 0 {
+			continue
+		}
+
+		mgr.observe(func(o Observer) { o.OnSnapshotExposed(mgr.Region, exposure) })
+
+		if opts.Remediate {
+			if err := mgr.removeExposure(exposure, approvedShares); err != nil {
+				mgr.observeError(nil, err)
+				exposure.Error = err.Error()
+			} else {
+				exposure.Remediated = true
+			}
+		}
+		exposures = append(exposures, exposure)
+	}
+
+	return exposures, nil
+}
+
+// isManagedSnapshot returns true if the snapshot has a tag only the SnapshotManager adds to
+// the snapshots it manages
+func isManagedSnapshot(snapshot *ec2.Snapshot) bool {
+	return hasTag(snapshot.Tags, BackupKeyTag) || hasTag(snapshot.Tags, SourceVolumeTag) || hasTag(snapshot.Tags, FinalSnapshotTag)
+}
+
+// removeExposure removes the public and unapproved shares of a snapshot, resetting its
+// createVolumePermission entirely if it has no approved shares to keep
+func (mgr *SnapshotManager) removeExposure(exposure SnapshotExposure, approvedShares bool) error {
+	mgr.observe(func(o Observer) { o.OnExposureRemediating(mgr.Region, exposure) })
+
+	if !approvedShares {
+		_, err := mgr.ec2.ResetSnapshotAttribute(&ec2.ResetSnapshotAttributeInput{
+			SnapshotId: aws.String(exposure.SnapshotID),
+			Attribute:  aws.String(ec2.SnapshotAttributeNameCreateVolumePermission),
+		})
+		return err
+	}
+
+	var remove []*ec2.CreateVolumePermission
+	if exposure.Public {
+		remove = append(remove, &ec2.CreateVolumePermission{Group: aws.String(ec2.PermissionGroupAll)})
+	}
+	for _, account := range exposure.Accounts {
+		remove = append(remove, &ec2.CreateVolumePermission{UserId: aws.String(account)})
+	}
+
+	_, err := mgr.ec2.ModifySnapshotAttribute(&ec2.ModifySnapshotAttributeInput{
+		SnapshotId: aws.String(exposure.SnapshotID),
+		Attribute:  aws.String(ec2.SnapshotAttributeNameCreateVolumePermission),
+		CreateVolumePermission: &ec2.CreateVolumePermissionModifications{
+			Remove: remove,
+		},
+	})
+	return err
+}
@@ -0,0 +1,78 @@
+package ebs
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The minimum retention floor, compiled into the binary so that it can't be lowered at run
+// time by flags, tags or library callers. No deletion made by this package ever leaves fewer
+// than minRetainCount snapshots of a volume or deletes a snapshot younger than minRetainAge,
+// whatever NumSnapshotsToRetain or a snapshot's ExpiresAtTag says. Both default to a week of
+// daily snapshots kept for five weeks, and can be changed at build time with the linker, e.g.:
+//
+//	go build -ldflags "-X github.com/healthcareblocks/ebs_snapshotter/ebs.minRetainCount=14
+//	  -X github.com/healthcareblocks/ebs_snapshotter/ebs.minRetainAge=1680h"
+var (
+	minRetainCount = "7"
+	minRetainAge   = "840h"
+)
+
+// The parsed retention floor. If it is invalid, NewSnapshotManager returns floorErr.
+var (
+	floorCount int
+	floorAge   time.Duration
+	floorErr   error
+)
+
+func init() {
+	floorCount, floorAge, floorErr = parseFloor(minRetainCount, minRetainAge)
+}
+
+// parseFloor parses the compiled in retention floor
+func parseFloor(count string, age string) (int, time.Duration, error) {
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return 0, 0, fmt.Errorf("invalid compiled in minimum retention count %q", count)
+	}
+	d, err := time.ParseDuration(age)
+	if err != nil || d < 0 {
+		return 0, 0, fmt.Errorf("invalid compiled in minimum retention age %q", age)
+	}
+	return n, d, nil
+}
+
+// RetentionFloor returns the minimum number of snapshots kept per volume and the minimum age
+// of a deleted snapshot, compiled into the binary
+func RetentionFloor() (count int, age time.Duration) {
+	return floorCount, floorAge
+}
+
+// floorProtected returns true if deleting the snapshot would break the retention floor: it
+// is one of the floorCount newest snapshots, or it was started less than floorAge before now.
+// The snapshots must be sorted oldest first.
+func floorProtected(snapshots []*ec2.Snapshot, snapshot *ec2.Snapshot, now time.Time) bool {
+	if floorAge > 0 && now.Sub(aws.TimeValue(snapshot.StartTime)) < floorAge {
+		return true
+	}
+
+	for i := len(snapshots) - 1; i >= 0 && i >= len(snapshots)-floorCount; i-- {
+		if *snapshots[i].SnapshotId == *snapshot.SnapshotId {
+			return true
+		}
+	}
+	return false
+}
+
+// floorKept reports an attempt to delete a snapshot protected by the retention floor
+func (mgr *SnapshotManager) floorKept(snapshot *ec2.Snapshot, reason string) {
+	mgr.observe(func(o Observer) { o.OnFloorKept(mgr.Region, snapshot, reason) })
+}
+
+func floorDescription() string {
+	return fmt.Sprintf("at least %d snapshots and %s", floorCount, floorAge)
+}
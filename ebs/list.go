@@ -0,0 +1,69 @@
+package ebs
+
+import (
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// ListedSnapshot is a snapshot along with what the retention policy would do with it
+type ListedSnapshot struct {
+	*ec2.Snapshot
+
+	// Whether the snapshot would be deleted the next time retention is applied
+	Expired bool `json:"expired"`
+}
+
+// VolumeSnapshots lists the snapshots of a volume, oldest first
+type VolumeSnapshots struct {
+	VolumeID  string           `json:"volume_id"`
+	Name      string           `json:"name,omitempty"`
+	Snapshots []ListedSnapshot `json:"snapshots"`
+}
+
+// ListSnapshots returns the snapshots of the volumes the SnapshotManager snapshots, marking
+// those the retention policy would delete as expired. Nothing is deleted or reported to the
+// Observers. If volumeIDs is not empty, only those
+// volumes are listed.
+func (mgr *SnapshotManager) ListSnapshots(volumeIDs []string) ([]VolumeSnapshots, error) {
+	if err := mgr.validate(); err != nil {
+		return nil, err
+	}
+
+	volumes, err := mgr.describeVolumes(volumeIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var listings []VolumeSnapshots
+	for _, volume := range volumes {
+		if isExcluded(volume) {
+			continue
+		}
+
+		snapshots, err := mgr.describeSnapshots(volume)
+		if err != nil {
+			return nil, err
+		}
+
+		// the prediction uses the same snapshots as Run, without reporting the snapshots the
+		// retention floor would keep. Retention keeps some of the oldest snapshots, such as
+		// final snapshots, so expired snapshots are matched by ID rather than position.
+		expired, _ := mgr.projectedDeletions(snapshots, mgr.NumSnapshotsToRetain)
+
+		listing := VolumeSnapshots{VolumeID: *volume.VolumeId, Name: volumeName(volume)}
+		for _, snapshot := range snapshots {
+			listing.Snapshots = append(listing.Snapshots, ListedSnapshot{Snapshot: snapshot, Expired: expired[*snapshot.SnapshotId]})
+		}
+		listings = append(listings, listing)
+	}
+
+	return listings, nil
+}
+
+// snapshotIDSet returns the IDs of the snapshots as a set
+func snapshotIDSet(snapshots []*ec2.Snapshot) map[string]bool {
+	ids := make(map[string]bool, len(snapshots))
+	for _, snapshot := range snapshots {
+		ids[*snapshot.SnapshotId] = true
+	}
+	return ids
+}
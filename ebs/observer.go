@@ -0,0 +1,247 @@
+package ebs
+
+import (
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+	"github.com/healthcareblocks/ebs_snapshotter/report"
+)
+
+// Observer receives the lifecycle events of a SnapshotManager, so that applications can feed
+// their own metrics, tracing or UI. Callbacks are called synchronously from the goroutine
+// running the SnapshotManager, so they shouldn't block. Embed NopObserver to implement only
+// some of them.
+type Observer interface {
+	// OnVolumeSelected is called before a volume is snapshotted
+	OnVolumeSelected(region string, volume *ec2.Volume)
+
+	// OnVolumeSkipped is called for a volume that is not snapshotted, with the reason
+	OnVolumeSkipped(region string, volume *ec2.Volume, reason string)
+
+	// OnSnapshotCreated is called once a snapshot of the volume has started, including
+	// encrypted copies made when RequireEncryption is set
+	OnSnapshotCreated(region string, volume *ec2.Volume, snapshot *ec2.Snapshot)
+
+	// OnSnapshotTagged is called once tags are added to a new snapshot
+	OnSnapshotTagged(region string, snapshotID string, tags []*ec2.Tag)
+
+	// OnSnapshotCopied is called once an encrypted copy of an unencrypted snapshot has started,
+	// when RequireEncryption is set
+	OnSnapshotCopied(region string, volume *ec2.Volume, snapshot *ec2.Snapshot, copyID string)
+
+	// OnSnapshotReplaced is called before an unencrypted snapshot is deleted because its
+	// encrypted copy has completed
+	OnSnapshotReplaced(region string, snapshot *ec2.Snapshot, copyID string)
+
+	// OnSnapshotExpired is called before PruneExpired deletes a snapshot past its ExpiresAtTag
+	OnSnapshotExpired(region string, snapshot *ec2.Snapshot)
+
+	// OnSnapshotDeleted is called once a snapshot is deleted by the retention policy, by
+	// PruneExpired or because it was replaced by an encrypted copy
+	OnSnapshotDeleted(region string, snapshot *ec2.Snapshot)
+
+	// OnFloorKept is called for a snapshot that the retention floor keeps, with the policy
+	// that would have deleted it
+	OnFloorKept(region string, snapshot *ec2.Snapshot, reason string)
+
+	// OnSnapshotExposed is called for each snapshot AuditExposure finds public or shared with
+	// unapproved accounts
+	OnSnapshotExposed(region string, exposure SnapshotExposure)
+
+	// OnExposureRemediating is called before the exposing permissions of a snapshot are removed
+	OnExposureRemediating(region string, exposure SnapshotExposure)
+
+	// OnVolumeRestoring is called before a volume is created from the snapshot
+	OnVolumeRestoring(region string, snapshot *ec2.Snapshot, availabilityZone string)
+
+	// OnVolumeAttaching is called before a restored volume is attached to an instance
+	OnVolumeAttaching(region string, volume *ec2.Volume, instanceID string, device string)
+
+	// OnSnapshotVerifying is called before a snapshot of the volume is restored by Verify
+	OnSnapshotVerifying(region string, volume *ec2.Volume, snapshot *ec2.Snapshot)
+
+	// OnVolumeReaping is called before the final snapshot of an unattached volume is taken
+	OnVolumeReaping(region string, volume *ec2.Volume)
+
+	// OnVolumeDeleting is called before an unattached volume is deleted by ReapUnattached or
+	// a temporary volume is deleted by Verify, with "unattached" or "temporary" as the kind
+	OnVolumeDeleting(region string, volume *ec2.Volume, kind string)
+
+	// OnError is called for each error that is recorded or logged rather than returned. The
+	// volume is nil if the error doesn't concern a single volume.
+	OnError(region string, volume *ec2.Volume, err error)
+
+	// OnRunCompleted is called with the report of each Run or Verify once it completes
+	OnRunCompleted(result *report.Region)
+}
+
+// NopObserver ignores every event. Embed it in an Observer that only handles some events.
+type NopObserver struct{}
+
+// OnVolumeSelected does nothing
+func (NopObserver) OnVolumeSelected(region string, volume *ec2.Volume) {}
+
+// OnVolumeSkipped does nothing
+func (NopObserver) OnVolumeSkipped(region string, volume *ec2.Volume, reason string) {}
+
+// OnSnapshotCreated does nothing
+func (NopObserver) OnSnapshotCreated(region string, volume *ec2.Volume, snapshot *ec2.Snapshot) {}
+
+// OnSnapshotTagged does nothing
+func (NopObserver) OnSnapshotTagged(region string, snapshotID string, tags []*ec2.Tag) {}
+
+// OnSnapshotCopied does nothing
+func (NopObserver) OnSnapshotCopied(region string, volume *ec2.Volume, snapshot *ec2.Snapshot, copyID string) {
+}
+
+// OnSnapshotReplaced does nothing
+func (NopObserver) OnSnapshotReplaced(region string, snapshot *ec2.Snapshot, copyID string) {}
+
+// OnSnapshotExpired does nothing
+func (NopObserver) OnSnapshotExpired(region string, snapshot *ec2.Snapshot) {}
+
+// OnSnapshotDeleted does nothing
+func (NopObserver) OnSnapshotDeleted(region string, snapshot *ec2.Snapshot) {}
+
+// OnFloorKept does nothing
+func (NopObserver) OnFloorKept(region string, snapshot *ec2.Snapshot, reason string) {}
+
+// OnSnapshotExposed does nothing
+func (NopObserver) OnSnapshotExposed(region string, exposure SnapshotExposure) {}
+
+// OnExposureRemediating does nothing
+func (NopObserver) OnExposureRemediating(region string, exposure SnapshotExposure) {}
+
+// OnVolumeRestoring does nothing
+func (NopObserver) OnVolumeRestoring(region string, snapshot *ec2.Snapshot, availabilityZone string) {
+}
+
+// OnVolumeAttaching does nothing
+func (NopObserver) OnVolumeAttaching(region string, volume *ec2.Volume, instanceID string, device string) {
+}
+
+// OnSnapshotVerifying does nothing
+func (NopObserver) OnSnapshotVerifying(region string, volume *ec2.Volume, snapshot *ec2.Snapshot) {}
+
+// OnVolumeReaping does nothing
+func (NopObserver) OnVolumeReaping(region string, volume *ec2.Volume) {}
+
+// OnVolumeDeleting does nothing
+func (NopObserver) OnVolumeDeleting(region string, volume *ec2.Volume, kind string) {}
+
+// OnError does nothing
+func (NopObserver) OnError(region string, volume *ec2.Volume, err error) {}
+
+// OnRunCompleted does nothing
+func (NopObserver) OnRunCompleted(result *report.Region) {}
+
+// LogObserver logs events with logrus. NewSnapshotManager adds it to Observers by default.
+type LogObserver struct {
+	NopObserver
+}
+
+// OnVolumeSelected logs that the volume's snapshot is starting
+func (LogObserver) OnVolumeSelected(region string, volume *ec2.Volume) {
+	log.Printf("Starting snapshot for %s in region %s", *volume.VolumeId, region)
+}
+
+// OnVolumeSkipped logs why the volume is skipped
+func (LogObserver) OnVolumeSkipped(region string, volume *ec2.Volume, reason string) {
+	log.Printf("Skipping volume %s in region %s, %s", *volume.VolumeId, region, reason)
+}
+
+// OnSnapshotCreated logs the new snapshot
+func (LogObserver) OnSnapshotCreated(region string, volume *ec2.Volume, snapshot *ec2.Snapshot) {
+	log.Printf("Created snapshot %s of %s in region %s", *snapshot.SnapshotId, *volume.VolumeId, region)
+}
+
+// OnSnapshotTagged logs the keys of the tags added to the snapshot
+func (LogObserver) OnSnapshotTagged(region string, snapshotID string, tags []*ec2.Tag) {
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = *tag.Key
+	}
+	log.Printf("Tagged snapshot %s in region %s with %s", snapshotID, region, strings.Join(keys, ", "))
+}
+
+// OnSnapshotCopied logs the encrypted copy
+func (LogObserver) OnSnapshotCopied(region string, volume *ec2.Volume, snapshot *ec2.Snapshot, copyID string) {
+	log.Printf("Copying snapshot %s of unencrypted volume %s to encrypted snapshot %s", *snapshot.SnapshotId, *volume.VolumeId, copyID)
+}
+
+// OnSnapshotReplaced logs the unencrypted snapshot that is being deleted
+func (LogObserver) OnSnapshotReplaced(region string, snapshot *ec2.Snapshot, copyID string) {
+	log.Printf("Deleting unencrypted snapshot %s, replaced by %s", *snapshot.SnapshotId, copyID)
+}
+
+// OnSnapshotExpired logs the expired snapshot that is being deleted
+func (LogObserver) OnSnapshotExpired(region string, snapshot *ec2.Snapshot) {
+	log.Printf("Deleting expired snapshot %s of %s in region %s", *snapshot.SnapshotId, aws.StringValue(snapshot.VolumeId), region)
+}
+
+// OnSnapshotDeleted logs the deleted snapshot
+func (LogObserver) OnSnapshotDeleted(region string, snapshot *ec2.Snapshot) {
+	log.Printf("Deleted snapshot %s of %s in region %s", *snapshot.SnapshotId, aws.StringValue(snapshot.VolumeId), region)
+}
+
+// OnFloorKept logs the snapshot kept by the retention floor
+func (LogObserver) OnFloorKept(region string, snapshot *ec2.Snapshot, reason string) {
+	log.Printf("Retention floor (%s) keeps snapshot %s of %s in region %s, which %s would delete",
+		floorDescription(), *snapshot.SnapshotId, aws.StringValue(snapshot.VolumeId), region, reason)
+}
+
+// OnSnapshotExposed logs how the snapshot is exposed
+func (LogObserver) OnSnapshotExposed(region string, exposure SnapshotExposure) {
+	log.Printf("Snapshot %s in region %s is exposed (public: %t, unapproved accounts: %v)",
+		exposure.SnapshotID, region, exposure.Public, exposure.Accounts)
+}
+
+// OnExposureRemediating logs that the snapshot's exposing permissions are being removed
+func (LogObserver) OnExposureRemediating(region string, exposure SnapshotExposure) {
+	log.Printf("Removing exposed permissions from snapshot %s in region %s", exposure.SnapshotID, region)
+}
+
+// OnVolumeRestoring logs the snapshot that is being restored
+func (LogObserver) OnVolumeRestoring(region string, snapshot *ec2.Snapshot, availabilityZone string) {
+	log.Printf("Restoring snapshot %s to a new volume in %s", *snapshot.SnapshotId, availabilityZone)
+}
+
+// OnVolumeAttaching logs where the restored volume is being attached
+func (LogObserver) OnVolumeAttaching(region string, volume *ec2.Volume, instanceID string, device string) {
+	log.Printf("Attaching volume %s to %s at %s", *volume.VolumeId, instanceID, device)
+}
+
+// OnSnapshotVerifying logs the snapshot that is being verified
+func (LogObserver) OnSnapshotVerifying(region string, volume *ec2.Volume, snapshot *ec2.Snapshot) {
+	log.Printf("Verifying snapshot %s of %s in region %s", *snapshot.SnapshotId, *volume.VolumeId, region)
+}
+
+// OnVolumeReaping logs that the volume's final snapshot is being taken
+func (LogObserver) OnVolumeReaping(region string, volume *ec2.Volume) {
+	log.Printf("Taking final snapshot of unattached volume %s in region %s", *volume.VolumeId, region)
+}
+
+// OnVolumeDeleting logs the volume that is being deleted
+func (LogObserver) OnVolumeDeleting(region string, volume *ec2.Volume, kind string) {
+	log.Printf("Deleting %s volume %s in region %s", kind, *volume.VolumeId, region)
+}
+
+// OnError logs the details of the error
+func (LogObserver) OnError(region string, volume *ec2.Volume, err error) {
+	awserror.LogError(err)
+}
+
+// observe calls the event on each of the SnapshotManager's observers
+func (mgr *SnapshotManager) observe(event func(observer Observer)) {
+	for _, observer := range mgr.Observers {
+		event(observer)
+	}
+}
+
+// observeError reports an error that is recorded or logged rather than returned
+func (mgr *SnapshotManager) observeError(volume *ec2.Volume, err error) {
+	mgr.observe(func(o Observer) { o.OnError(mgr.Region, volume, err) })
+}
@@ -0,0 +1,48 @@
+package ebs
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// Option configures the EC2 client of a SnapshotManager created by NewSnapshotManager.
+// Applications embedding this package use options to pass in custom credentials, retryers
+// or a mock client:
+//
+//	mgr, err := ebs.NewSnapshotManager("us-west-2", "", true, 5, false, ebs.WithSession(sess))
+type Option func(*clientOptions)
+
+// clientOptions holds the options applied by NewSnapshotManager
+type clientOptions struct {
+	api    ec2iface.EC2API
+	sess   *session.Session
+	config *aws.Config
+}
+
+// WithEC2 uses the given EC2 client, such as a mock, instead of creating one. The endpoint
+// and debug parameters of NewSnapshotManager and the other options are ignored. Audit
+// logging requires an *ec2.EC2 client.
+func WithEC2(api ec2iface.EC2API) Option {
+	return func(opts *clientOptions) {
+		opts.api = api
+	}
+}
+
+// WithSession creates the EC2 client from the given session, for example one configured
+// with custom credentials, instead of a new default session. The SnapshotManager's region,
+// endpoint, debug and MaxRetries settings are applied on top of the session's configuration.
+func WithSession(sess *session.Session) Option {
+	return func(opts *clientOptions) {
+		opts.sess = sess
+	}
+}
+
+// WithConfig merges the given configuration, such as credentials, an HTTP client or a
+// retryer, over the SnapshotManager's default configuration. Its region is ignored in favor
+// of the SnapshotManager's region.
+func WithConfig(config *aws.Config) Option {
+	return func(opts *clientOptions) {
+		opts.config = config
+	}
+}
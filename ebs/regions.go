@@ -0,0 +1,23 @@
+package ebs
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// EnabledRegions returns the names of the EC2 regions enabled for the account, sorted by name
+func (mgr *SnapshotManager) EnabledRegions() ([]string, error) {
+	resp, err := mgr.ec2.DescribeRegions(&ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(resp.Regions))
+	for i, region := range resp.Regions {
+		names[i] = aws.StringValue(region.RegionName)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,225 @@
+package ebs
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+)
+
+// RestoredFromTag is added to restored volumes with the ID of the snapshot they were created from
+const RestoredFromTag = "ebs_snapshotter:restored_from"
+
+// RestoreOptions describes which snapshot to restore and where to put the new volume
+type RestoreOptions struct {
+	// The snapshot to restore. If empty, the newest completed snapshot of VolumeID started
+	// at or before PointInTime is restored.
+	SnapshotID string
+
+	// The original volume, used to find a snapshot when SnapshotID is empty
+	VolumeID string
+
+	// Restore the newest snapshot at or before this time. Defaults to now if zero.
+	PointInTime time.Time
+
+	// The availability zone to create the volume in. This parameter is required.
+	AvailabilityZone string
+
+	// Overrides the type, provisioned IOPS and gp3 throughput (in MiB/s) of the original
+	// volume. If the original volume no longer exists and these are empty, the EC2 default
+	// volume type is used.
+	VolumeType string
+	Iops       int64
+	Throughput int64
+
+	// If set, the volume is attached to this instance at Device once it is available
+	InstanceID string
+	Device     string
+
+	// Additional tags for the new volume
+	Tags []*ec2.Tag
+
+	// Keys of the snapshot's tags that are not copied onto the new volume
+	OmitTags []string
+
+	// Whether to wait until the volume is available, or attached if InstanceID is set
+	Wait bool
+}
+
+// FindSnapshot returns the newest completed snapshot of a volume started at or before the given time
+func (mgr *SnapshotManager) FindSnapshot(volumeID string, at time.Time) (*ec2.Snapshot, error) {
+	snapshots, err := mgr.describeSnapshots(&ec2.Volume{VolumeId: aws.String(volumeID)})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		snapshot := snapshots[i]
+		if aws.StringValue(snapshot.State) == ec2.SnapshotStateCompleted && !snapshot.StartTime.After(at) {
+			return snapshot, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no completed snapshot of %s found at or before %s", volumeID, at.Format(time.RFC3339))
+}
+
+// RestoreVolume creates a new volume from a snapshot, matching the original volume's type,
+// size, IOPS, throughput and encryption, and copying the snapshot's tags onto it. It optionally waits
+// for the volume to become available and attaches it to an instance.
+func (mgr *SnapshotManager) RestoreVolume(opts RestoreOptions) (*ec2.Volume, error) {
+	if opts.AvailabilityZone == "" {
+		return nil, errors.New("availability zone is required")
+	}
+
+	snapshot, err := mgr.restoreSnapshot(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &ec2.CreateVolumeInput{
+		AvailabilityZone: aws.String(opts.AvailabilityZone),
+		SnapshotId:       snapshot.SnapshotId,
+		Size:             snapshot.VolumeSize,
+		Encrypted:        snapshot.Encrypted,
+	}
+	if aws.BoolValue(snapshot.Encrypted) {
+		params.KmsKeyId = snapshot.KmsKeyId
+	}
+
+	original, err := mgr.originalVolume(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	var throughput int64
+	if original != nil {
+		params.VolumeType = original.VolumeType
+		if hasProvisionedIops(aws.StringValue(original.VolumeType)) {
+			params.Iops = original.Iops
+		}
+		if aws.StringValue(original.VolumeType) == volumeTypeGp3 {
+			if throughput, err = mgr.volumeThroughput(original.VolumeId); err != nil {
+				return nil, err
+			}
+		}
+		if aws.Int64Value(original.Size) > aws.Int64Value(params.Size) {
+			params.Size = original.Size
+		}
+	}
+	if opts.VolumeType != "" {
+		params.VolumeType = aws.String(opts.VolumeType)
+		params.Iops = nil
+		throughput = 0
+	}
+	if opts.Iops > 0 {
+		params.Iops = aws.Int64(opts.Iops)
+	}
+	if opts.Throughput > 0 {
+		throughput = opts.Throughput
+	}
+
+	mgr.observe(func(o Observer) { o.OnVolumeRestoring(mgr.Region, snapshot, opts.AvailabilityZone) })
+
+	var options []request.Option
+	if throughput > 0 {
+		options = append(options, withThroughput(throughput))
+	}
+	volume, err := mgr.ec2.CreateVolumeWithContext(aws.BackgroundContext(), params, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := append(withoutKeys(withoutBookkeepingTags(filterReservedTags(snapshot.Tags)), opts.OmitTags), &ec2.Tag{
+		Key:   aws.String(RestoredFromTag),
+		Value: snapshot.SnapshotId,
+	})
+	tags = append(tags, opts.Tags...)
+	if err := mgr.tagResource(volume.VolumeId, tags); err != nil {
+		return volume, err
+	}
+
+	describeVolume := &ec2.DescribeVolumesInput{VolumeIds: []*string{volume.VolumeId}}
+
+	if opts.Wait || opts.InstanceID != "" {
+		if err := mgr.ec2.WaitUntilVolumeAvailable(describeVolume); err != nil {
+			return volume, err
+		}
+	}
+
+	if opts.InstanceID != "" {
+		if opts.Device == "" {
+			return volume, errors.New("device is required to attach a volume")
+		}
+
+		mgr.observe(func(o Observer) { o.OnVolumeAttaching(mgr.Region, volume, opts.InstanceID, opts.Device) })
+
+		_, err := mgr.ec2.AttachVolume(&ec2.AttachVolumeInput{
+			VolumeId:   volume.VolumeId,
+			InstanceId: aws.String(opts.InstanceID),
+			Device:     aws.String(opts.Device),
+		})
+		if err != nil {
+			return volume, err
+		}
+
+		if opts.Wait {
+			if err := mgr.ec2.WaitUntilVolumeInUse(describeVolume); err != nil {
+				return volume, err
+			}
+		}
+	}
+
+	return volume, nil
+}
+
+// restoreSnapshot returns the snapshot selected by the restore options
+func (mgr *SnapshotManager) restoreSnapshot(opts RestoreOptions) (*ec2.Snapshot, error) {
+	if opts.SnapshotID == "" {
+		if opts.VolumeID == "" {
+			return nil, errors.New("a snapshot ID or volume ID is required")
+		}
+
+		at := opts.PointInTime
+		if at.IsZero() {
+			at = time.Now()
+		}
+		return mgr.FindSnapshot(opts.VolumeID, at)
+	}
+
+	resp, err := mgr.ec2.DescribeSnapshots(&ec2.DescribeSnapshotsInput{
+		SnapshotIds: []*string{aws.String(opts.SnapshotID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Snapshots) == 0 {
+		return nil, fmt.Errorf("snapshot %s not found", opts.SnapshotID)
+	}
+	return resp.Snapshots[0], nil
+}
+
+// originalVolume returns the volume a snapshot, or the original of an encrypted copy, was created
+// from, or nil if it no longer exists
+func (mgr *SnapshotManager) originalVolume(snapshot *ec2.Snapshot) (*ec2.Volume, error) {
+	resp, err := mgr.ec2.DescribeVolumes(&ec2.DescribeVolumesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("volume-id"),
+				Values: []*string{aws.String(sourceVolumeID(snapshot))},
+			},
+		},
+	})
+	if awserror.HasCode(err, "InvalidVolume.NotFound") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Volumes) == 0 {
+		return nil, nil
+	}
+	return resp.Volumes[0], nil
+}
@@ -9,30 +9,47 @@
 // 	- ec2:CreateTags
 // 	- ec2:DeleteSnapshot
 // 	- ec2:DeleteTags
+// 	- ec2:DescribeRegions (optional, for EnabledRegions)
 // 	- ec2:DescribeSnapshotAttribute
 // 	- ec2:DescribeSnapshots
 // 	- ec2:DescribeTags
 // 	- ec2:DescribeVolumes
 // 	- ec2:ModifySnapshotAttribute
 // 	- ec2:ResetSnapshotAttribute
+// 	- ec2:AttachVolume, ec2:CreateVolume, ec2:DetachVolume,
+// 	  ec2:DescribeInstances (optional, for RestoreVolume and Verify)
+// 	- ec2:DeleteVolume (optional, for Verify and ReapUnattached)
+// 	- ec2:DescribeInstances (also optional, for InstanceTagKeys)
+// 	- kms:CreateGrant, kms:DescribeKey, kms:Encrypt, kms:Decrypt,
+// 	  kms:GenerateDataKeyWithoutPlaintext, kms:ReEncrypt* (optional, on KmsKeyID
+// 	  with RequireEncryption)
+// 	- sts:GetCallerIdentity (optional, for SetAuditLog)
 package ebs
 
 import (
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
+	"text/template"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/healthcareblocks/ebs_snapshotter/auditlog"
 	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+	"github.com/healthcareblocks/ebs_snapshotter/report"
 )
 
 // MaxRetries is the number of AWS service requests that can be retried
 const MaxRetries = 200
 
+// ExcludeTag is a volume tag that excludes the volume from snapshots when set to "true"
+const ExcludeTag = "ebs_snapshotter:exclude"
+
 // SnapshotManager manages EBS snapshots via the AWS SDK
 type SnapshotManager struct {
 	// The EC2 region containg the EBS volumes to snapshot. This parameter is required.
@@ -50,49 +67,203 @@ type SnapshotManager struct {
 	// How many EBS snapshots to retain after the current snapshot is generated
 	NumSnapshotsToRetain int
 
+	// If set, new snapshots are tagged with ExpiresAtTag this long after they are started
+	RetentionPeriod time.Duration
+
+	// The name of the backup policy, available to the description and Name templates
+	Policy string
+
+	// Static tags added to every snapshot, overriding copied volume tags with the same key
+	Tags []*ec2.Tag
+
+	// If set, only volume tags with these keys are copied to snapshots
+	IncludeTagKeys []string
+
+	// Volume tags with these keys are never copied to snapshots
+	ExcludeTagKeys []string
+
+	// Tags of the attached instance to copy to snapshots. Volume tags with the same key take
+	// precedence.
+	InstanceTagKeys []string
+
+	// Whether snapshots of unencrypted volumes are replaced by encrypted copies on later runs,
+	// and unencrypted snapshots without a copy are reported
+	RequireEncryption bool
+
+	// The KMS key used to encrypt copies of unencrypted snapshots. If empty, the account's
+	// default EBS key is used.
+	KmsKeyID string
+
+	// Whether to snapshot unattached (available) volumes as well as attached ones
+	IncludeUnattached bool
+
+	// Whether volumes without a BackupKeyTag are grouped for retention by their Name tag and
+	// attachment device instead of their volume ID
+	BackupKeyFromNameAndDevice bool
+
+	// Whether to tag snapshots with the instance ID, device name and availability zone of the
+	// volume's attachment
+	AttachmentTags bool
+
+	// Optional templates executed with SnapshotTemplateData for the snapshot description
+	// and Name tag. The Name template overrides any Name tag copied from the volume.
+	DescriptionTemplate *template.Template
+	NameTemplate        *template.Template
+
 	// Internal reference to EC2 Client
-	ec2 *ec2.EC2
+	ec2 ec2iface.EC2API
+
+	// Observers receive the SnapshotManager's lifecycle events. NewSnapshotManager adds a
+	// LogObserver, which can be removed to silence logging.
+	Observers []Observer
+
+	// Tags of the attached instances, by instance ID, loaded when InstanceTagKeys is set
+	instances map[string][]*ec2.Tag
 }
 
 // NewSnapshotManager returns a new SnapshotManager pointer. The input parameters are based
 // on the SnapshotManager struct fields. Enabling debug mode will dump out AWS requests / responses.
+// Options customize the EC2 client, see Option. An error is returned if a parameter is invalid,
+// the compiled in retention floor is invalid or the AWS session can't be created.
 //
-//     mgr := ebs.NewSnapshotManager("us-west-2", "", true, 5, false)
+//     mgr, err := ebs.NewSnapshotManager("us-west-2", "", true, 5, false)
 //
-func NewSnapshotManager(region string, endpoint string, copyVolumeTags bool, numSnapshotsToRetain int, debug bool) *SnapshotManager {
+func NewSnapshotManager(region string, endpoint string, copyVolumeTags bool, numSnapshotsToRetain int, debug bool, options ...Option) (*SnapshotManager, error) {
 	if region == "" {
-		log.Fatal("region is required")
+		return nil, errors.New("region is required")
 	}
 
-	if numSnapshotsToRetain < 1 {
-		log.Fatal("numSnapshotsToRetain should be great than 0")
+	if floorErr != nil {
+		return nil, floorErr
 	}
 
-	config := aws.NewConfig().WithRegion(region).WithMaxRetries(MaxRetries)
-	if endpoint != "" {
-		config = config.WithEndpoint(endpoint)
+	opts := &clientOptions{}
+	for _, option := range options {
+		option(opts)
 	}
 
-	if debug {
-		config = config.WithLogLevel(aws.LogDebugWithHTTPBody)
-	}
-
-	sess, err := session.NewSession(config)
-	awserror.HandleError(err)
-
-	return &SnapshotManager{
+	mgr := &SnapshotManager{
 		Region:               region,
 		Endpoint:             endpoint,
 		CopyVolumeTags:       copyVolumeTags,
 		NumSnapshotsToRetain: numSnapshotsToRetain,
-		ec2:                  ec2.New(sess),
+		Observers:            []Observer{LogObserver{}},
+		ec2:                  opts.api,
+	}
+	if err := mgr.validate(); err != nil {
+		return nil, err
+	}
+
+	if mgr.ec2 == nil {
+		config := aws.NewConfig().WithRegion(region).WithMaxRetries(MaxRetries)
+		if endpoint != "" {
+			config = config.WithEndpoint(endpoint)
+		}
+
+		if debug {
+			config = config.WithLogLevel(aws.LogDebugWithHTTPBody)
+		}
+
+		if opts.config != nil {
+			config.MergeIn(opts.config)
+			config.Region = aws.String(region)
+		}
+
+		sess := opts.sess
+		if sess == nil {
+			var err error
+			if sess, err = session.NewSession(config); err != nil {
+				return nil, err
+			}
+		}
+
+		mgr.ec2 = ec2.New(sess, config)
+	}
+
+	return mgr, nil
+}
+
+// validate returns an error if a setting would make retention delete more than intended
+func (mgr *SnapshotManager) validate() error {
+	if mgr.NumSnapshotsToRetain < 1 {
+		return errors.New("NumSnapshotsToRetain should be greater than 0")
 	}
+	return nil
+}
+
+// SetAuditLog records every change the SnapshotManager makes to snapshots and volumes,
+// successful or not, in the audit log. A client passed in with WithEC2 is only audited if it
+// is an *ec2.EC2.
+func (mgr *SnapshotManager) SetAuditLog(logger *auditlog.Logger) {
+	client, ok := mgr.ec2.(*ec2.EC2)
+	if !ok {
+		log.Printf("Audit log is not supported by the EC2 client in region %s", mgr.Region)
+		return
+	}
+	client.Handlers.Complete.PushBackNamed(logger.Handler(mgr.Region))
 }
 
 // SnapshotVolumes is a helper method that wraps several operations. It queries for attached
 // EBS volumes in the SnapshotManager's region, generates new snapshots, optionally
 // copying any volume tags, and keeps the last X snapshots as specified by retainCount.
 func (mgr *SnapshotManager) SnapshotVolumes() (snapshotsGenerated int) {
+	return len(mgr.Run().Created)
+}
+
+// Run performs the same operations as SnapshotVolumes, returning a report of the
+// snapshots created and deleted in the SnapshotManager's region. Failures are reported to
+// the Observers and recorded in the report rather than exiting, so the remaining volumes are
+// still processed.
+func (mgr *SnapshotManager) Run() *report.Region {
+	start := time.Now()
+	result := report.NewRegion(mgr.Region)
+	defer func() {
+		result.Duration = time.Since(start)
+		mgr.observe(func(o Observer) { o.OnRunCompleted(result) })
+	}()
+
+	err := mgr.validate()
+	var volumes []*ec2.Volume
+	if err == nil {
+		volumes, err = mgr.describeVolumes(nil)
+	}
+	if err != nil {
+		mgr.observeError(nil, err)
+		result.AddFailure(report.Volume{}, err)
+		return result
+	}
+
+	if mgr.IncludeUnattached {
+		mgr.trackUnattached(volumes, time.Now())
+	}
+
+	if len(mgr.InstanceTagKeys) > 0 {
+		// instances that can't be described in a single request are described individually
+		// when their volumes are snapshotted
+		mgr.instances = nil
+		if err := mgr.loadInstanceTags(attachedInstanceIDs(volumes)); err != nil {
+			mgr.observeError(nil, err)
+		}
+	}
+
+	for _, volume := range volumes {
+		if isExcluded(volume) {
+			reason := fmt.Sprintf("it has the %s tag", ExcludeTag)
+			mgr.observe(func(o Observer) { o.OnVolumeSkipped(mgr.Region, volume, reason) })
+			continue
+		}
+
+		mgr.observe(func(o Observer) { o.OnVolumeSelected(mgr.Region, volume) })
+		result.AddVolume(mgr.snapshotVolume(volume))
+	}
+
+	return result
+}
+
+// describeVolumes returns the attached volumes in the SnapshotManager's region, and the
+// unattached volumes if IncludeUnattached is set. If volumeIDs is not empty, only those
+// volumes are returned.
+func (mgr *SnapshotManager) describeVolumes(volumeIDs []string) ([]*ec2.Volume, error) {
 	params := &ec2.DescribeVolumesInput{
 		Filters: []*ec2.Filter{
 			{
@@ -104,27 +275,123 @@ func (mgr *SnapshotManager) SnapshotVolumes() (snapshotsGenerated int) {
 		},
 	}
 
-	resp, err := mgr.ec2.DescribeVolumes(params)
-	awserror.HandleError(err)
+	if mgr.IncludeUnattached {
+		params.Filters[0] = &ec2.Filter{
+			Name: aws.String("status"),
+			Values: []*string{
+				aws.String(ec2.VolumeStateInUse),
+				aws.String(ec2.VolumeStateAvailable),
+			},
+		}
+	}
+
+	if len(volumeIDs) > 0 {
+		params.Filters = append(params.Filters, &ec2.Filter{
+			Name:   aws.String("volume-id"),
+			Values: aws.StringSlice(volumeIDs),
+		})
+	}
+
+	var volumes []*ec2.Volume
+	err := mgr.ec2.DescribeVolumesPages(params, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		volumes = append(volumes, page.Volumes...)
+		return true
+	})
+	return volumes, err
+}
+
+// snapshotVolume creates a snapshot of the volume and prunes its older snapshots,
+// returning the outcome. Errors are reported to the Observers and recorded in the outcome.
+func (mgr *SnapshotManager) snapshotVolume(volume *ec2.Volume) (outcome report.Volume) {
+	outcome.ID = *volume.VolumeId
+	outcome.Name = volumeName(volume)
+
+	fail := func(err error) report.Volume {
+		mgr.observeError(volume, err)
+		outcome.Error = err.Error()
+		return outcome
+	}
+
+	// earlier snapshots are copied before the new one is taken, so that their copies keep
+	// their place in the retention order. Failures don't prevent the new snapshot.
+	var encryptErr error
+	if mgr.RequireEncryption && !aws.BoolValue(volume.Encrypted) {
+		encryptErr = mgr.encryptSnapshots(volume)
+	}
+
+	snapshot, err := mgr.createSnapshot(volume)
+	if snapshot != nil {
+		outcome.SnapshotID = *snapshot.SnapshotId
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if encryptErr != nil {
+		return fail(encryptErr)
+	}
+
+	snapshots, err := mgr.describeSnapshots(volume)
+	if err != nil {
+		return fail(err)
+	}
+
+	if mgr.CopyVolumeTags {
+		outcome.TagsChanged = mgr.tagsChanged(volume, snapshots, outcome.SnapshotID)
+	}
 
-	for _, volume := range resp.Volumes {
-		mgr.CreateSnapshot(volume)
-		mgr.DestroySnapshots(volume)
-		snapshotsGenerated++
+	snapshots, pending := mgr.retentionCandidates(snapshots)
+	outcome.Deleted, err = mgr.deleteSnapshots(snapshots)
+	if err != nil {
+		return fail(err)
+	}
+
+	// copies of deleted snapshots are cancelled rather than left to replace them
+	for _, snapshotID := range outcome.Deleted {
+		if copied, ok := pending[snapshotID]; ok {
+			if _, err := mgr.deleteSnapshot(copied); err != nil {
+				return fail(err)
+			}
+		}
+	}
+
+	// snapshots waiting for their encrypted copy, including the new one, are reported until
+	// they are replaced
+	if mgr.RequireEncryption {
+		for _, snapshotID := range unencryptedSnapshots(snapshots) {
+			if !contains(outcome.Deleted, snapshotID) {
+				outcome.Unencrypted = append(outcome.Unencrypted, snapshotID)
+			}
+		}
 	}
 
-	return snapshotsGenerated
+	if oldest := oldestRemaining(snapshots, outcome.Deleted); oldest != nil {
+		outcome.OldestSnapshot = *oldest.StartTime
+	}
+
+	// the snapshot started by this run is normally still pending, so it only counts once a
+	// later run sees it completed
+	if newest := newestCompleted(snapshots, outcome.Deleted); newest != nil {
+		outcome.NewestSnapshot = *newest.StartTime
+	}
+
+	return outcome
 }
 
 // CreateSnapshot creates an EBS snapshot for a specific EBS volume, optionally copying
-// any volume tags depending on SnapshotManager's CopyVolumeTags. If the volume has a Name tag,
-// it is used as the snapshot's description.
+// any volume tags depending on SnapshotManager's CopyVolumeTags, and adding the static Tags.
+// The description is rendered from DescriptionTemplate if set, otherwise if the volume has
+// a Name tag it is used as the snapshot's description.
 func (mgr *SnapshotManager) CreateSnapshot(volume *ec2.Volume) {
-	description := fmt.Sprintf("Snapshot for volume %s", *volume.VolumeId)
-	for _, tag := range volume.Tags {
-		if *tag.Key == "Name" {
-			description = *tag.Value
-		}
+	_, err := mgr.createSnapshot(volume)
+	awserror.HandleError(err)
+}
+
+func (mgr *SnapshotManager) createSnapshot(volume *ec2.Volume) (*ec2.Snapshot, error) {
+	data := mgr.templateData(volume)
+
+	description, err := mgr.snapshotDescription(volume, data)
+	if err != nil {
+		return nil, err
 	}
 
 	params := &ec2.CreateSnapshotInput{
@@ -132,44 +399,73 @@ func (mgr *SnapshotManager) CreateSnapshot(volume *ec2.Volume) {
 		VolumeId:    aws.String(*volume.VolumeId),
 	}
 
-	log.Printf("Starting snapshot for %s in region %s", *volume.VolumeId, mgr.Region)
-
 	snapshot, err := mgr.ec2.CreateSnapshot(params)
-	awserror.HandleError(err)
+	if err != nil {
+		return nil, err
+	}
+	mgr.observe(func(o Observer) { o.OnSnapshotCreated(mgr.Region, volume, snapshot) })
 
-	if mgr.CopyVolumeTags && len(volume.Tags) > 0 {
-		mgr.TagResource(snapshot.SnapshotId, volume.Tags)
+	// tags are gathered once the snapshot has started, so that failing to describe the
+	// attached instance doesn't prevent the volume from being backed up
+	tags, err := mgr.snapshotTags(volume, data)
+	if err != nil {
+		return snapshot, err
+	}
+
+	if len(tags) > 0 {
+		if err := mgr.tagSnapshot(snapshot.SnapshotId, tags); err != nil {
+			return snapshot, err
+		}
 	}
+
+	return snapshot, nil
 }
 
 // TagResource creates tags for an EBS snapshot
 func (mgr *SnapshotManager) TagResource(id *string, tags []*ec2.Tag) {
-	// remove tags containing "aws:", as these are reserved by AWS
-	// and cannot be duplicated for other resources
-	filteredTags := tags[:0]
-	for _, tag := range tags {
-		if !strings.Contains(*tag.Key, "aws:") {
-			filteredTags = append(filteredTags, tag)
-		}
-	}
+	awserror.HandleError(mgr.tagResource(id, tags))
+}
 
+func (mgr *SnapshotManager) tagResource(id *string, tags []*ec2.Tag) error {
 	params := &ec2.CreateTagsInput{
 		Resources: []*string{
 			aws.String(*id),
 		},
-		Tags: filteredTags,
+		Tags: filterReservedTags(tags),
 	}
 
 	_, err := mgr.ec2.CreateTags(params)
-	awserror.HandleError(err)
+	return err
+}
+
+// tagSnapshot adds tags to a new snapshot and notifies the Observers
+func (mgr *SnapshotManager) tagSnapshot(id *string, tags []*ec2.Tag) error {
+	if err := mgr.tagResource(id, tags); err != nil {
+		return err
+	}
+	mgr.observe(func(o Observer) { o.OnSnapshotTagged(mgr.Region, *id, tags) })
+	return nil
 }
 
 // DestroySnapshots deletes snapshots greater than SnapshotManager's NumSnapshotsToRetain for a given volume
 func (mgr *SnapshotManager) DestroySnapshots(volume *ec2.Volume) (snapshotsDestroyed int) {
-	if mgr.NumSnapshotsToRetain < 1 {
-		log.Fatal("NumSnapshotsToRetain should be great than 0")
+	deleted, err := mgr.destroySnapshots(volume)
+	awserror.HandleError(err)
+	return len(deleted)
+}
+
+func (mgr *SnapshotManager) destroySnapshots(volume *ec2.Volume) (deleted []string, err error) {
+	snapshots, err := mgr.describeSnapshots(volume)
+	if err != nil {
+		return nil, err
 	}
+	return mgr.deleteSnapshots(snapshots)
+}
 
+// describeSnapshots returns the snapshots of a volume, including encrypted copies, oldest
+// first. If the volume has a backup key, the snapshots of other volumes with the same key
+// are included.
+func (mgr *SnapshotManager) describeSnapshots(volume *ec2.Volume) ([]*ec2.Snapshot, error) {
 	params := &ec2.DescribeSnapshotsInput{
 		Filters: []*ec2.Filter{
 			{
@@ -180,26 +476,223 @@ func (mgr *SnapshotManager) DestroySnapshots(volume *ec2.Volume) (snapshotsDestr
 			},
 		},
 	}
-	resp, err := mgr.ec2.DescribeSnapshots(params)
-	awserror.HandleError(err)
+	var snapshots []*ec2.Snapshot
+	err := mgr.ec2.DescribeSnapshotsPages(params, func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+		snapshots = append(snapshots, page.Snapshots...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	copies, err := mgr.describeCopiedSnapshots(*volume.VolumeId)
+	if err != nil {
+		return nil, err
+	}
+	snapshots = mergeSnapshots(snapshots, copies)
+
+	if key := mgr.backupKey(volume); key != "" {
+		keyed, err := mgr.describeBackupKeySnapshots(key)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = mergeSnapshots(snapshots, keyed)
+	}
 
-	snapshots := resp.Snapshots
 	sort.Sort(ByStartTime(snapshots))
+	return snapshots, nil
+}
 
-	numberSnapshotsToDelete := len(snapshots) - mgr.NumSnapshotsToRetain
-	for numberSnapshotsToDelete > 0 {
-		params := &ec2.DeleteSnapshotInput{
-			SnapshotId: aws.String(*resp.Snapshots[numberSnapshotsToDelete-1].SnapshotId),
+// deleteSnapshots deletes the oldest snapshots so that only NumSnapshotsToRetain remain.
+// The snapshots must be sorted oldest first.
+func (mgr *SnapshotManager) deleteSnapshots(snapshots []*ec2.Snapshot) (deleted []string, err error) {
+	expired := mgr.expiredSnapshots(snapshots)
+	for i := len(expired) - 1; i >= 0; i-- {
+		ok, err := mgr.deleteSnapshot(expired[i])
+		if err != nil {
+			return deleted, err
 		}
+		if ok {
+			deleted = append(deleted, *expired[i].SnapshotId)
+		}
+	}
+
+	return deleted, nil
+}
 
-		_, err := mgr.ec2.DeleteSnapshot(params)
-		if err != nil && err.(awserr.Error).Code() != "InvalidSnapshot.InUse" {
-			awserror.HandleError(err)
+// deleteSnapshot deletes a snapshot and reports it to the Observers. Snapshots in use, such
+// as by an AMI, are skipped without an error and false is returned.
+func (mgr *SnapshotManager) deleteSnapshot(snapshot *ec2.Snapshot) (bool, error) {
+	_, err := mgr.ec2.DeleteSnapshot(&ec2.DeleteSnapshotInput{SnapshotId: snapshot.SnapshotId})
+	if awserror.HasCode(err, "InvalidSnapshot.InUse") {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	mgr.observe(func(o Observer) { o.OnSnapshotDeleted(mgr.Region, snapshot) })
+	return true, nil
+}
+
+// retentionCandidates returns the snapshots the retention policy counts and may delete. When
+// RequireEncryption is set, encrypted copies whose original is still present are left out so
+// that each pair counts once, and are returned by the ID of their original.
+func (mgr *SnapshotManager) retentionCandidates(snapshots []*ec2.Snapshot) ([]*ec2.Snapshot, map[string]*ec2.Snapshot) {
+	if !mgr.RequireEncryption {
+		return snapshots, nil
+	}
+	return withoutPendingCopies(snapshots)
+}
+
+// projectedDeletions returns the IDs of the snapshots Run would delete from a volume's
+// snapshots, sorted oldest first, if it retained retain snapshots, including the pending
+// copies it would cancel, and the number of retention candidates it would keep. The
+// snapshots the retention floor keeps aren't reported to the Observers.
+func (mgr *SnapshotManager) projectedDeletions(snapshots []*ec2.Snapshot, retain int) (map[string]bool, int) {
+	candidates, pending := mgr.retentionCandidates(snapshots)
+	expiring, _ := expiredRetaining(candidates, retain)
+	expired := snapshotIDSet(expiring)
+
+	// Run cancels the pending copies of the snapshots it deletes
+	for source, copied := range pending {
+		if expired[source] {
+			expired[*copied.SnapshotId] = true
 		}
+	}
+	return expired, len(candidates) - len(expiring)
+}
+
+// expiredSnapshots returns the oldest snapshots beyond the NumSnapshotsToRetain newest,
+// which the retention policy deletes. Snapshots tagged to expire in the future, final
+// snapshots of reaped volumes and snapshots protected by the retention floor are kept.
+// The snapshots must be sorted oldest first.
+func (mgr *SnapshotManager) expiredSnapshots(snapshots []*ec2.Snapshot) []*ec2.Snapshot {
+	expired, floorKept := expiredRetaining(snapshots, mgr.NumSnapshotsToRetain)
+	for _, snapshot := range floorKept {
+		mgr.floorKept(snapshot, fmt.Sprintf("retaining %d", mgr.NumSnapshotsToRetain))
+	}
+	return expired
+}
 
-		numberSnapshotsToDelete--
-		snapshotsDestroyed++
+// expiredRetaining returns the snapshots expiredSnapshots would return if retain snapshots
+// were kept instead of NumSnapshotsToRetain, and those it would keep only because of the
+// retention floor
+func expiredRetaining(snapshots []*ec2.Snapshot, retain int) (expired []*ec2.Snapshot, floorKept []*ec2.Snapshot) {
+	// callers validate retain, but nothing expires without a valid one
+	if retain < 1 {
+		return nil, nil
 	}
 
-	return snapshotsDestroyed
+	numberSnapshotsToDelete := len(snapshots) - retain
+	if numberSnapshotsToDelete <= 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	for _, snapshot := range snapshots[:numberSnapshotsToDelete] {
+		if unexpired(snapshot, now) || isFinalSnapshot(snapshot) {
+			continue
+		}
+		if floorProtected(snapshots, snapshot, now) {
+			floorKept = append(floorKept, snapshot)
+			continue
+		}
+		expired = append(expired, snapshot)
+	}
+	return expired, floorKept
+}
+
+// oldestRemaining returns the oldest snapshot that was not deleted, or nil if none remain.
+// Snapshots must be sorted oldest first.
+func oldestRemaining(snapshots []*ec2.Snapshot, deleted []string) *ec2.Snapshot {
+	for _, snapshot := range snapshots {
+		if !contains(deleted, *snapshot.SnapshotId) {
+			return snapshot
+		}
+	}
+	return nil
+}
+
+// newestCompleted returns the newest completed snapshot that was not deleted, or nil if there
+// is none. Snapshots must be sorted oldest first.
+func newestCompleted(snapshots []*ec2.Snapshot, deleted []string) *ec2.Snapshot {
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if isCompleted(snapshots[i]) && !contains(deleted, *snapshots[i].SnapshotId) {
+			return snapshots[i]
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// filterReservedTags returns tags without those containing "aws:", as these are reserved
+// by AWS and cannot be duplicated for other resources
+func filterReservedTags(tags []*ec2.Tag) []*ec2.Tag {
+	var filtered []*ec2.Tag
+	for _, tag := range tags {
+		if !strings.Contains(*tag.Key, "aws:") {
+			filtered = append(filtered, tag)
+		}
+	}
+	return filtered
+}
+
+// tagsChanged returns true if the volume's copied tags differ from those on its most recent
+// snapshot prior to newSnapshotID, ignoring static and templated tags. Snapshots must be
+// sorted oldest first.
+func (mgr *SnapshotManager) tagsChanged(volume *ec2.Volume, snapshots []*ec2.Snapshot, newSnapshotID string) bool {
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if *snapshots[i].SnapshotId == newSnapshotID {
+			continue
+		}
+		previous := withoutKeys(filterReservedTags(snapshots[i].Tags), mgr.generatedTagKeys())
+		return !sameTags(withoutKeys(mgr.copiedTags(volume), mgr.generatedTagKeys()), previous)
+	}
+	return false
+}
+
+func sameTags(a []*ec2.Tag, b []*ec2.Tag) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	values := make(map[string]string, len(a))
+	for _, tag := range a {
+		values[*tag.Key] = *tag.Value
+	}
+	for _, tag := range b {
+		if value, ok := values[*tag.Key]; !ok || value != *tag.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// isExcluded returns true if the volume has opted out of snapshots with the ExcludeTag
+func isExcluded(volume *ec2.Volume) bool {
+	for _, tag := range volume.Tags {
+		if *tag.Key == ExcludeTag {
+			return strings.EqualFold(*tag.Value, "true")
+		}
+	}
+	return false
+}
+
+// volumeName returns the value of the volume's Name tag, or an empty string if it has none
+func volumeName(volume *ec2.Volume) (name string) {
+	for _, tag := range volume.Tags {
+		if *tag.Key == "Name" {
+			name = *tag.Value
+		}
+	}
+	return name
 }
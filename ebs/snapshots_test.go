@@ -1,44 +1,476 @@
 package ebs
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"text/template"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/healthcareblocks/ebs_snapshotter/auditlog"
+	"github.com/healthcareblocks/ebs_snapshotter/ebstest"
+	"github.com/healthcareblocks/ebs_snapshotter/report"
 	"github.com/stretchr/testify/assert"
 )
 
+func TestMain(m *testing.M) {
+	// the tests exercise retention below the default floor, and set it where they need one
+	floorCount, floorAge = 0, 0
+	os.Exit(m.Run())
+}
+
+// newManager returns a new SnapshotManager, failing the test if it can't be created
+func newManager(t *testing.T, region string, endpoint string, copyVolumeTags bool, numSnapshotsToRetain int, debug bool, options ...Option) *SnapshotManager {
+	mgr, err := NewSnapshotManager(region, endpoint, copyVolumeTags, numSnapshotsToRetain, debug, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mgr
+}
+
 // integration test that covers snapshot creation, tagging, and deletion
 func TestSnapshotVolumes(t *testing.T) {
-	mgr := NewSnapshotManager("us-west-1", awsServer.URL, true, 1, false)
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
 	assert.EqualValues(t, mgr.SnapshotVolumes(), 1)
 }
 
+func TestRunReportsCreatedAndDeletedSnapshots(t *testing.T) {
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+	result := mgr.Run()
+
+	assert.Equal(t, "us-west-1", result.Name)
+	assert.Equal(t, []string{"snap-2"}, result.Created)
+	assert.Equal(t, []string{"snap-1"}, result.Deleted)
+	assert.Empty(t, result.Failures)
+
+	volume := result.Volumes[0]
+	assert.Equal(t, "vol-1a2b3c4d", volume.ID)
+	assert.Equal(t, "Data Volume", volume.Name)
+	assert.Equal(t, "snap-2", volume.SnapshotID)
+	assert.True(t, volume.TagsChanged)
+}
+
 func TestSnapshotDestroyRemovesCorrectQuantity(t *testing.T) {
 	volume := ec2.Volume{VolumeId: aws.String("vol-1a2b3c4d")}
 
-	mgr := NewSnapshotManager("us-west-1", awsServer.URL, true, 1, false)
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
 	assert.EqualValues(t, mgr.DestroySnapshots(&volume), 1)
 
-	mgr = NewSnapshotManager("us-west-1", awsServer.URL, true, 2, false)
+	mgr = newManager(t, "us-west-1", awsServer.URL, true, 2, false)
 	assert.EqualValues(t, mgr.DestroySnapshots(&volume), 0)
 }
 
+func TestAuditCoverageReportsStaleVolumes(t *testing.T) {
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+	coverage, err := mgr.AuditCoverage(26 * time.Hour)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, coverage.Attached)
+	assert.Equal(t, 1, coverage.Stale)
+	assert.True(t, coverage.Violated())
+	assert.Equal(t, "snap-1", coverage.Volumes[0].NewestSnapshotID)
+	assert.Equal(t, 2, coverage.Volumes[0].Snapshots)
+	assert.Equal(t, CoverageStale, coverage.Volumes[0].Status)
+}
+
+func TestListSnapshotsMarksExpiredSnapshots(t *testing.T) {
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+	listings, err := mgr.ListSnapshots(nil)
+	assert.NoError(t, err)
+
+	snapshots := listings[0].Snapshots
+	assert.Equal(t, "vol-1a2b3c4d", listings[0].VolumeID)
+	assert.Equal(t, "snap-1", *snapshots[0].SnapshotId)
+	assert.True(t, snapshots[0].Expired)
+	assert.Equal(t, "snap-2", *snapshots[1].SnapshotId)
+	assert.False(t, snapshots[1].Expired)
+}
+
+func TestRestoreVolumeFromPointInTime(t *testing.T) {
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+
+	snapshot, err := mgr.FindSnapshot("vol-1a2b3c4d", time.Date(2016, 2, 24, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, "snap-1", *snapshot.SnapshotId)
+
+	_, err = mgr.FindSnapshot("vol-1a2b3c4d", time.Date(2016, 2, 1, 0, 0, 0, 0, time.UTC))
+	assert.Error(t, err)
+
+	volume, err := mgr.RestoreVolume(RestoreOptions{VolumeID: "vol-1a2b3c4d", AvailabilityZone: "us-west-1a"})
+	assert.NoError(t, err)
+	assert.Equal(t, "vol-restored", *volume.VolumeId)
+}
+
+func TestVerifyRestoresAndDeletesTemporaryVolume(t *testing.T) {
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+
+	result := mgr.Verify(VerifyOptions{Sample: 1})
+	assert.False(t, result.Failed())
+	assert.Len(t, result.Verifications, 1)
+
+	verification := result.Verifications[0]
+	assert.Equal(t, "vol-1a2b3c4d", verification.Volume.ID)
+	assert.Equal(t, "snap-1", verification.SnapshotID)
+	assert.Equal(t, "vol-restored", verification.RestoredVolumeID)
+	assert.False(t, verification.Checked)
+}
+
+func TestEnabledRegionsSortedByName(t *testing.T) {
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+
+	regions, err := mgr.EnabledRegions()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eu-west-1", "us-east-1", "us-west-1"}, regions)
+}
+
+// regionsClient is a mock EC2 client that only implements DescribeRegions
+type regionsClient struct {
+	ec2iface.EC2API
+}
+
+func (c *regionsClient) DescribeRegions(*ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
+	return &ec2.DescribeRegionsOutput{Regions: []*ec2.Region{{RegionName: aws.String("ap-south-1")}}}, nil
+}
+
+func TestClientOptions(t *testing.T) {
+	mgr := newManager(t, "us-west-1", "", true, 1, false, WithEC2(&regionsClient{}))
+	regions, err := mgr.EnabledRegions()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ap-south-1"}, regions)
+
+	sess, err := session.NewSession(aws.NewConfig().WithRegion("eu-west-1"))
+	assert.NoError(t, err)
+	mgr = newManager(t, "us-west-1", awsServer.URL, true, 1, false, WithSession(sess))
+	regions, err = mgr.EnabledRegions()
+	assert.NoError(t, err)
+	assert.Len(t, regions, 3)
+
+	config := aws.NewConfig().WithRegion("eu-west-1").WithEndpoint(awsServer.URL).WithMaxRetries(0)
+	mgr = newManager(t, "us-west-1", "", true, 1, false, WithConfig(config))
+	assert.Equal(t, "us-west-1", aws.StringValue(mgr.ec2.(*ec2.EC2).Config.Region))
+	assert.Equal(t, 0, aws.IntValue(mgr.ec2.(*ec2.EC2).Config.MaxRetries))
+	regions, err = mgr.EnabledRegions()
+	assert.NoError(t, err)
+	assert.Len(t, regions, 3)
+}
+
+func TestSnapshotTagsAndDescriptionTemplates(t *testing.T) {
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+	mgr.Policy = "daily"
+	mgr.Tags = []*ec2.Tag{{Key: aws.String("BackupPolicy"), Value: aws.String("daily")}}
+	mgr.ExcludeTagKeys = []string{"Team"}
+	mgr.DescriptionTemplate = template.Must(template.New("description").Parse("{{.Policy}} backup of {{.VolumeID}} on {{.InstanceID}}:{{.Device}}"))
+	mgr.NameTemplate = template.Must(template.New("name").Parse("{{.VolumeName}} {{.Time.Format \"2006\"}}"))
+
+	volume := &ec2.Volume{
+		VolumeId:    aws.String("vol-1a2b3c4d"),
+		Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1a2b3c4d"), Device: aws.String("/dev/sdf")}},
+		Tags: []*ec2.Tag{
+			{Key: aws.String("aws:foo"), Value: aws.String("bar")},
+			{Key: aws.String("Name"), Value: aws.String("Data Volume")},
+			{Key: aws.String("Team"), Value: aws.String("Storage")},
+			{Key: aws.String("BackupPolicy"), Value: aws.String("weekly")},
+		},
+	}
+	data := mgr.templateData(volume)
+
+	description, err := mgr.snapshotDescription(volume, data)
+	assert.NoError(t, err)
+	assert.Equal(t, "daily backup of vol-1a2b3c4d on i-1a2b3c4d:/dev/sdf", description)
+
+	tags, err := mgr.snapshotTags(volume, data)
+	assert.NoError(t, err)
+	assert.Len(t, tags, 2)
+	assert.Equal(t, "Data Volume "+data.Time.Format("2006"), *tags[0].Value)
+	assert.Equal(t, "BackupPolicy", *tags[1].Key)
+	assert.Equal(t, "daily", *tags[1].Value)
+
+	mgr.IncludeTagKeys = []string{"Team"}
+	mgr.ExcludeTagKeys = nil
+	assert.Equal(t, "Team", *mgr.copiedTags(volume)[0].Key)
+	assert.Len(t, mgr.copiedTags(volume), 1)
+}
+
+func TestSnapshotTagsFromInstanceAndAttachment(t *testing.T) {
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+	mgr.InstanceTagKeys = []string{"Name", "Environment"}
+	mgr.AttachmentTags = true
+
+	volume := &ec2.Volume{
+		VolumeId:         aws.String("vol-1a2b3c4d"),
+		AvailabilityZone: aws.String("us-west-1a"),
+		Attachments:      []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1a2b3c4d"), Device: aws.String("/dev/sdf")}},
+		Tags:             []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("Data Volume")}},
+	}
+
+	tags, err := mgr.snapshotTags(volume, mgr.templateData(volume))
+	assert.NoError(t, err)
+
+	values := map[string]string{}
+	for _, tag := range tags {
+		values[*tag.Key] = *tag.Value
+	}
+	assert.Equal(t, map[string]string{
+		"Name":              "Data Volume",
+		"Environment":       "production",
+		InstanceIDTag:       "i-1a2b3c4d",
+		DeviceTag:           "/dev/sdf",
+		AvailabilityZoneTag: "us-west-1a",
+	}, values)
+}
+
+func TestPruneExpiredDeletesSnapshotsPastExpiry(t *testing.T) {
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+	now := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	expired, err := mgr.PruneExpired(now, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"snap-3"}, expired)
+
+	expired, err = mgr.PruneExpired(now, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"snap-3"}, expired)
+}
+
+func TestSetAuditLogRecordsChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditlog")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	file, err := auditlog.OpenFile(path)
+	assert.NoError(t, err)
+	logger := auditlog.New("123456789012", "arn", file)
+
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+	mgr.SetAuditLog(logger)
+	mgr.Run()
+	assert.NoError(t, logger.Close())
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+
+	var actions []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry auditlog.Entry
+		assert.NoError(t, json.Unmarshal([]byte(line), &entry))
+		assert.Equal(t, "us-west-1", entry.Region)
+		assert.Equal(t, auditlog.OutcomeSuccess, entry.Outcome)
+		actions = append(actions, entry.Action)
+	}
+	assert.Equal(t, []string{"CreateSnapshot", "CreateTags", "DeleteSnapshot"}, actions)
+}
+
+func TestRetentionKeepsUnexpiredSnapshots(t *testing.T) {
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+	expiresAt := time.Now().Add(time.Hour).Format(time.RFC3339)
+
+	snapshots := []*ec2.Snapshot{
+		{SnapshotId: aws.String("snap-1")},
+		{SnapshotId: aws.String("snap-2"), Tags: []*ec2.Tag{{Key: aws.String(ExpiresAtTag), Value: aws.String(expiresAt)}}},
+		{SnapshotId: aws.String("snap-3")},
+	}
+
+	expired := mgr.expiredSnapshots(snapshots)
+	assert.Len(t, expired, 1)
+	assert.Equal(t, "snap-1", *expired[0].SnapshotId)
+}
+
+func TestRetentionFloorKeepsSnapshots(t *testing.T) {
+	defer func(count int, age time.Duration) { floorCount, floorAge = count, age }(floorCount, floorAge)
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+	now := time.Now()
+
+	snapshots := []*ec2.Snapshot{
+		{SnapshotId: aws.String("snap-1"), StartTime: aws.Time(now.Add(-240 * time.Hour))},
+		{SnapshotId: aws.String("snap-2"), StartTime: aws.Time(now.Add(-120 * time.Hour))},
+		{SnapshotId: aws.String("snap-3"), StartTime: aws.Time(now.Add(-2 * time.Hour))},
+		{SnapshotId: aws.String("snap-4"), StartTime: aws.Time(now.Add(-1 * time.Hour))},
+	}
+
+	floorCount, floorAge = 3, 0
+	expired := mgr.expiredSnapshots(snapshots)
+	assert.Len(t, expired, 1)
+	assert.Equal(t, "snap-1", *expired[0].SnapshotId)
+
+	floorCount, floorAge = 0, 24*time.Hour
+	expired = mgr.expiredSnapshots(snapshots)
+	assert.Len(t, expired, 2)
+	assert.Equal(t, "snap-2", *expired[1].SnapshotId)
+
+	expiredIDs, err := mgr.PruneExpired(time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC), true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"snap-3"}, expiredIDs)
+
+	floorCount, floorAge = 0, time.Since(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	expiredIDs, err = mgr.PruneExpired(time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC), true)
+	assert.NoError(t, err)
+	assert.Empty(t, expiredIDs)
+}
+
+func TestRetentionGroupsSnapshotsByBackupKey(t *testing.T) {
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+
+	volume := &ec2.Volume{
+		VolumeId: aws.String("vol-1a2b3c4d"),
+		Tags:     []*ec2.Tag{{Key: aws.String(BackupKeyTag), Value: aws.String("db-data")}},
+	}
+
+	snapshots, err := mgr.describeSnapshots(volume)
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 3)
+	assert.Equal(t, "snap-0", *snapshots[0].SnapshotId)
+
+	expired := mgr.expiredSnapshots(snapshots)
+	assert.Len(t, expired, 2)
+	assert.Equal(t, "snap-0", *expired[0].SnapshotId)
+	assert.Equal(t, "snap-1", *expired[1].SnapshotId)
+
+	tags, err := mgr.snapshotTags(volume, mgr.templateData(volume))
+	assert.NoError(t, err)
+	assert.Equal(t, BackupKeyTag, *tags[0].Key)
+}
+
+func TestBackupKeyFromNameAndDevice(t *testing.T) {
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+
+	volume := &ec2.Volume{
+		VolumeId:    aws.String("vol-1a2b3c4d"),
+		Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1a2b3c4d"), Device: aws.String("/dev/sdf")}},
+		Tags:        []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("db")}},
+	}
+	assert.Equal(t, "", mgr.backupKey(volume))
+
+	mgr.BackupKeyFromNameAndDevice = true
+	assert.Equal(t, "db:/dev/sdf", mgr.backupKey(volume))
+
+	volume.Tags = append(volume.Tags, &ec2.Tag{Key: aws.String(BackupKeyTag), Value: aws.String("db-data")})
+	assert.Equal(t, "db-data", mgr.backupKey(volume))
+}
+
+func TestReapUnattachedSnapshotsLongUnattachedVolumes(t *testing.T) {
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+
+	reaped, err := mgr.ReapUnattached(ReapOptions{UnattachedFor: 30 * 24 * time.Hour, DryRun: true})
+	assert.NoError(t, err)
+	assert.Len(t, reaped, 1)
+	assert.Equal(t, "vol-detached", reaped[0].VolumeID)
+	assert.Empty(t, reaped[0].SnapshotID)
+
+	reaped, err = mgr.ReapUnattached(ReapOptions{UnattachedFor: 30 * 24 * time.Hour})
+	assert.NoError(t, err)
+	assert.Len(t, reaped, 1)
+	assert.Equal(t, "snap-2", reaped[0].SnapshotID)
+	assert.False(t, reaped[0].Deleted)
+	assert.Empty(t, reaped[0].Error)
+}
+
+func TestRequireEncryptionReplacesUnencryptedSnapshot(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	volume := srv.AddVolume(&ec2.Volume{Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1")}}})
+	completed := srv.AddSnapshot(&ec2.Snapshot{
+		VolumeId:   volume.VolumeId,
+		VolumeSize: aws.Int64(8),
+		Tags:       []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("db")}},
+	})
+	srv.AddSnapshot(&ec2.Snapshot{VolumeId: volume.VolumeId, VolumeSize: aws.Int64(8), State: aws.String(ec2.SnapshotStatePending)})
+
+	mgr := newManager(t, "us-west-1", srv.URL, true, 1, false)
+	mgr.RequireEncryption = true
+	mgr.KmsKeyID = "arn:aws:kms:us-west-1:123456789012:key/abcd"
+
+	// only the completed snapshot is copied, and it is kept until the copy completes
+	assert.NoError(t, mgr.encryptSnapshots(volume))
+	assert.Equal(t, 1, srv.Calls("CopySnapshot"))
+	assert.NotNil(t, srv.Snapshot(*completed.SnapshotId))
+
+	var copied *ec2.Snapshot
+	for _, snapshot := range srv.Snapshots() {
+		if aws.BoolValue(snapshot.Encrypted) {
+			copied = snapshot
+		}
+	}
+	assert.Equal(t, mgr.KmsKeyID, *copied.KmsKeyId)
+	assert.Equal(t, "db", tagValue(copied.Tags, "Name"))
+	assert.Equal(t, *volume.VolumeId, tagValue(copied.Tags, SourceVolumeTag))
+	assert.Equal(t, *completed.SnapshotId, tagValue(copied.Tags, SourceSnapshotTag))
+
+	assert.NoError(t, mgr.encryptSnapshots(volume))
+	assert.Equal(t, 1, srv.Calls("CopySnapshot"))
+	assert.Nil(t, srv.Snapshot(*completed.SnapshotId))
+	assert.NotNil(t, srv.Snapshot(*copied.SnapshotId))
+
+	unencrypted := unencryptedSnapshots([]*ec2.Snapshot{
+		{SnapshotId: aws.String("snap-1"), Encrypted: aws.Bool(false)},
+		{SnapshotId: aws.String("snap-2"), Encrypted: aws.Bool(true)},
+	})
+	assert.Equal(t, []string{"snap-1"}, unencrypted)
+}
+
+func TestAuditExposureFlagsPublicSnapshots(t *testing.T) {
+	mgr := newManager(t, "us-west-1", awsServer.URL, true, 1, false)
+
+	exposures, err := mgr.AuditExposure(ExposureOptions{ApprovedAccounts: []string{"111111111111"}, AllSnapshots: true})
+	assert.NoError(t, err)
+	assert.Len(t, exposures, 2)
+	assert.True(t, exposures[0].Public)
+	assert.Equal(t, []string{"222222222222"}, exposures[0].Accounts)
+	assert.False(t, exposures[0].Remediated)
+
+	exposures, err = mgr.AuditExposure(ExposureOptions{ApprovedAccounts: []string{"111111111111", "222222222222"}, Remediate: true, AllSnapshots: true})
+	assert.NoError(t, err)
+	assert.Len(t, exposures, 2)
+	assert.Empty(t, exposures[0].Accounts)
+	assert.True(t, exposures[0].Remediated)
+}
+
 // local test server
 var awsServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	params := r.PostForm.Encode()
 
-	if strings.Contains(params, "DescribeVolumes") {
+	if strings.Contains(params, "DescribeVolumes") && strings.Contains(params, "vol-restored") {
+		fmt.Fprintln(w, DescribeRestoredVolumeResponse)
+	} else if strings.Contains(params, "DescribeVolumes") && strings.Contains(params, "Value.1=available") {
+		fmt.Fprintln(w, DescribeUnattachedVolumesResponse)
+	} else if strings.Contains(params, "DescribeVolumes") {
 		fmt.Fprintln(w, DescribeVolumesResponse)
 	} else if strings.Contains(params, "CreateSnapshot") {
 		fmt.Fprintln(w, CreateSnapshotResponse)
+	} else if strings.Contains(params, "DescribeSnapshots") && r.PostForm.Get("SnapshotId.1") != "" {
+		fmt.Fprintf(w, DescribeCompletedSnapshotResponse, r.PostForm.Get("SnapshotId.1"))
+	} else if strings.Contains(params, "DescribeSnapshotAttribute") {
+		fmt.Fprintf(w, DescribeSnapshotAttributeResponse, r.PostForm.Get("SnapshotId"))
+	} else if strings.Contains(params, "ResetSnapshotAttribute") || strings.Contains(params, "ModifySnapshotAttribute") {
+		fmt.Fprintln(w, SnapshotAttributeResponse)
+	} else if strings.Contains(params, "CopySnapshot") {
+		fmt.Fprintln(w, CopySnapshotResponse)
+	} else if strings.Contains(params, "DescribeSnapshots") && strings.Contains(params, "backup_key") {
+		fmt.Fprintln(w, DescribeBackupKeySnapshotsResponse)
+	} else if strings.Contains(params, "DescribeSnapshots") && strings.Contains(params, "tag-key") {
+		fmt.Fprintln(w, DescribeExpiringSnapshotsResponse)
 	} else if strings.Contains(params, "DescribeSnapshots") {
 		fmt.Fprintln(w, DescribeSnapshotsResponse)
+	} else if strings.Contains(params, "CreateVolume") {
+		fmt.Fprintln(w, CreateVolumeResponse)
+	} else if strings.Contains(params, "DescribeInstances") {
+		fmt.Fprintln(w, DescribeInstancesResponse)
+	} else if strings.Contains(params, "DescribeRegions") {
+		fmt.Fprintln(w, DescribeRegionsResponse)
+	} else if strings.Contains(params, "DeleteVolume") {
+		fmt.Fprintln(w, DeleteVolumeResponse)
 	} else if strings.Contains(params, "DeleteSnapshot") {
 		fmt.Fprintln(w, DeleteSnapshotResponse)
 	} else {
@@ -133,9 +565,1139 @@ var DescribeSnapshotsResponse = `
 </DescribeSnapshotsResponse>
 `
 
+var CreateVolumeResponse = `
+<CreateVolumeResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <volumeId>vol-restored</volumeId>
+  <size>80</size>
+  <snapshotId>snap-1</snapshotId>
+  <availabilityZone>us-west-1a</availabilityZone>
+  <status>creating</status>
+  <createTime>2016-02-25T22:35:00.000Z</createTime>
+  <volumeType>standard</volumeType>
+  <encrypted>true</encrypted>
+</CreateVolumeResponse>
+`
+
+var DescribeRestoredVolumeResponse = `
+<DescribeVolumesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <volumeSet>
+    <item>
+      <volumeId>vol-restored</volumeId>
+      <size>80</size>
+      <snapshotId>snap-1</snapshotId>
+      <availabilityZone>us-west-1a</availabilityZone>
+      <status>available</status>
+      <createTime>2016-02-25T22:35:00.000Z</createTime>
+      <volumeType>standard</volumeType>
+      <encrypted>true</encrypted>
+    </item>
+  </volumeSet>
+</DescribeVolumesResponse>
+`
+
+var DescribeUnattachedVolumesResponse = `
+<DescribeVolumesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <volumeSet>
+    <item>
+      <volumeId>vol-detached</volumeId>
+      <size>80</size>
+      <availabilityZone>us-west-1a</availabilityZone>
+      <status>available</status>
+      <createTime>2015-02-25T22:35:00.000Z</createTime>
+      <volumeType>standard</volumeType>
+      <tagSet>
+        <item>
+          <key>ebs_snapshotter:unattached_since</key>
+          <value>2016-01-01T00:00:00Z</value>
+        </item>
+      </tagSet>
+    </item>
+    <item>
+      <volumeId>vol-new-detached</volumeId>
+      <size>80</size>
+      <availabilityZone>us-west-1a</availabilityZone>
+      <status>available</status>
+      <createTime>2015-02-25T22:35:00.000Z</createTime>
+      <volumeType>standard</volumeType>
+    </item>
+  </volumeSet>
+</DescribeVolumesResponse>
+`
+
+var DeleteVolumeResponse = `
+<DeleteVolumeResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <return>true</return>
+</DeleteVolumeResponse>
+`
+
+var DescribeInstancesResponse = `
+<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <reservationSet>
+    <item>
+      <reservationId>r-1a2b3c4d</reservationId>
+      <instancesSet>
+        <item>
+          <instanceId>i-1a2b3c4d</instanceId>
+          <placement>
+            <availabilityZone>us-west-1a</availabilityZone>
+          </placement>
+          <tagSet>
+            <item>
+              <key>Name</key>
+              <value>db-1</value>
+            </item>
+            <item>
+              <key>Environment</key>
+              <value>production</value>
+            </item>
+            <item>
+              <key>Owner</key>
+              <value>ops</value>
+            </item>
+          </tagSet>
+        </item>
+      </instancesSet>
+    </item>
+  </reservationSet>
+</DescribeInstancesResponse>
+`
+
+var DescribeCompletedSnapshotResponse = `
+<DescribeSnapshotsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <snapshotSet>
+    <item>
+      <snapshotId>%s</snapshotId>
+      <volumeId>vol-1a2b3c4d</volumeId>
+      <status>completed</status>
+      <startTime>2016-02-23T17:02:25.000Z</startTime>
+      <progress>100%%</progress>
+      <volumeSize>80</volumeSize>
+      <encrypted>true</encrypted>
+    </item>
+  </snapshotSet>
+</DescribeSnapshotsResponse>
+`
+
+var DescribeSnapshotAttributeResponse = `
+<DescribeSnapshotAttributeResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <snapshotId>%s</snapshotId>
+  <createVolumePermission>
+    <item>
+      <group>all</group>
+    </item>
+    <item>
+      <userId>111111111111</userId>
+    </item>
+    <item>
+      <userId>222222222222</userId>
+    </item>
+  </createVolumePermission>
+</DescribeSnapshotAttributeResponse>
+`
+
+var SnapshotAttributeResponse = `
+<ResetSnapshotAttributeResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <return>true</return>
+</ResetSnapshotAttributeResponse>
+`
+
+var CopySnapshotResponse = `
+<CopySnapshotResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <snapshotId>snap-copy</snapshotId>
+</CopySnapshotResponse>
+`
+
+var DescribeBackupKeySnapshotsResponse = `
+<DescribeSnapshotsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <snapshotSet>
+    <item>
+      <snapshotId>snap-0</snapshotId>
+      <volumeId>vol-replaced</volumeId>
+      <status>completed</status>
+      <startTime>2016-02-20T00:00:00.000Z</startTime>
+      <tagSet>
+        <item>
+          <key>ebs_snapshotter:backup_key</key>
+          <value>db-data</value>
+        </item>
+      </tagSet>
+    </item>
+    <item>
+      <snapshotId>snap-1</snapshotId>
+      <volumeId>vol-1a2b3c4d</volumeId>
+      <status>completed</status>
+      <startTime>2016-02-23T17:02:25.000Z</startTime>
+      <tagSet>
+        <item>
+          <key>ebs_snapshotter:backup_key</key>
+          <value>db-data</value>
+        </item>
+      </tagSet>
+    </item>
+  </snapshotSet>
+</DescribeSnapshotsResponse>
+`
+
+var DescribeExpiringSnapshotsResponse = `
+<DescribeSnapshotsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <snapshotSet>
+    <item>
+      <snapshotId>snap-3</snapshotId>
+      <volumeId>vol-replaced</volumeId>
+      <status>completed</status>
+      <startTime>2017-01-01T00:00:00.000Z</startTime>
+      <tagSet>
+        <item>
+          <key>ebs_snapshotter:expires_at</key>
+          <value>2017-02-01T00:00:00Z</value>
+        </item>
+      </tagSet>
+    </item>
+    <item>
+      <snapshotId>snap-4</snapshotId>
+      <volumeId>vol-1a2b3c4d</volumeId>
+      <status>completed</status>
+      <startTime>2017-02-01T00:00:00.000Z</startTime>
+      <tagSet>
+        <item>
+          <key>ebs_snapshotter:expires_at</key>
+          <value>2017-04-01T00:00:00Z</value>
+        </item>
+      </tagSet>
+    </item>
+  </snapshotSet>
+</DescribeSnapshotsResponse>
+`
+
+var DescribeRegionsResponse = `
+<DescribeRegionsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <regionInfo>
+    <item>
+      <regionName>us-west-1</regionName>
+      <regionEndpoint>ec2.us-west-1.amazonaws.com</regionEndpoint>
+    </item>
+    <item>
+      <regionName>us-east-1</regionName>
+      <regionEndpoint>ec2.us-east-1.amazonaws.com</regionEndpoint>
+    </item>
+    <item>
+      <regionName>eu-west-1</regionName>
+      <regionEndpoint>ec2.eu-west-1.amazonaws.com</regionEndpoint>
+    </item>
+  </regionInfo>
+</DescribeRegionsResponse>
+`
+
 var DeleteSnapshotResponse = `
 <DeleteSnapshotResponse xmlns="http://ec2.amazonaws.com/doc/2015-10-01/">
   <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
   <return>true</return>
 </DeleteSnapshotResponse>
 `
+
+// end-to-end retention scenarios against the stateful fake EC2 endpoint
+
+func TestRetentionAcrossRuns(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	srv.PageSize = 1
+	defer srv.Close()
+
+	attachment := func(instanceID string) []*ec2.VolumeAttachment {
+		return []*ec2.VolumeAttachment{{InstanceId: aws.String(instanceID), Device: aws.String("/dev/sdf")}}
+	}
+	data := srv.AddVolume(&ec2.Volume{
+		Attachments: attachment("i-1"),
+		Tags:        []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("data")}},
+	})
+	logs := srv.AddVolume(&ec2.Volume{Attachments: attachment("i-2")})
+	srv.AddVolume(&ec2.Volume{})
+
+	mgr := newManager(t, "us-west-1", srv.URL, true, 2, false)
+	var created []string
+	for run := 0; run < 4; run++ {
+		result := mgr.Run()
+		assert.Empty(t, result.Failures)
+		assert.Len(t, result.Created, 2)
+		created = append(created, result.Created...)
+	}
+
+	snapshots := srv.Snapshots()
+	assert.Len(t, snapshots, 4)
+	for _, snapshot := range snapshots {
+		assert.Contains(t, created[4:], *snapshot.SnapshotId)
+	}
+
+	var dataSnapshots int
+	for _, snapshot := range snapshots {
+		if *snapshot.VolumeId == *data.VolumeId {
+			dataSnapshots++
+			assert.Equal(t, "data", *snapshot.Tags[0].Value)
+		} else {
+			assert.Equal(t, *logs.VolumeId, *snapshot.VolumeId)
+		}
+	}
+	assert.Equal(t, 2, dataSnapshots)
+	assert.Equal(t, 4, srv.Calls("DeleteSnapshot"))
+}
+
+func TestRetentionRetriesSnapshotsInUse(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	srv.AddVolume(&ec2.Volume{Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1")}}})
+	mgr := newManager(t, "us-west-1", srv.URL, true, 1, false)
+
+	first := mgr.Run().Created[0]
+	srv.Fail("DeleteSnapshot", 1, "InvalidSnapshot.InUse", "The snapshot is in use by ami-1")
+	result := mgr.Run()
+	assert.Empty(t, result.Failures)
+	assert.Empty(t, result.Deleted)
+	assert.NotNil(t, srv.Snapshot(first))
+
+	srv.Throttle("CreateSnapshot", 1)
+	result = mgr.Run()
+	assert.Empty(t, result.Failures)
+	assert.Len(t, srv.Snapshots(), 1)
+	assert.Equal(t, result.Created[0], *srv.Snapshots()[0].SnapshotId)
+}
+
+func TestRequireEncryptionAcrossRuns(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	volume := srv.AddVolume(&ec2.Volume{Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1")}}})
+	mgr := newManager(t, "us-west-1", srv.URL, true, 2, false)
+	mgr.RequireEncryption = true
+
+	// each unencrypted snapshot is reported from the run that takes it until the second run
+	// after, which deletes it once its copy has completed
+	var created []string
+	for run := 0; run < 3; run++ {
+		result := mgr.Run()
+		assert.Empty(t, result.Failures)
+		created = append(created, result.Volumes[0].SnapshotID)
+
+		oldest := 0
+		if run > 1 {
+			oldest = run - 1
+		}
+		assert.Equal(t, created[oldest:], result.Volumes[0].Unencrypted)
+	}
+
+	// the first snapshot was replaced by a copy that the retention policy then deleted, the
+	// second is being replaced by its copy, and the third is copied by the next run
+	encrypted := map[string]string{}
+	var unencrypted []string
+	for _, snapshot := range srv.Snapshots() {
+		if aws.BoolValue(snapshot.Encrypted) {
+			assert.Equal(t, *volume.VolumeId, sourceVolumeID(snapshot))
+			encrypted[*snapshot.SnapshotId] = tagValue(snapshot.Tags, SourceSnapshotTag)
+		} else {
+			unencrypted = append(unencrypted, *snapshot.SnapshotId)
+		}
+	}
+	assert.Len(t, encrypted, 1)
+	for _, source := range encrypted {
+		assert.Equal(t, created[1], source)
+	}
+	assert.Equal(t, created[1:], unencrypted)
+}
+
+// recordingObserver records the events it receives
+type recordingObserver struct {
+	NopObserver
+	events []string
+	runs   int
+}
+
+func (o *recordingObserver) OnVolumeSelected(region string, volume *ec2.Volume) {
+	o.events = append(o.events, "selected "+*volume.VolumeId)
+}
+
+func (o *recordingObserver) OnVolumeSkipped(region string, volume *ec2.Volume, reason string) {
+	o.events = append(o.events, "skipped "+*volume.VolumeId)
+}
+
+func (o *recordingObserver) OnSnapshotCreated(region string, volume *ec2.Volume, snapshot *ec2.Snapshot) {
+	o.events = append(o.events, "created "+*snapshot.SnapshotId)
+}
+
+func (o *recordingObserver) OnSnapshotTagged(region string, snapshotID string, tags []*ec2.Tag) {
+	o.events = append(o.events, "tagged "+snapshotID)
+}
+
+func (o *recordingObserver) OnSnapshotDeleted(region string, snapshot *ec2.Snapshot) {
+	o.events = append(o.events, "deleted "+*snapshot.SnapshotId)
+}
+
+func (o *recordingObserver) OnFloorKept(region string, snapshot *ec2.Snapshot, reason string) {
+	o.events = append(o.events, "floor kept "+*snapshot.SnapshotId)
+}
+
+func (o *recordingObserver) OnVolumeReaping(region string, volume *ec2.Volume) {
+	o.events = append(o.events, "reaping "+*volume.VolumeId)
+}
+
+func (o *recordingObserver) OnVolumeDeleting(region string, volume *ec2.Volume, kind string) {
+	o.events = append(o.events, "deleting "+kind+" "+*volume.VolumeId)
+}
+
+func (o *recordingObserver) OnError(region string, volume *ec2.Volume, err error) {
+	o.events = append(o.events, "error "+aws.StringValue(volume.VolumeId))
+}
+
+func (o *recordingObserver) OnRunCompleted(result *report.Region) {
+	o.runs++
+}
+
+func TestObserverReceivesLifecycleEvents(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	attachment := []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1"), Device: aws.String("/dev/sdf")}}
+	volume := srv.AddVolume(&ec2.Volume{
+		Attachments: attachment,
+		Tags:        []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("data")}},
+	})
+	excluded := srv.AddVolume(&ec2.Volume{
+		Attachments: attachment,
+		Tags:        []*ec2.Tag{{Key: aws.String(ExcludeTag), Value: aws.String("true")}},
+	})
+
+	mgr := newManager(t, "us-west-1", srv.URL, true, 1, false)
+	observer := &recordingObserver{}
+	mgr.Observers = []Observer{observer}
+
+	first := mgr.Run().Created[0]
+	second := mgr.Run().Created[0]
+	assert.Equal(t, []string{
+		"selected " + *volume.VolumeId,
+		"created " + first,
+		"tagged " + first,
+		"skipped " + *excluded.VolumeId,
+		"selected " + *volume.VolumeId,
+		"created " + second,
+		"tagged " + second,
+		"deleted " + first,
+		"skipped " + *excluded.VolumeId,
+	}, observer.events)
+
+	observer.events = nil
+	srv.Fail("CreateSnapshot", 1, "IncorrectState", "The volume is not in a valid state")
+	result := mgr.Run()
+	assert.Len(t, result.Failures, 1)
+	assert.Contains(t, observer.events, "error "+*volume.VolumeId)
+	assert.Equal(t, 3, observer.runs)
+}
+
+func TestObserverReceivesReapEvents(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	since := time.Now().Add(-60 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	volume := srv.AddVolume(&ec2.Volume{Tags: []*ec2.Tag{{Key: aws.String(UnattachedSinceTag), Value: aws.String(since)}}})
+
+	mgr := newManager(t, "us-west-1", srv.URL, false, 1, false)
+	observer := &recordingObserver{}
+	mgr.Observers = []Observer{observer}
+
+	reaped, err := mgr.ReapUnattached(ReapOptions{UnattachedFor: 30 * 24 * time.Hour, Delete: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"reaping " + *volume.VolumeId,
+		"created " + reaped[0].SnapshotID,
+		"tagged " + reaped[0].SnapshotID,
+		"deleting unattached " + *volume.VolumeId,
+	}, observer.events)
+}
+
+func TestEstimateCostProjectsProposedRetention(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	volume := srv.AddVolume(&ec2.Volume{
+		Size:        aws.Int64(100),
+		Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1")}},
+		Tags:        []*ec2.Tag{{Key: aws.String("CostCenter"), Value: aws.String("data")}},
+	})
+	mgr := newManager(t, "us-west-1", srv.URL, false, 3, false)
+	for run := 0; run < 3; run++ {
+		mgr.Run()
+	}
+	srv.AddSnapshot(&ec2.Snapshot{
+		VolumeId:   aws.String("vol-deleted"),
+		VolumeSize: aws.Int64(50),
+		Tags:       []*ec2.Tag{{Key: aws.String("CostCenter"), Value: aws.String("ops")}},
+	})
+
+	estimate, err := mgr.EstimateCost(CostOptions{Price: 0.05, ChangeRate: 0.1, TagKey: "CostCenter", ProposedRetain: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, estimate.Snapshots)
+	assert.Len(t, estimate.Volumes, 2)
+
+	managed := estimate.Volumes[0]
+	assert.Equal(t, *volume.VolumeId, managed.VolumeID)
+	assert.Equal(t, "data", managed.Tag)
+	assert.True(t, managed.Managed)
+	assert.InDelta(t, 120, managed.StoredGiB, 0.001)
+	assert.InDelta(t, 6, managed.Monthly, 0.001)
+	assert.Equal(t, 5, managed.ProposedSnapshots)
+	assert.InDelta(t, 7, managed.ProposedMonthly, 0.001)
+
+	deleted := estimate.Volumes[1]
+	assert.Equal(t, "ops", deleted.Tag)
+	assert.False(t, deleted.Managed)
+	assert.Equal(t, 1, deleted.ProposedSnapshots)
+	assert.InDelta(t, 2.5, deleted.ProposedMonthly, 0.001)
+	assert.InDelta(t, 8.5, estimate.Monthly, 0.001)
+	assert.InDelta(t, 9.5, estimate.ProposedMonthly, 0.001)
+
+	estimate, err = mgr.EstimateCost(CostOptions{Price: 0.05, ChangeRate: 0.1, ProposedRetain: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, estimate.Volumes[0].ProposedSnapshots)
+	assert.InDelta(t, 5, estimate.Volumes[0].ProposedMonthly, 0.001)
+}
+
+func TestEstimateCostProjectsOnlyManagedVolumes(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	attachment := []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1")}}
+	managed := srv.AddVolume(&ec2.Volume{Size: aws.Int64(10), Attachments: attachment})
+	excluded := srv.AddVolume(&ec2.Volume{
+		Size:        aws.Int64(10),
+		Attachments: attachment,
+		Tags:        []*ec2.Tag{{Key: aws.String(ExcludeTag), Value: aws.String("true")}},
+	})
+
+	// the oldest snapshot of the managed volume is a final snapshot, so retaining 1 keeps it
+	// along with the newest
+	final := []*ec2.Tag{{Key: aws.String(FinalSnapshotTag), Value: aws.String("true")}}
+	for i := 0; i < 3; i++ {
+		snapshot := &ec2.Snapshot{VolumeId: managed.VolumeId, VolumeSize: aws.Int64(10)}
+		if i == 0 {
+			snapshot.Tags = final
+		}
+		srv.AddSnapshot(snapshot)
+		srv.AddSnapshot(&ec2.Snapshot{VolumeId: excluded.VolumeId, VolumeSize: aws.Int64(10)})
+	}
+
+	mgr := newManager(t, "us-west-1", srv.URL, false, 3, false)
+	estimate, err := mgr.EstimateCost(CostOptions{Price: 0.05, ChangeRate: 1, ProposedRetain: 1})
+	assert.NoError(t, err)
+	assert.Len(t, estimate.Volumes, 2)
+
+	assert.Equal(t, *managed.VolumeId, estimate.Volumes[0].VolumeID)
+	assert.Equal(t, 2, estimate.Volumes[0].ProposedSnapshots)
+	assert.InDelta(t, 1, estimate.Volumes[0].ProposedMonthly, 0.001)
+
+	assert.Equal(t, *excluded.VolumeId, estimate.Volumes[1].VolumeID)
+	assert.False(t, estimate.Volumes[1].Managed)
+	assert.Equal(t, 3, estimate.Volumes[1].ProposedSnapshots)
+	assert.InDelta(t, estimate.Volumes[1].Monthly, estimate.Volumes[1].ProposedMonthly, 0.001)
+
+	// the retention floor's decisions are projected without being reported
+	defer func(count int, age time.Duration) { floorCount, floorAge = count, age }(floorCount, floorAge)
+	floorCount, floorAge = 3, 0
+	observer := &recordingObserver{}
+	mgr.Observers = []Observer{observer}
+	estimate, err = mgr.EstimateCost(CostOptions{Price: 0.05, ChangeRate: 1, ProposedRetain: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, estimate.Volumes[0].ProposedSnapshots)
+	assert.Empty(t, observer.events)
+}
+
+func TestRestoreVolumeKeepsProvisionedPerformance(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	// the fake predates gp3 throughput, so it is added to DescribeVolumes responses and
+	// recorded from CreateVolume requests here
+	var throughput []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") == "CreateVolume" {
+			throughput = append(throughput, r.Form.Get("Throughput"))
+		}
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, r)
+		body := strings.Replace(rec.Body.String(), "<volumeType>gp3</volumeType>", "<volumeType>gp3</volumeType><throughput>500</throughput>", -1)
+		w.WriteHeader(rec.Code)
+		w.Write([]byte(body))
+	}))
+	defer proxy.Close()
+
+	gp3 := srv.AddVolume(&ec2.Volume{Size: aws.Int64(100), VolumeType: aws.String("gp3"), Iops: aws.Int64(4000)})
+	io2 := srv.AddVolume(&ec2.Volume{Size: aws.Int64(100), VolumeType: aws.String("io2"), Iops: aws.Int64(10000)})
+	gp3Snapshot := srv.AddSnapshot(&ec2.Snapshot{VolumeId: gp3.VolumeId, VolumeSize: aws.Int64(100)})
+	io2Snapshot := srv.AddSnapshot(&ec2.Snapshot{VolumeId: io2.VolumeId, VolumeSize: aws.Int64(100)})
+	// an encrypted copy names its source volume in a tag rather than in VolumeId
+	gp3Copy := srv.AddSnapshot(&ec2.Snapshot{
+		VolumeId:   aws.String("vol-ffffffff"),
+		VolumeSize: aws.Int64(100),
+		Encrypted:  aws.Bool(true),
+		Tags: []*ec2.Tag{
+			{Key: aws.String(SourceVolumeTag), Value: gp3.VolumeId},
+			{Key: aws.String(SourceSnapshotTag), Value: gp3Snapshot.SnapshotId},
+		},
+	})
+
+	mgr := newManager(t, "us-west-1", proxy.URL, false, 1, false)
+
+	restored, err := mgr.RestoreVolume(RestoreOptions{SnapshotID: *gp3Snapshot.SnapshotId, AvailabilityZone: "us-west-1a"})
+	assert.NoError(t, err)
+	assert.Equal(t, "gp3", *srv.Volume(*restored.VolumeId).VolumeType)
+	assert.Equal(t, int64(4000), *srv.Volume(*restored.VolumeId).Iops)
+
+	restored, err = mgr.RestoreVolume(RestoreOptions{SnapshotID: *io2Snapshot.SnapshotId, AvailabilityZone: "us-west-1a"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10000), *srv.Volume(*restored.VolumeId).Iops)
+
+	restored, err = mgr.RestoreVolume(RestoreOptions{SnapshotID: *gp3Copy.SnapshotId, AvailabilityZone: "us-west-1a"})
+	assert.NoError(t, err)
+	assert.Equal(t, "gp3", *srv.Volume(*restored.VolumeId).VolumeType)
+	assert.Equal(t, int64(4000), *srv.Volume(*restored.VolumeId).Iops)
+	assert.True(t, *srv.Volume(*restored.VolumeId).Encrypted)
+
+	_, err = mgr.RestoreVolume(RestoreOptions{SnapshotID: *gp3Snapshot.SnapshotId, AvailabilityZone: "us-west-1a", VolumeType: "gp2"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"500", "", "500", ""}, throughput)
+}
+
+func TestVerifyExcludesTemporaryVolumesFromSnapshots(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	srv.Now = func() time.Time { return time.Now().Add(-time.Hour) }
+	defer srv.Close()
+
+	instance := srv.AddInstance(&ec2.Instance{})
+	volume := srv.AddVolume(&ec2.Volume{
+		Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1"), Device: aws.String("/dev/sdf")}},
+		Tags: []*ec2.Tag{
+			{Key: aws.String("Name"), Value: aws.String("data")},
+			{Key: aws.String(BackupKeyTag), Value: aws.String("db")},
+		},
+	})
+	mgr := newManager(t, "us-west-1", srv.URL, true, 2, false)
+	mgr.Run()
+
+	var tags []*ec2.Tag
+	result := mgr.Verify(VerifyOptions{
+		InstanceID: *instance.InstanceId,
+		Device:     "/dev/sdg",
+		Check: func(restored *ec2.Volume, snapshot *ec2.Snapshot, device string) error {
+			tags = srv.Volume(*restored.VolumeId).Tags
+			return nil
+		},
+	})
+	assert.Empty(t, result.Failures)
+	assert.True(t, result.Verifications[0].Checked)
+	assert.Equal(t, "true", tagValue(tags, ExcludeTag))
+	assert.Equal(t, "true", tagValue(tags, VerificationTag))
+	assert.False(t, hasTag(tags, BackupKeyTag))
+	assert.Equal(t, "data", tagValue(tags, "Name"))
+	assert.Len(t, srv.Volumes(), 1)
+	assert.Equal(t, *volume.VolumeId, *srv.Volumes()[0].VolumeId)
+}
+
+func TestListSnapshotsKeepsFinalAndUnexpiredSnapshots(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	volume := srv.AddVolume(&ec2.Volume{Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1")}}})
+	unexpiredTag := &ec2.Tag{Key: aws.String(ExpiresAtTag), Value: aws.String(time.Now().Add(time.Hour).Format(time.RFC3339))}
+	finalTag := &ec2.Tag{Key: aws.String(FinalSnapshotTag), Value: aws.String("true")}
+	snapshots := []*ec2.Snapshot{
+		srv.AddSnapshot(&ec2.Snapshot{VolumeId: volume.VolumeId, Tags: []*ec2.Tag{unexpiredTag}}),
+		srv.AddSnapshot(&ec2.Snapshot{VolumeId: volume.VolumeId}),
+		srv.AddSnapshot(&ec2.Snapshot{VolumeId: volume.VolumeId, Tags: []*ec2.Tag{finalTag}}),
+		srv.AddSnapshot(&ec2.Snapshot{VolumeId: volume.VolumeId}),
+		srv.AddSnapshot(&ec2.Snapshot{VolumeId: volume.VolumeId}),
+	}
+
+	mgr := newManager(t, "us-west-1", srv.URL, false, 1, false)
+	listings, err := mgr.ListSnapshots(nil)
+	assert.NoError(t, err)
+
+	expired := map[string]bool{}
+	for _, snapshot := range listings[0].Snapshots {
+		expired[*snapshot.SnapshotId] = snapshot.Expired
+	}
+	assert.Equal(t, map[string]bool{
+		*snapshots[0].SnapshotId: false,
+		*snapshots[1].SnapshotId: true,
+		*snapshots[2].SnapshotId: false,
+		*snapshots[3].SnapshotId: true,
+		*snapshots[4].SnapshotId: false,
+	}, expired)
+}
+
+func TestReapUnattachedReusesFinalSnapshot(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	since := time.Now().Add(-60 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	volume := srv.AddVolume(&ec2.Volume{Tags: []*ec2.Tag{{Key: aws.String(UnattachedSinceTag), Value: aws.String(since)}}})
+	stale := srv.AddSnapshot(&ec2.Snapshot{
+		VolumeId:  volume.VolumeId,
+		StartTime: aws.Time(time.Now().Add(-90 * 24 * time.Hour)),
+		Tags:      []*ec2.Tag{{Key: aws.String(FinalSnapshotTag), Value: aws.String("true")}},
+	})
+
+	mgr := newManager(t, "us-west-1", srv.URL, false, 1, false)
+	opts := ReapOptions{UnattachedFor: 30 * 24 * time.Hour}
+
+	reaped, err := mgr.ReapUnattached(opts)
+	assert.NoError(t, err)
+	assert.Len(t, reaped, 1)
+	final := reaped[0].SnapshotID
+	assert.NotEqual(t, *stale.SnapshotId, final)
+
+	reaped, err = mgr.ReapUnattached(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, final, reaped[0].SnapshotID)
+	assert.Equal(t, 1, srv.Calls("CreateSnapshot"))
+
+	opts.Delete = true
+	reaped, err = mgr.ReapUnattached(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, final, reaped[0].SnapshotID)
+	assert.True(t, reaped[0].Deleted)
+	assert.Empty(t, reaped[0].Error)
+	assert.Equal(t, 1, srv.Calls("CreateSnapshot"))
+	assert.Nil(t, srv.Volume(*volume.VolumeId))
+	assert.Len(t, srv.Snapshots(), 2)
+}
+
+func TestBookkeepingTagsAreNotCopied(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	tag := func(key string, value string) *ec2.Tag {
+		return &ec2.Tag{Key: aws.String(key), Value: aws.String(value)}
+	}
+	bookkeeping := []*ec2.Tag{
+		tag(ExpiresAtTag, "2017-01-01T00:00:00Z"),
+		tag(FinalSnapshotTag, "true"),
+		tag(SourceVolumeTag, "vol-original"),
+		tag(UnattachedSinceTag, "2017-01-01T00:00:00Z"),
+		tag(RestoredFromTag, "snap-original"),
+		tag(VerificationTag, "true"),
+		tag(InstanceIDTag, "i-1a2b3c4d"),
+		tag(DeviceTag, "/dev/sdf"),
+		tag(AvailabilityZoneTag, "us-west-1a"),
+	}
+	config := []*ec2.Tag{tag("Team", "data"), tag(BackupKeyTag, "db-data"), tag(ExcludeTag, "true")}
+
+	mgr := newManager(t, "us-west-1", srv.URL, true, 1, false)
+	volume := &ec2.Volume{VolumeId: aws.String("vol-1a2b3c4d"), Tags: append(append([]*ec2.Tag{}, config...), bookkeeping...)}
+	assert.Equal(t, config, mgr.copiedTags(volume))
+
+	snapshot := srv.AddSnapshot(&ec2.Snapshot{VolumeId: aws.String("vol-1a2b3c4d"), VolumeSize: aws.Int64(8), Tags: volume.Tags})
+	restored, err := mgr.RestoreVolume(RestoreOptions{SnapshotID: *snapshot.SnapshotId, AvailabilityZone: "us-west-1a"})
+	assert.NoError(t, err)
+
+	tags := map[string]string{}
+	for _, tag := range srv.Volume(*restored.VolumeId).Tags {
+		tags[*tag.Key] = *tag.Value
+	}
+	assert.Equal(t, map[string]string{
+		"Team":          "data",
+		BackupKeyTag:    "db-data",
+		ExcludeTag:      "true",
+		RestoredFromTag: *snapshot.SnapshotId,
+	}, tags)
+}
+
+func TestNewestSnapshotIgnoresPendingSnapshots(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	snapshot := func(id string, hours int, state string) *ec2.Snapshot {
+		return &ec2.Snapshot{SnapshotId: aws.String(id), StartTime: aws.Time(start.Add(time.Duration(hours) * time.Hour)), State: aws.String(state)}
+	}
+	snapshots := []*ec2.Snapshot{
+		snapshot("snap-1", 0, ec2.SnapshotStateCompleted),
+		snapshot("snap-2", 24, ec2.SnapshotStateCompleted),
+		snapshot("snap-3", 48, ec2.SnapshotStateError),
+		snapshot("snap-4", 72, ec2.SnapshotStatePending),
+	}
+
+	assert.Equal(t, "snap-2", *newestCompleted(snapshots, nil).SnapshotId)
+	assert.Equal(t, "snap-1", *newestCompleted(snapshots, []string{"snap-2"}).SnapshotId)
+	assert.Nil(t, newestCompleted(snapshots[2:], nil))
+}
+
+func TestAuditCoverageGroupsByBackupKey(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	key := []*ec2.Tag{{Key: aws.String(BackupKeyTag), Value: aws.String("db-data")}}
+	replacement := srv.AddVolume(&ec2.Volume{Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-2")}}, Tags: key})
+	unkeyed := srv.AddVolume(&ec2.Volume{Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-3")}}})
+	backup := srv.AddSnapshot(&ec2.Snapshot{VolumeId: aws.String("vol-replaced"), VolumeSize: aws.Int64(8), Tags: key})
+
+	mgr := newManager(t, "us-west-1", srv.URL, false, 1, false)
+	coverage, err := mgr.AuditCoverage(26 * time.Hour)
+	assert.NoError(t, err)
+	assert.Len(t, coverage.Volumes, 2)
+
+	assert.Equal(t, *replacement.VolumeId, coverage.Volumes[0].VolumeID)
+	assert.Equal(t, CoverageOK, coverage.Volumes[0].Status)
+	assert.Equal(t, *backup.SnapshotId, coverage.Volumes[0].NewestSnapshotID)
+
+	assert.Equal(t, *unkeyed.VolumeId, coverage.Volumes[1].VolumeID)
+	assert.Equal(t, CoverageNoSnapshot, coverage.Volumes[1].Status)
+	assert.Equal(t, 1, coverage.NoSnapshot)
+}
+
+func TestRequireEncryptionKeepsOriginalsProtectedByFloor(t *testing.T) {
+	defer func(count int, age time.Duration) { floorCount, floorAge = count, age }(floorCount, floorAge)
+	floorCount, floorAge = 0, 24*time.Hour
+
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	volume := srv.AddVolume(&ec2.Volume{Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1")}}})
+	original := srv.AddSnapshot(&ec2.Snapshot{VolumeId: volume.VolumeId, VolumeSize: aws.Int64(8)})
+
+	mgr := newManager(t, "us-west-1", srv.URL, false, 5, false)
+	mgr.RequireEncryption = true
+	observer := &recordingObserver{}
+	mgr.Observers = []Observer{observer}
+
+	assert.NoError(t, mgr.encryptSnapshots(volume))
+	assert.NoError(t, mgr.encryptSnapshots(volume))
+	assert.NotNil(t, srv.Snapshot(*original.SnapshotId))
+	assert.Contains(t, observer.events, "floor kept "+*original.SnapshotId)
+
+	floorAge = 0
+	assert.NoError(t, mgr.encryptSnapshots(volume))
+	assert.Nil(t, srv.Snapshot(*original.SnapshotId))
+	assert.Contains(t, observer.events, "deleted "+*original.SnapshotId)
+}
+
+func TestListSnapshotsMatchesRunWithoutSideEffects(t *testing.T) {
+	defer func(count int, age time.Duration) { floorCount, floorAge = count, age }(floorCount, floorAge)
+	floorCount, floorAge = 2, 0
+
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	volume := srv.AddVolume(&ec2.Volume{Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1")}}})
+	oldest := srv.AddSnapshot(&ec2.Snapshot{VolumeId: volume.VolumeId, VolumeSize: aws.Int64(8)})
+	original := srv.AddSnapshot(&ec2.Snapshot{VolumeId: volume.VolumeId, VolumeSize: aws.Int64(8)})
+	copied := srv.AddSnapshot(&ec2.Snapshot{
+		VolumeId:  aws.String("vol-ffffffff"),
+		Encrypted: aws.Bool(true),
+		State:     aws.String(ec2.SnapshotStatePending),
+		Tags: []*ec2.Tag{
+			{Key: aws.String(SourceVolumeTag), Value: volume.VolumeId},
+			{Key: aws.String(SourceSnapshotTag), Value: original.SnapshotId},
+		},
+	})
+	newest := srv.AddSnapshot(&ec2.Snapshot{VolumeId: volume.VolumeId, VolumeSize: aws.Int64(8)})
+
+	mgr := newManager(t, "us-west-1", srv.URL, false, 1, false)
+	mgr.RequireEncryption = true
+	observer := &recordingObserver{}
+	mgr.Observers = []Observer{observer}
+
+	listings, err := mgr.ListSnapshots(nil)
+	assert.NoError(t, err)
+
+	expired := map[string]bool{}
+	for _, snapshot := range listings[0].Snapshots {
+		expired[*snapshot.SnapshotId] = snapshot.Expired
+	}
+	// the pending copy isn't counted, so the floor of 2 keeps the original and the newest
+	assert.Equal(t, map[string]bool{
+		*oldest.SnapshotId:   true,
+		*original.SnapshotId: false,
+		*copied.SnapshotId:   false,
+		*newest.SnapshotId:   false,
+	}, expired)
+	assert.Empty(t, observer.events)
+	assert.Len(t, srv.Snapshots(), 4)
+}
+
+func TestVerifyDeletesStaleTemporaryVolumes(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	verification := []*ec2.Tag{{Key: aws.String(VerificationTag), Value: aws.String("true")}}
+	stale := srv.AddVolume(&ec2.Volume{CreateTime: aws.Time(time.Now().Add(-2 * VerificationTimeout)), Tags: verification})
+	recent := srv.AddVolume(&ec2.Volume{Tags: verification})
+
+	mgr := newManager(t, "us-west-1", srv.URL, false, 1, false)
+	observer := &recordingObserver{}
+	mgr.Observers = []Observer{observer}
+
+	result := mgr.Verify(VerifyOptions{})
+	assert.False(t, result.Failed())
+	assert.Nil(t, srv.Volume(*stale.VolumeId))
+	assert.NotNil(t, srv.Volume(*recent.VolumeId))
+	assert.Equal(t, []string{"deleting temporary " + *stale.VolumeId}, observer.events)
+}
+
+func TestTagsChangedIgnoresBookkeepingTags(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	volume := srv.AddVolume(&ec2.Volume{
+		Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1")}},
+		Tags:        []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("data")}},
+	})
+	// the previous snapshot is a final snapshot taken while the volume was unattached
+	srv.AddSnapshot(&ec2.Snapshot{
+		VolumeId: volume.VolumeId,
+		Tags: []*ec2.Tag{
+			{Key: aws.String("Name"), Value: aws.String("data")},
+			{Key: aws.String(FinalSnapshotTag), Value: aws.String("true")},
+			{Key: aws.String(UnattachedSinceTag), Value: aws.String("2017-03-01T09:00:00Z")},
+		},
+	})
+
+	mgr := newManager(t, "us-west-1", srv.URL, true, 2, false)
+
+	result := mgr.Run()
+	assert.False(t, result.Failed())
+	assert.Len(t, result.Volumes, 1)
+	assert.False(t, result.Volumes[0].TagsChanged)
+}
+
+func TestReapUnattachedEncryptsFinalSnapshots(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	since := time.Now().Add(-60 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	unattached := []*ec2.Tag{{Key: aws.String(UnattachedSinceTag), Value: aws.String(since)}}
+	volume := srv.AddVolume(&ec2.Volume{Size: aws.Int64(8), Tags: unattached})
+	// a final snapshot that is still pending can't be copied yet
+	pendingVolume := srv.AddVolume(&ec2.Volume{Size: aws.Int64(8), Tags: unattached})
+	pending := srv.AddSnapshot(&ec2.Snapshot{
+		VolumeId:   pendingVolume.VolumeId,
+		VolumeSize: aws.Int64(8),
+		State:      aws.String(ec2.SnapshotStatePending),
+		Tags:       []*ec2.Tag{{Key: aws.String(FinalSnapshotTag), Value: aws.String("true")}},
+	})
+
+	mgr := newManager(t, "us-west-1", srv.URL, false, 1, false)
+	mgr.RequireEncryption = true
+
+	reaped, err := mgr.ReapUnattached(ReapOptions{UnattachedFor: 30 * 24 * time.Hour})
+	assert.NoError(t, err)
+	assert.Len(t, reaped, 2)
+	outcomes := map[string]ReapedVolume{}
+	for _, outcome := range reaped {
+		outcomes[outcome.VolumeID] = outcome
+	}
+
+	replaced := srv.Snapshot(outcomes[*volume.VolumeId].SnapshotID)
+	assert.True(t, *replaced.Encrypted)
+	assert.Equal(t, "true", tagValue(replaced.Tags, FinalSnapshotTag))
+	assert.Equal(t, *volume.VolumeId, tagValue(replaced.Tags, SourceVolumeTag))
+	assert.False(t, outcomes[*volume.VolumeId].Unencrypted)
+
+	assert.Equal(t, *pending.SnapshotId, outcomes[*pendingVolume.VolumeId].SnapshotID)
+	assert.True(t, outcomes[*pendingVolume.VolumeId].Unencrypted)
+	assert.Equal(t, 1, srv.Calls("CopySnapshot"))
+	assert.Len(t, srv.Snapshots(), 2)
+
+	// the next reap finds the encrypted copy rather than taking another final snapshot
+	snapshot, copied, err := mgr.finalSnapshot(srv.Volume(*volume.VolumeId), time.Now().Add(-60*24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, *replaced.SnapshotId, *snapshot.SnapshotId)
+	assert.Nil(t, copied)
+}
+
+func TestReapUnattachedDeletesVolumesOnceEncrypted(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	since := time.Now().Add(-60 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	unattached := []*ec2.Tag{{Key: aws.String(UnattachedSinceTag), Value: aws.String(since)}}
+	first := srv.AddVolume(&ec2.Volume{Size: aws.Int64(8), Tags: unattached})
+	second := srv.AddVolume(&ec2.Volume{Size: aws.Int64(8), Tags: unattached})
+
+	mgr := newManager(t, "us-west-1", srv.URL, false, 1, false)
+	mgr.RequireEncryption = true
+	observer := &recordingObserver{}
+	mgr.Observers = []Observer{observer}
+
+	reaped, err := mgr.ReapUnattached(ReapOptions{UnattachedFor: 30 * 24 * time.Hour, Delete: true})
+	assert.NoError(t, err)
+	assert.Len(t, reaped, 2)
+	for _, outcome := range reaped {
+		assert.True(t, outcome.Deleted)
+		assert.False(t, outcome.Unencrypted)
+		assert.Empty(t, outcome.Error)
+		assert.True(t, *srv.Snapshot(outcome.SnapshotID).Encrypted)
+	}
+	assert.Nil(t, srv.Volume(*first.VolumeId))
+	assert.Nil(t, srv.Volume(*second.VolumeId))
+	assert.Len(t, srv.Snapshots(), 2)
+
+	// both final snapshots are taken before either volume is waited for and deleted
+	assert.Equal(t, "reaping "+*second.VolumeId, observer.events[3])
+	assert.Equal(t, "deleting unattached "+*first.VolumeId, observer.events[len(observer.events)-2])
+}
+
+func TestAuditExposureChecksManagedSnapshots(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	volume := srv.AddVolume(&ec2.Volume{Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1")}}})
+	managed := srv.AddSnapshot(&ec2.Snapshot{VolumeId: volume.VolumeId})
+	final := srv.AddSnapshot(&ec2.Snapshot{
+		VolumeId: aws.String("vol-reaped"),
+		Tags:     []*ec2.Tag{{Key: aws.String(FinalSnapshotTag), Value: aws.String("true")}},
+	})
+	unmanaged := srv.AddSnapshot(&ec2.Snapshot{VolumeId: aws.String("vol-other")})
+
+	mgr := newManager(t, "us-west-1", srv.URL, false, 1, false)
+	for _, snapshot := range []*ec2.Snapshot{managed, final, unmanaged} {
+		_, err := mgr.ec2.ModifySnapshotAttribute(&ec2.ModifySnapshotAttributeInput{
+			SnapshotId: snapshot.SnapshotId,
+			Attribute:  aws.String(ec2.SnapshotAttributeNameCreateVolumePermission),
+			CreateVolumePermission: &ec2.CreateVolumePermissionModifications{
+				Add: []*ec2.CreateVolumePermission{{Group: aws.String(ec2.PermissionGroupAll)}},
+			},
+		})
+		assert.NoError(t, err)
+	}
+
+	exposures, err := mgr.AuditExposure(ExposureOptions{Remediate: true})
+	assert.NoError(t, err)
+	var exposed []string
+	for _, exposure := range exposures {
+		exposed = append(exposed, exposure.SnapshotID)
+	}
+	assert.Equal(t, []string{*managed.SnapshotId, *final.SnapshotId}, exposed)
+	assert.Empty(t, srv.SnapshotPermissions(*managed.SnapshotId))
+	assert.NotEmpty(t, srv.SnapshotPermissions(*unmanaged.SnapshotId))
+
+	exposures, err = mgr.AuditExposure(ExposureOptions{AllSnapshots: true})
+	assert.NoError(t, err)
+	assert.Len(t, exposures, 1)
+	assert.Equal(t, *unmanaged.SnapshotId, exposures[0].SnapshotID)
+}
+
+func TestDefaultBuildEnforcesRetentionFloor(t *testing.T) {
+	defer func(count int, age time.Duration) { floorCount, floorAge = count, age }(floorCount, floorAge)
+
+	var err error
+	floorCount, floorAge, err = parseFloor(minRetainCount, minRetainAge)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, floorCount)
+	assert.Equal(t, 840*time.Hour, floorAge)
+
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+	volume := srv.AddVolume(&ec2.Volume{Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1")}}})
+	for i := 0; i < 3; i++ {
+		srv.AddSnapshot(&ec2.Snapshot{VolumeId: volume.VolumeId, StartTime: aws.Time(time.Now().Add(-1000 * time.Hour))})
+	}
+
+	mgr := newManager(t, "us-west-1", srv.URL, false, 1, false)
+	result := mgr.Run()
+	assert.False(t, result.Failed())
+	assert.Len(t, srv.Snapshots(), 4)
+
+	_, _, err = parseFloor("-1", "0")
+	assert.Error(t, err)
+	_, _, err = parseFloor("0", "a week")
+	assert.Error(t, err)
+}
+
+func TestNewSnapshotManagerReturnsErrors(t *testing.T) {
+	_, err := NewSnapshotManager("", "", false, 1, false)
+	assert.EqualError(t, err, "region is required")
+
+	_, err = NewSnapshotManager("us-west-1", "", false, 0, false)
+	assert.EqualError(t, err, "NumSnapshotsToRetain should be greater than 0")
+
+	defer func(err error) { floorErr = err }(floorErr)
+	_, _, floorErr = parseFloor("seven", "840h")
+	_, err = NewSnapshotManager("us-west-1", "", false, 1, false)
+	assert.EqualError(t, err, `invalid compiled in minimum retention count "seven"`)
+}
+
+func TestRunFailsWithoutValidRetention(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	volume := srv.AddVolume(&ec2.Volume{Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1")}}})
+	srv.AddSnapshot(&ec2.Snapshot{VolumeId: volume.VolumeId})
+
+	mgr := newManager(t, "us-west-1", srv.URL, false, 1, false)
+	mgr.NumSnapshotsToRetain = 0
+
+	result := mgr.Run()
+	assert.True(t, result.Failed())
+	assert.Len(t, srv.Snapshots(), 1)
+
+	_, err := mgr.ListSnapshots(nil)
+	assert.Error(t, err)
+}
+
+func TestEstimateCostProjectsBackupKeyGroups(t *testing.T) {
+	srv := ebstest.NewServer("us-west-1")
+	defer srv.Close()
+
+	key := []*ec2.Tag{{Key: aws.String(BackupKeyTag), Value: aws.String("db-data")}}
+	replacement := srv.AddVolume(&ec2.Volume{
+		Size:        aws.Int64(8),
+		Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-2")}},
+		Tags:        key,
+	})
+	srv.AddSnapshot(&ec2.Snapshot{VolumeId: aws.String("vol-replaced"), VolumeSize: aws.Int64(8), Tags: key})
+	original := srv.AddSnapshot(&ec2.Snapshot{VolumeId: aws.String("vol-replaced"), VolumeSize: aws.Int64(8), Tags: key})
+	srv.AddSnapshot(&ec2.Snapshot{
+		VolumeId:   aws.String("vol-ffffffff"),
+		VolumeSize: aws.Int64(8),
+		Encrypted:  aws.Bool(true),
+		State:      aws.String(ec2.SnapshotStatePending),
+		Tags: []*ec2.Tag{
+			{Key: aws.String(BackupKeyTag), Value: aws.String("db-data")},
+			{Key: aws.String(SourceVolumeTag), Value: aws.String("vol-replaced")},
+			{Key: aws.String(SourceSnapshotTag), Value: original.SnapshotId},
+		},
+	})
+	srv.AddSnapshot(&ec2.Snapshot{VolumeId: replacement.VolumeId, VolumeSize: aws.Int64(8), Tags: key})
+
+	// the replaced volume's snapshots are in the replacement's retention group, so retaining 1
+	// deletes them and cancels the pending copy
+	mgr := newManager(t, "us-west-1", srv.URL, false, 3, false)
+	mgr.RequireEncryption = true
+	estimate, err := mgr.EstimateCost(CostOptions{Price: 0.05, ChangeRate: 1, ProposedRetain: 1})
+	assert.NoError(t, err)
+	assert.Len(t, estimate.Volumes, 2)
+
+	assert.Equal(t, *replacement.VolumeId, estimate.Volumes[0].VolumeID)
+	assert.Equal(t, 1, estimate.Volumes[0].ProposedSnapshots)
+
+	replaced := estimate.Volumes[1]
+	assert.Equal(t, "vol-replaced", replaced.VolumeID)
+	assert.False(t, replaced.Managed)
+	assert.Equal(t, 3, replaced.Snapshots)
+	assert.Equal(t, 0, replaced.ProposedSnapshots)
+	assert.InDelta(t, 0, replaced.ProposedMonthly, 0.001)
+	assert.InDelta(t, 0.4, estimate.ProposedMonthly, 0.001)
+
+	// the pending copy counts with its original, so retaining 2 keeps both
+	estimate, err = mgr.EstimateCost(CostOptions{Price: 0.05, ChangeRate: 1, ProposedRetain: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, estimate.Volumes[0].ProposedSnapshots)
+	assert.Equal(t, 2, estimate.Volumes[1].ProposedSnapshots)
+}
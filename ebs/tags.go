@@ -0,0 +1,261 @@
+package ebs
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Attachment metadata tags added to snapshots when AttachmentTags is set, recording where the
+// volume was attached when the snapshot was taken
+const (
+	InstanceIDTag       = "ebs_snapshotter:instance_id"
+	DeviceTag           = "ebs_snapshotter:device"
+	AvailabilityZoneTag = "ebs_snapshotter:availability_zone"
+)
+
+// bookkeepingTagKeys are the tags that record the state of the resource they were added to, so
+// they are never copied between volumes and snapshots. BackupKeyTag and ExcludeTag configure
+// the volume and are copied.
+var bookkeepingTagKeys = []string{
+	ExpiresAtTag,
+	FinalSnapshotTag,
+	SourceVolumeTag,
+	SourceSnapshotTag,
+	UnattachedSinceTag,
+	RestoredFromTag,
+	VerificationTag,
+	InstanceIDTag,
+	DeviceTag,
+	AvailabilityZoneTag,
+}
+
+// SnapshotTemplateData is the data available to the snapshot description and Name templates:
+//
+//	{{.Policy}} backup of {{.VolumeID}} ({{.InstanceID}}:{{.Device}}) {{.Time.Format "2006-01-02"}}
+type SnapshotTemplateData struct {
+	// The EBS volume ID and the value of its Name tag, if any
+	VolumeID   string
+	VolumeName string
+
+	// The instance the volume is attached to and its device name
+	InstanceID string
+	Device     string
+
+	// The volume's availability zone and region
+	AvailabilityZone string
+	Region           string
+
+	// The SnapshotManager's backup policy name
+	Policy string
+
+	// When the snapshot was started
+	Time time.Time
+}
+
+// templateData returns the template data for a snapshot of the volume started now
+func (mgr *SnapshotManager) templateData(volume *ec2.Volume) SnapshotTemplateData {
+	data := SnapshotTemplateData{
+		VolumeID:         aws.StringValue(volume.VolumeId),
+		VolumeName:       volumeName(volume),
+		AvailabilityZone: aws.StringValue(volume.AvailabilityZone),
+		Region:           mgr.Region,
+		Policy:           mgr.Policy,
+		Time:             time.Now().UTC(),
+	}
+
+	if len(volume.Attachments) > 0 {
+		data.InstanceID = aws.StringValue(volume.Attachments[0].InstanceId)
+		data.Device = aws.StringValue(volume.Attachments[0].Device)
+	}
+	return data
+}
+
+// snapshotDescription returns the description for a new snapshot of the volume, rendered from
+// DescriptionTemplate if set. Otherwise the volume's Name tag is used if it has one.
+func (mgr *SnapshotManager) snapshotDescription(volume *ec2.Volume, data SnapshotTemplateData) (string, error) {
+	if mgr.DescriptionTemplate != nil {
+		return renderTemplate(mgr.DescriptionTemplate, data)
+	}
+
+	if name := volumeName(volume); name != "" {
+		return name, nil
+	}
+	return fmt.Sprintf("Snapshot for volume %s", *volume.VolumeId), nil
+}
+
+// snapshotTags returns the tags for a new snapshot of the volume: the copied volume tags if
+// CopyVolumeTags is set, the InstanceTagKeys of the attached instance not already copied from
+// the volume, the volume's backup key and the attachment metadata tags if AttachmentTags is
+// set, overridden by the static Tags, the ExpiresAtTag if RetentionPeriod is set and the Name
+// rendered from NameTemplate
+func (mgr *SnapshotManager) snapshotTags(volume *ec2.Volume, data SnapshotTemplateData) ([]*ec2.Tag, error) {
+	var tags []*ec2.Tag
+	if mgr.CopyVolumeTags {
+		tags = mgr.copiedTags(volume)
+	}
+
+	if len(mgr.InstanceTagKeys) > 0 && data.InstanceID != "" {
+		instanceTags, err := mgr.instanceTags(data.InstanceID)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range filterReservedTags(instanceTags) {
+			if contains(mgr.InstanceTagKeys, *tag.Key) && !hasTag(tags, *tag.Key) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	if key := mgr.backupKey(volume); key != "" {
+		tags = setTag(tags, BackupKeyTag, key)
+	}
+
+	if mgr.AttachmentTags && data.InstanceID != "" {
+		tags = setTag(tags, InstanceIDTag, data.InstanceID)
+		tags = setTag(tags, DeviceTag, data.Device)
+		tags = setTag(tags, AvailabilityZoneTag, data.AvailabilityZone)
+	}
+
+	for _, tag := range mgr.Tags {
+		tags = setTag(tags, *tag.Key, *tag.Value)
+	}
+
+	if mgr.RetentionPeriod > 0 {
+		tags = setTag(tags, ExpiresAtTag, data.Time.Add(mgr.RetentionPeriod).Format(time.RFC3339))
+	}
+
+	if mgr.NameTemplate != nil {
+		name, err := renderTemplate(mgr.NameTemplate, data)
+		if err != nil {
+			return nil, err
+		}
+		tags = setTag(tags, "Name", name)
+	}
+
+	return tags, nil
+}
+
+// copiedTags returns the volume tags that are copied to its snapshots, limited to
+// IncludeTagKeys if set and without ExcludeTagKeys, tags reserved by AWS or bookkeeping tags
+func (mgr *SnapshotManager) copiedTags(volume *ec2.Volume) []*ec2.Tag {
+	var tags []*ec2.Tag
+	for _, tag := range withoutBookkeepingTags(filterReservedTags(volume.Tags)) {
+		if len(mgr.IncludeTagKeys) > 0 && !contains(mgr.IncludeTagKeys, *tag.Key) {
+			continue
+		}
+		if contains(mgr.ExcludeTagKeys, *tag.Key) {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// instanceTags returns the tags of an instance, from the tags loaded by loadInstanceTags
+// if present, otherwise by describing the instance
+func (mgr *SnapshotManager) instanceTags(instanceID string) ([]*ec2.Tag, error) {
+	if tags, ok := mgr.instances[instanceID]; ok {
+		return tags, nil
+	}
+
+	if err := mgr.loadInstanceTags([]string{instanceID}); err != nil {
+		return nil, err
+	}
+	return mgr.instances[instanceID], nil
+}
+
+// loadInstanceTags describes the instances in a single paged request and caches their tags
+// for instanceTags
+func (mgr *SnapshotManager) loadInstanceTags(instanceIDs []string) error {
+	if mgr.instances == nil {
+		mgr.instances = make(map[string][]*ec2.Tag)
+	}
+	if len(instanceIDs) == 0 {
+		return nil
+	}
+
+	params := &ec2.DescribeInstancesInput{InstanceIds: aws.StringSlice(instanceIDs)}
+	return mgr.ec2.DescribeInstancesPages(params, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				mgr.instances[*instance.InstanceId] = instance.Tags
+			}
+		}
+		return true
+	})
+}
+
+// attachedInstanceIDs returns the IDs of the instances the volumes are attached to
+func attachedInstanceIDs(volumes []*ec2.Volume) []string {
+	var instanceIDs []string
+	for _, volume := range volumes {
+		for _, attachment := range volume.Attachments {
+			if attachment.InstanceId != nil && !contains(instanceIDs, *attachment.InstanceId) {
+				instanceIDs = append(instanceIDs, *attachment.InstanceId)
+			}
+		}
+	}
+	return instanceIDs
+}
+
+// generatedTagKeys returns the keys of snapshot tags that are not copied from the volume
+func (mgr *SnapshotManager) generatedTagKeys() []string {
+	keys := append([]string{BackupKeyTag}, bookkeepingTagKeys...)
+	keys = append(keys, mgr.InstanceTagKeys...)
+	for _, tag := range mgr.Tags {
+		keys = append(keys, *tag.Key)
+	}
+	if mgr.NameTemplate != nil {
+		keys = append(keys, "Name")
+	}
+	return keys
+}
+
+// setTag sets the value of the tag with the given key, appending it if it isn't present
+func setTag(tags []*ec2.Tag, key string, value string) []*ec2.Tag {
+	for i, tag := range tags {
+		if *tag.Key == key {
+			tags[i] = &ec2.Tag{Key: aws.String(key), Value: aws.String(value)}
+			return tags
+		}
+	}
+	return append(tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+}
+
+func hasTag(tags []*ec2.Tag, key string) bool {
+	for _, tag := range tags {
+		if *tag.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutKeys returns the tags whose keys are not in keys
+func withoutKeys(tags []*ec2.Tag, keys []string) []*ec2.Tag {
+	var filtered []*ec2.Tag
+	for _, tag := range tags {
+		if !contains(keys, *tag.Key) {
+			filtered = append(filtered, tag)
+		}
+	}
+	return filtered
+}
+
+// withoutBookkeepingTags returns the tags that aren't bookkeepingTagKeys
+func withoutBookkeepingTags(tags []*ec2.Tag) []*ec2.Tag {
+	return withoutKeys(tags, bookkeepingTagKeys)
+}
+
+func renderTemplate(tmpl *template.Template, data SnapshotTemplateData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
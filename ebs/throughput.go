@@ -0,0 +1,89 @@
+package ebs
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// Volume types newer than the vendored AWS SDK
+const (
+	volumeTypeIo2 = "io2"
+	volumeTypeGp3 = "gp3"
+)
+
+// hasProvisionedIops returns true if volumes of the type have IOPS that can be set when
+// they are created
+func hasProvisionedIops(volumeType string) bool {
+	switch volumeType {
+	case ec2.VolumeTypeIo1, volumeTypeIo2, volumeTypeGp3:
+		return true
+	}
+	return false
+}
+
+// volumeThroughput returns the provisioned throughput in MiB/s of a gp3 volume, or 0 if it
+// isn't reported. The vendored AWS SDK predates gp3, so it is read from the raw
+// DescribeVolumes response.
+func (mgr *SnapshotManager) volumeThroughput(volumeID *string) (int64, error) {
+	var body []byte
+	capture := func(r *request.Request) {
+		r.Handlers.Unmarshal.PushFront(func(r *request.Request) {
+			var err error
+			if body, err = ioutil.ReadAll(r.HTTPResponse.Body); err != nil {
+				r.Error = err
+				return
+			}
+			r.HTTPResponse.Body = ioutil.NopCloser(bytes.NewReader(body))
+		})
+	}
+
+	params := &ec2.DescribeVolumesInput{VolumeIds: []*string{volumeID}}
+	if _, err := mgr.ec2.DescribeVolumesWithContext(aws.BackgroundContext(), params, capture); err != nil {
+		return 0, err
+	}
+
+	var resp struct {
+		Volumes []struct {
+			Throughput int64 `xml:"throughput"`
+		} `xml:"volumeSet>item"`
+	}
+	if err := xml.Unmarshal(body, &resp); err != nil {
+		return 0, err
+	}
+	if len(resp.Volumes) == 0 {
+		return 0, nil
+	}
+	return resp.Volumes[0].Throughput, nil
+}
+
+// withThroughput sets the Throughput parameter of a CreateVolume request, which the
+// vendored AWS SDK can't serialize
+func withThroughput(throughput int64) request.Option {
+	return func(r *request.Request) {
+		r.Handlers.Build.PushBack(func(r *request.Request) {
+			if r.Error != nil || r.Body == nil {
+				return
+			}
+
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				r.Error = err
+				return
+			}
+			values, err := url.ParseQuery(string(body))
+			if err != nil {
+				r.Error = err
+				return
+			}
+			values.Set("Throughput", strconv.FormatInt(throughput, 10))
+			r.SetBufferBody([]byte(values.Encode()))
+		})
+	}
+}
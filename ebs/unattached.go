@@ -0,0 +1,409 @@
+package ebs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// UnattachedSinceTag records when a volume was first seen unattached. EC2 doesn't record when
+// a volume was detached, so the tag is added by Run with IncludeUnattached set, and by
+// ReapUnattached, and removed once the volume is attached again.
+const UnattachedSinceTag = "ebs_snapshotter:unattached_since"
+
+// FinalSnapshotTag marks the snapshot taken of a volume before it is reaped. Final snapshots
+// are never deleted by the retention policy.
+const FinalSnapshotTag = "ebs_snapshotter:final_snapshot"
+
+// SnapshotWaitTimeout is how long ReapUnattached waits in all for final snapshots, and their
+// encrypted copies with RequireEncryption set, to complete before deleting volumes
+const SnapshotWaitTimeout = 12 * time.Hour
+
+// snapshotWaitDelay is the interval between checks of a snapshot's state
+const snapshotWaitDelay = 30 * time.Second
+
+// ReapedVolume describes an unattached volume selected by ReapUnattached
+type ReapedVolume struct {
+	VolumeID string `json:"volume_id"`
+	Name     string `json:"name,omitempty"`
+
+	// When the volume was first seen unattached
+	UnattachedSince time.Time `json:"unattached_since"`
+
+	// The final snapshot of the volume, or the encrypted copy that replaced it, unless this was
+	// a dry run
+	SnapshotID string `json:"snapshot_id,omitempty"`
+
+	// Whether the final snapshot is unencrypted with RequireEncryption set, as it or its
+	// encrypted copy hasn't completed yet. The volume isn't deleted until the copy replaces it.
+	Unencrypted bool `json:"unencrypted,omitempty"`
+
+	// Whether the volume was deleted after its final snapshot completed
+	Deleted bool `json:"deleted"`
+
+	// A description of the first error encountered for the volume, if any
+	Error string `json:"error,omitempty"`
+}
+
+// ReapOptions configures ReapUnattached
+type ReapOptions struct {
+	// How long a volume must have been unattached before it is reaped
+	UnattachedFor time.Duration
+
+	// Whether to delete each volume once its final snapshot completes
+	Delete bool
+
+	// If set, the volumes that would be reaped are returned without snapshotting or deleting
+	// them. Dry runs don't tag volumes with UnattachedSinceTag either, so they only report
+	// volumes already tagged by a snapshot run with IncludeUnattached set or by an earlier
+	// reap.
+	DryRun bool
+}
+
+// reaping tracks a volume selected by ReapUnattached
+type reaping struct {
+	volume *ec2.Volume
+
+	// The volume's final snapshot, and its encrypted copy while both exist
+	snapshot *ec2.Snapshot
+	copied   *ec2.Snapshot
+
+	outcome ReapedVolume
+}
+
+// ReapUnattached takes a final snapshot of each volume that has been unattached for longer
+// than UnattachedFor, tagged with FinalSnapshotTag, and optionally deletes the volume once the
+// snapshot completes. A volume that already has a final snapshot taken since it was unattached
+// isn't snapshotted again. Volumes seen unattached for the first time are only tagged with
+// UnattachedSinceTag, and excluded volumes are skipped.
+//
+// With RequireEncryption set, the final snapshots of unencrypted volumes are replaced with
+// encrypted copies like the snapshots taken by Run, and a volume is only deleted once its copy
+// has completed. Without Delete nothing is waited for, so the copy is made and replaces the
+// original on later reaps.
+func (mgr *SnapshotManager) ReapUnattached(opts ReapOptions) ([]ReapedVolume, error) {
+	params := &ec2.DescribeVolumesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("status"),
+				Values: []*string{aws.String(ec2.VolumeStateAvailable)},
+			},
+		},
+	}
+
+	var volumes []*ec2.Volume
+	err := mgr.ec2.DescribeVolumesPages(params, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		volumes = append(volumes, page.Volumes...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !opts.DryRun {
+		mgr.trackUnattached(volumes, now)
+	}
+
+	var selected []*reaping
+	for _, volume := range volumes {
+		if isExcluded(volume) {
+			continue
+		}
+
+		since, ok := unattachedSince(volume)
+		if !ok || now.Sub(since) < opts.UnattachedFor {
+			continue
+		}
+
+		selected = append(selected, &reaping{
+			volume:  volume,
+			outcome: ReapedVolume{VolumeID: *volume.VolumeId, Name: volumeName(volume), UnattachedSince: since},
+		})
+	}
+
+	if !opts.DryRun {
+		mgr.reapVolumes(selected, opts.Delete)
+	}
+
+	var reaped []ReapedVolume
+	for _, r := range selected {
+		reaped = append(reaped, r.outcome)
+	}
+	return reaped, nil
+}
+
+// reapVolumes takes the final snapshot of each volume, unless it has one taken since it was
+// unattached, encrypts it if RequireEncryption is set and optionally deletes the volume. When
+// deleting, the final snapshots and then their copies are waited for together, for at most
+// SnapshotWaitTimeout in all. Errors are reported to the Observers and recorded in the outcomes.
+func (mgr *SnapshotManager) reapVolumes(reapings []*reaping, delete bool) {
+	var started []*reaping
+	for _, r := range reapings {
+		if err := mgr.startReaping(r); err != nil {
+			mgr.failReaping(r, err)
+			continue
+		}
+		started = append(started, r)
+	}
+	if len(started) == 0 || !delete && !mgr.RequireEncryption {
+		return
+	}
+
+	deadline := time.Now()
+	if delete {
+		deadline = deadline.Add(SnapshotWaitTimeout)
+	}
+
+	var finals []*ec2.Snapshot
+	for _, r := range started {
+		finals = append(finals, r.snapshot)
+	}
+	refreshed, err := mgr.refreshSnapshots(finals, deadline)
+	if err != nil {
+		for _, r := range started {
+			mgr.failReaping(r, err)
+		}
+		return
+	}
+	for _, r := range started {
+		if snapshot, ok := refreshed[*r.snapshot.SnapshotId]; ok {
+			r.snapshot = snapshot
+		}
+	}
+
+	if mgr.RequireEncryption {
+		mgr.encryptFinalSnapshots(started, deadline)
+	}
+
+	if !delete {
+		return
+	}
+
+	for _, r := range started {
+		switch {
+		case r.outcome.Error != "":
+			continue
+		case !isCompleted(r.snapshot):
+			mgr.failReaping(r, fmt.Errorf("final snapshot %s is %s", *r.snapshot.SnapshotId, aws.StringValue(r.snapshot.State)))
+		case r.outcome.Unencrypted:
+			mgr.failReaping(r, fmt.Errorf("final snapshot %s hasn't been replaced by an encrypted copy", *r.snapshot.SnapshotId))
+		default:
+			mgr.observe(func(o Observer) { o.OnVolumeDeleting(mgr.Region, r.volume, "unattached") })
+
+			if _, err := mgr.ec2.DeleteVolume(&ec2.DeleteVolumeInput{VolumeId: r.volume.VolumeId}); err != nil {
+				mgr.failReaping(r, err)
+				continue
+			}
+			r.outcome.Deleted = true
+		}
+	}
+}
+
+// startReaping finds or takes the final snapshot of a volume, without waiting for it
+func (mgr *SnapshotManager) startReaping(r *reaping) error {
+	snapshot, copied, err := mgr.finalSnapshot(r.volume, r.outcome.UnattachedSince)
+	if err != nil {
+		return err
+	}
+
+	if snapshot == nil {
+		mgr.observe(func(o Observer) { o.OnVolumeReaping(mgr.Region, r.volume) })
+
+		snapshot, err = mgr.createSnapshot(r.volume)
+		if snapshot != nil {
+			r.outcome.SnapshotID = *snapshot.SnapshotId
+		}
+		if err != nil {
+			return err
+		}
+
+		final := []*ec2.Tag{{Key: aws.String(FinalSnapshotTag), Value: aws.String("true")}}
+		if err := mgr.tagSnapshot(snapshot.SnapshotId, final); err != nil {
+			return err
+		}
+	}
+
+	r.snapshot, r.copied = snapshot, copied
+	r.outcome.SnapshotID = *snapshot.SnapshotId
+	return nil
+}
+
+// encryptFinalSnapshots copies each completed, unencrypted final snapshot that doesn't have an
+// encrypted copy yet, waits for the copies until the deadline and replaces the final snapshots
+// whose copies have completed. Final snapshots aren't subject to the retention policy, so the
+// retention floor doesn't keep the originals.
+func (mgr *SnapshotManager) encryptFinalSnapshots(reapings []*reaping, deadline time.Time) {
+	var copying []*reaping
+	for _, r := range reapings {
+		if r.outcome.Error != "" || aws.BoolValue(r.snapshot.Encrypted) {
+			continue
+		}
+		if r.copied == nil && isCompleted(r.snapshot) {
+			copied, err := mgr.copySnapshot(r.volume, r.snapshot)
+			if err != nil {
+				mgr.failReaping(r, err)
+				continue
+			}
+			r.copied = copied
+		}
+		if r.copied != nil {
+			copying = append(copying, r)
+		}
+	}
+
+	var copies []*ec2.Snapshot
+	for _, r := range copying {
+		copies = append(copies, r.copied)
+	}
+	refreshed, err := mgr.refreshSnapshots(copies, deadline)
+	if err != nil {
+		for _, r := range copying {
+			mgr.failReaping(r, err)
+		}
+		return
+	}
+
+	for _, r := range copying {
+		if copied, ok := refreshed[*r.copied.SnapshotId]; ok {
+			r.copied = copied
+		}
+		if !isCompleted(r.copied) {
+			continue
+		}
+
+		original, copied := r.snapshot, r.copied
+		mgr.observe(func(o Observer) { o.OnSnapshotReplaced(mgr.Region, original, *copied.SnapshotId) })
+		deleted, err := mgr.deleteSnapshot(original)
+		if err != nil {
+			mgr.failReaping(r, err)
+			continue
+		}
+		if deleted {
+			r.snapshot, r.copied = copied, nil
+			r.outcome.SnapshotID = *copied.SnapshotId
+		}
+	}
+
+	for _, r := range reapings {
+		r.outcome.Unencrypted = !aws.BoolValue(r.snapshot.Encrypted)
+	}
+}
+
+// failReaping reports an error to the Observers and records it in the outcome, unless an
+// earlier error was recorded
+func (mgr *SnapshotManager) failReaping(r *reaping, err error) {
+	mgr.observeError(r.volume, err)
+	if r.outcome.Error == "" {
+		r.outcome.Error = err.Error()
+	}
+}
+
+// refreshSnapshots describes the snapshots again, checking every snapshotWaitDelay until none
+// of them is pending or the deadline would pass, and returns them by ID
+func (mgr *SnapshotManager) refreshSnapshots(snapshots []*ec2.Snapshot, deadline time.Time) (map[string]*ec2.Snapshot, error) {
+	refreshed := map[string]*ec2.Snapshot{}
+	if len(snapshots) == 0 {
+		return refreshed, nil
+	}
+
+	var ids []*string
+	for _, snapshot := range snapshots {
+		ids = append(ids, snapshot.SnapshotId)
+	}
+
+	for {
+		pending := false
+		params := &ec2.DescribeSnapshotsInput{SnapshotIds: ids}
+		err := mgr.ec2.DescribeSnapshotsPages(params, func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+			for _, snapshot := range page.Snapshots {
+				refreshed[*snapshot.SnapshotId] = snapshot
+				if aws.StringValue(snapshot.State) == ec2.SnapshotStatePending {
+					pending = true
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if !pending || time.Now().Add(snapshotWaitDelay).After(deadline) {
+			return refreshed, nil
+		}
+		time.Sleep(snapshotWaitDelay)
+	}
+}
+
+// finalSnapshot returns the final snapshot of the volume taken since it was unattached and its
+// encrypted copy, if both exist, or nil if there is none. Older final snapshots predate changes
+// made while it was attached again.
+func (mgr *SnapshotManager) finalSnapshot(volume *ec2.Volume, since time.Time) (*ec2.Snapshot, *ec2.Snapshot, error) {
+	snapshots, err := mgr.describeSnapshots(volume)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// a copy stands in for its original once the original has been deleted
+	snapshots, copies := withoutPendingCopies(snapshots)
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		snapshot := snapshots[i]
+		if isFinalSnapshot(snapshot) && sourceVolumeID(snapshot) == *volume.VolumeId && !aws.TimeValue(snapshot.StartTime).Before(since) {
+			return snapshot, copies[*snapshot.SnapshotId], nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// trackUnattached tags unattached volumes with UnattachedSinceTag the first time they are
+// seen, and removes the tag from volumes that have been attached again. Failures are only
+// reported to the Observers, as they delay reaping but don't affect snapshots.
+func (mgr *SnapshotManager) trackUnattached(volumes []*ec2.Volume, now time.Time) {
+	for _, volume := range volumes {
+		_, tracked := unattachedSince(volume)
+
+		switch {
+		case !isAttached(volume) && !tracked:
+			value := now.UTC().Format(time.RFC3339)
+			tag := &ec2.Tag{Key: aws.String(UnattachedSinceTag), Value: aws.String(value)}
+			if err := mgr.tagResource(volume.VolumeId, []*ec2.Tag{tag}); err != nil {
+				mgr.observeError(volume, err)
+				continue
+			}
+			volume.Tags = append(volume.Tags, tag)
+		case isAttached(volume) && tracked:
+			_, err := mgr.ec2.DeleteTags(&ec2.DeleteTagsInput{
+				Resources: []*string{volume.VolumeId},
+				Tags:      []*ec2.Tag{{Key: aws.String(UnattachedSinceTag)}},
+			})
+			if err != nil {
+				mgr.observeError(volume, err)
+				continue
+			}
+			volume.Tags = withoutKeys(volume.Tags, []string{UnattachedSinceTag})
+		}
+	}
+}
+
+// unattachedSince returns the time in the volume's UnattachedSinceTag, if it has a valid one
+func unattachedSince(volume *ec2.Volume) (time.Time, bool) {
+	for _, tag := range volume.Tags {
+		if *tag.Key == UnattachedSinceTag {
+			since, err := time.Parse(time.RFC3339, aws.StringValue(tag.Value))
+			return since, err == nil
+		}
+	}
+	return time.Time{}, false
+}
+
+// isFinalSnapshot returns true if the snapshot was taken before its volume was reaped
+func isFinalSnapshot(snapshot *ec2.Snapshot) bool {
+	for _, tag := range snapshot.Tags {
+		if *tag.Key == FinalSnapshotTag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package ebs
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+	"github.com/healthcareblocks/ebs_snapshotter/report"
+)
+
+// VerificationTag is added to the temporary volumes created by restore verification drills,
+// so that any left behind by an interrupted drill can be found and removed
+const VerificationTag = "ebs_snapshotter:verification"
+
+// VerificationTimeout is how long a temporary volume may exist before Verify deletes it as
+// left behind by an interrupted drill
+const VerificationTimeout = 24 * time.Hour
+
+// VerifyOptions configures restore verification drills
+type VerifyOptions struct {
+	// How many randomly selected volumes to verify. All volumes are verified if zero.
+	Sample int
+
+	// If set, each temporary volume is attached to this instance at Device and Check is run.
+	// The instance must be in the SnapshotManager's region.
+	InstanceID string
+	Device     string
+
+	// An optional check run once the temporary volume is attached, for example to mount
+	// it and verify its filesystem. Returning an error fails the drill.
+	Check func(volume *ec2.Volume, snapshot *ec2.Snapshot, device string) error
+}
+
+// Verify restores the newest completed snapshot of each volume the SnapshotManager
+// snapshots, or a sample of them, to a temporary volume. Each temporary volume is
+// deleted once it becomes available, or once Check passes if an instance is set.
+// Temporary volumes older than VerificationTimeout are deleted first. Failures are
+// reported to the Observers and recorded in the returned report.
+func (mgr *SnapshotManager) Verify(opts VerifyOptions) *report.Region {
+	start := time.Now()
+	result := report.NewRegion(mgr.Region)
+	defer func() {
+		result.Duration = time.Since(start)
+		mgr.observe(func(o Observer) { o.OnRunCompleted(result) })
+	}()
+
+	mgr.deleteStaleVerificationVolumes(start, result)
+
+	volumes, err := mgr.describeVolumes(nil)
+	if err == nil && opts.InstanceID != "" && opts.Device == "" {
+		err = errors.New("device is required to attach a volume")
+	}
+	if err != nil {
+		mgr.observeError(nil, err)
+		result.AddFailure(report.Volume{}, err)
+		return result
+	}
+
+	var selected []*ec2.Volume
+	for _, volume := range volumes {
+		if !isExcluded(volume) {
+			selected = append(selected, volume)
+		}
+	}
+
+	if opts.Sample > 0 && opts.Sample < len(selected) {
+		sample := make([]*ec2.Volume, opts.Sample)
+		for i, j := range rand.Perm(len(selected))[:opts.Sample] {
+			sample[i] = selected[j]
+		}
+		selected = sample
+	}
+
+	availabilityZone := ""
+	if opts.InstanceID != "" {
+		availabilityZone, err = mgr.instanceAvailabilityZone(opts.InstanceID)
+		if err != nil {
+			mgr.observeError(nil, err)
+			result.AddFailure(report.Volume{}, err)
+			return result
+		}
+	}
+
+	for _, volume := range selected {
+		zone := availabilityZone
+		if zone == "" {
+			zone = aws.StringValue(volume.AvailabilityZone)
+		}
+		result.AddVerification(mgr.verifyVolume(volume, zone, opts))
+	}
+
+	return result
+}
+
+// verifyVolume restores the volume's newest completed snapshot in the given availability
+// zone, runs the check if configured and deletes the temporary volume, returning the outcome
+func (mgr *SnapshotManager) verifyVolume(volume *ec2.Volume, availabilityZone string, opts VerifyOptions) (outcome report.Verification) {
+	start := time.Now()
+	outcome.Volume = report.Volume{ID: *volume.VolumeId, Name: volumeName(volume)}
+	defer func() { outcome.Duration = time.Since(start) }()
+
+	fail := func(err error) report.Verification {
+		mgr.observeError(volume, err)
+		if outcome.Error == "" {
+			outcome.Error = err.Error()
+		}
+		return outcome
+	}
+
+	snapshot, err := mgr.FindSnapshot(*volume.VolumeId, start)
+	if err != nil {
+		return fail(err)
+	}
+	outcome.SnapshotID = *snapshot.SnapshotId
+	outcome.SnapshotTime = aws.TimeValue(snapshot.StartTime)
+
+	mgr.observe(func(o Observer) { o.OnSnapshotVerifying(mgr.Region, volume, snapshot) })
+
+	restored, err := mgr.RestoreVolume(RestoreOptions{
+		SnapshotID:       outcome.SnapshotID,
+		AvailabilityZone: availabilityZone,
+		InstanceID:       opts.InstanceID,
+		Device:           opts.Device,
+		// the temporary volume must not be snapshotted into the original's retention group
+		// by a run while it is attached
+		Tags: []*ec2.Tag{
+			{Key: aws.String(VerificationTag), Value: aws.String("true")},
+			{Key: aws.String(ExcludeTag), Value: aws.String("true")},
+		},
+		OmitTags: []string{BackupKeyTag},
+		Wait:     true,
+	})
+	if restored != nil {
+		outcome.RestoredVolumeID = *restored.VolumeId
+		defer func() {
+			if err := mgr.deleteRestoredVolume(restored, opts.InstanceID != ""); err != nil {
+				fail(fmt.Errorf("can't delete temporary volume %s: %v", outcome.RestoredVolumeID, err))
+			}
+		}()
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	if opts.InstanceID != "" && opts.Check != nil {
+		if err := opts.Check(restored, snapshot, opts.Device); err != nil {
+			return fail(fmt.Errorf("check failed: %v", err))
+		}
+		outcome.Checked = true
+	}
+
+	return outcome
+}
+
+// deleteStaleVerificationVolumes deletes the temporary volumes created more than
+// VerificationTimeout before now, recording failures in the report
+func (mgr *SnapshotManager) deleteStaleVerificationVolumes(now time.Time, result *report.Region) {
+	params := &ec2.DescribeVolumesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []*string{aws.String(VerificationTag)},
+			},
+			{
+				Name:   aws.String("status"),
+				Values: []*string{aws.String(ec2.VolumeStateInUse), aws.String(ec2.VolumeStateAvailable)},
+			},
+		},
+	}
+
+	var volumes []*ec2.Volume
+	err := mgr.ec2.DescribeVolumesPages(params, func(page *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		volumes = append(volumes, page.Volumes...)
+		return true
+	})
+	if err != nil {
+		mgr.observeError(nil, err)
+		result.AddFailure(report.Volume{}, err)
+		return
+	}
+
+	for _, volume := range volumes {
+		if !hasTag(volume.Tags, VerificationTag) || now.Sub(aws.TimeValue(volume.CreateTime)) < VerificationTimeout {
+			continue
+		}
+		if err := mgr.deleteRestoredVolume(volume, len(volume.Attachments) > 0); err != nil {
+			err = fmt.Errorf("can't delete temporary volume %s: %v", *volume.VolumeId, err)
+			mgr.observeError(volume, err)
+			result.AddFailure(report.Volume{ID: *volume.VolumeId, Name: volumeName(volume)}, err)
+		}
+	}
+}
+
+// deleteRestoredVolume deletes a temporary volume, detaching it first if it was attached
+func (mgr *SnapshotManager) deleteRestoredVolume(volume *ec2.Volume, attached bool) error {
+	describeVolume := &ec2.DescribeVolumesInput{VolumeIds: []*string{volume.VolumeId}}
+
+	if attached {
+		_, err := mgr.ec2.DetachVolume(&ec2.DetachVolumeInput{VolumeId: volume.VolumeId})
+		if err != nil && !awserror.HasCode(err, "IncorrectState") {
+			return err
+		}
+		if err := mgr.ec2.WaitUntilVolumeAvailable(describeVolume); err != nil {
+			return err
+		}
+	}
+
+	mgr.observe(func(o Observer) { o.OnVolumeDeleting(mgr.Region, volume, "temporary") })
+
+	_, err := mgr.ec2.DeleteVolume(&ec2.DeleteVolumeInput{VolumeId: volume.VolumeId})
+	return err
+}
+
+// instanceAvailabilityZone returns the availability zone of an instance
+func (mgr *SnapshotManager) instanceAvailabilityZone(instanceID string) (string, error) {
+	resp, err := mgr.ec2.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String(instanceID)},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, reservation := range resp.Reservations {
+		for _, instance := range reservation.Instances {
+			return aws.StringValue(instance.Placement.AvailabilityZone), nil
+		}
+	}
+	return "", fmt.Errorf("instance %s not found in region %s", instanceID, mgr.Region)
+}
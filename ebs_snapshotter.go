@@ -11,27 +11,47 @@
 // 	- ec2:CreateTags
 // 	- ec2:DeleteSnapshot
 // 	- ec2:DeleteTags
+// 	- ec2:DescribeRegions (optional, for -regions=all)
 // 	- ec2:DescribeSnapshotAttribute
 // 	- ec2:DescribeSnapshots
 // 	- ec2:DescribeTags
 // 	- ec2:DescribeVolumes
 // 	- ec2:ModifySnapshotAttribute
 // 	- ec2:ResetSnapshotAttribute
+// 	- ec2:AttachVolume, ec2:CreateVolume, ec2:DetachVolume,
+// 	  ec2:DescribeInstances (optional, for restores and restore verification)
+// 	- ec2:DeleteVolume (optional, for restore verification and reaping)
+// 	- ec2:DescribeInstances (also optional, for -copytags_instance)
+// 	- kms:CreateGrant, kms:DescribeKey, kms:Encrypt, kms:Decrypt,
+// 	  kms:GenerateDataKeyWithoutPlaintext, kms:ReEncrypt* (optional, on the
+// 	  -kms_key_id key with -require_encryption)
+// 	- sts:GetCallerIdentity (optional, for the audit log)
+// 	- cloudwatch:PutMetricData (optional)
 // 	- SNS:Publish (optional)
+// 	- SES:SendEmail (optional)
+// 	- logs:CreateLogStream, logs:PutLogEvents (optional, for -audit_log_group)
+// 	- s3:PutObject (optional, for -audit_log_bucket)
 
 package main // import "github.com/healthcareblocks/ebs_snapshotter"
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+	"github.com/healthcareblocks/ebs_snapshotter/cloudwatch"
 	"github.com/healthcareblocks/ebs_snapshotter/ebs"
-	"github.com/healthcareblocks/ebs_snapshotter/sns"
+	"github.com/healthcareblocks/ebs_snapshotter/notify"
+	"github.com/healthcareblocks/ebs_snapshotter/prometheus"
+	"github.com/healthcareblocks/ebs_snapshotter/report"
 	"github.com/healthcareblocks/ec2_metrics_publisher/metadata"
 )
 
@@ -40,23 +60,89 @@ var (
 	debug      = flag.Bool("d", false, "Turns on AWS request profiling")
 
 	// EBS snapshot flags
-	regions     = flag.String("regions", "", "AWS EC2 regions (comma delimited) to include in EBS snapshots. If not set\n\tthis value is determined using the host machine's EC2 metadata.")
-	copyTags    = flag.Bool("copytags", true, "Copy tags from volume")
-	retainCount = flag.Int("retain", 7, "Keep x number of snapshots per each volume")
+	regions             = flag.String("regions", "", "AWS EC2 regions (comma delimited) to include in EBS snapshots, or \"all\" for every\n\tregion enabled for the account. If not set this value is determined using the host\n\tmachine's EC2 metadata.")
+	excludeRegions      = flag.String("exclude_regions", "", "AWS EC2 regions (comma delimited) to skip, e.g. with -regions=all")
+	includeUnattached   = flag.Bool("include_unattached", false, "Also snapshot unattached (available) volumes")
+	copyTags            = flag.Bool("copytags", true, "Copy tags from volume")
+	copyTagsInclude     = flag.String("copytags_include", "", "Volume tag keys (comma delimited) to copy. If not set, all volume tags are copied.")
+	copyTagsExclude     = flag.String("copytags_exclude", "", "Volume tag keys (comma delimited) never to copy")
+	copyInstanceTags    = flag.String("copytags_instance", "", "Tag keys (comma delimited) to copy from the volume's attached instance. Volume\n\ttags with the same key take precedence.")
+	attachmentTags      = flag.Bool("attachment_tags", false, "Tag snapshots with the instance ID, device name and availability zone the volume\n\tis attached at")
+	snapshotTags        = flag.String("tags", "", "Static tags (comma delimited key=value pairs) added to every snapshot, e.g.\n\tBackupPolicy=daily,CostCenter=1234")
+	snapshotDescription = flag.String("description", "", "Snapshot description template. Templates can use .VolumeID, .VolumeName, .InstanceID,\n\t.Device, .AvailabilityZone, .Region, .Policy and .Time, e.g.\n\t'{{.Policy}} backup of {{.VolumeID}} at {{.Time.Format \"2006-01-02\"}}'. If not set, the\n\tvolume's Name tag is used.")
+	snapshotName        = flag.String("name", "", "Snapshot Name tag template, using the same values as -description")
+	retainCount         = flag.Int("retain", 7, "Keep x number of snapshots per each volume")
+	backupKeyNameDevice = flag.Bool("backup_key_name_device", false, "Group retention of volumes without an ebs_snapshotter:backup_key tag by their\n\tName tag and device instead of their volume ID")
+	requireEncryption   = flag.Bool("require_encryption", false, "Replace snapshots of unencrypted volumes with encrypted copies, deleting the\n\tunencrypted original on a later run once the copy completes, and report every unencrypted\n\tsnapshot until it is replaced.")
+	kmsKeyID            = flag.String("kms_key_id", "", "KMS key ID or ARN used to encrypt copies with -require_encryption. If not set, the\n\taccount's default EBS key is used.")
+	retainFor           = flag.Duration("retain_for", 0, "Tag new snapshots to expire this long after they are taken (e.g. 720h). Expired\n\tsnapshots are deleted by the prune command, and -retain never deletes a snapshot\n\tbefore it expires.")
+	policy              = flag.String("policy", "default", "Name of the backup policy this run applies, used in reports and metrics")
+	interval            = flag.Duration("interval", 0, "Run continuously as a daemon, snapshotting every interval (e.g. 24h). If not set,\n\tsnapshots are taken once and the program exits.")
+
+	// Restore verification flags
+	verify         = flag.Bool("verify", false, "Run restore verification drills instead of taking snapshots. The newest completed\n\tsnapshot of each volume is restored to a temporary volume, which is then deleted.")
+	verifySample   = flag.Int("verify_sample", 0, "Number of randomly selected volumes to verify in each region. If not set, all\n\tvolumes are verified.")
+	verifyInstance = flag.String("verify_instance", "", "Optional instance ID to attach temporary volumes to during verification. Requires\n\ta single region.")
+	verifyDevice   = flag.String("verify_device", "/dev/sdz", "Device name to attach temporary volumes at on -verify_instance")
+	verifyCommand  = flag.String("verify_command", "", "Optional shell command run on this host once a temporary volume is attached to\n\t-verify_instance, e.g. to mount it and check its filesystem. EBS_VOLUME_ID,\n\tEBS_SNAPSHOT_ID, EBS_SOURCE_VOLUME_ID and EBS_DEVICE are set in its environment.")
+
+	// Prometheus metrics flags
+	metricsAddr     = flag.String("metrics_addr", "", "Address to serve Prometheus metrics on at /metrics (e.g. :9470). Requires -interval.")
+	metricsTextfile = flag.String("metrics_textfile", "", "Path of a node_exporter textfile collector file to write Prometheus metrics to\n\tafter each run (e.g. /var/lib/node_exporter/ebs_snapshotter.prom).")
+
+	// CloudWatch metrics flags
+	cloudwatchMetrics       = flag.Bool("cloudwatch", false, "Publish run metrics to CloudWatch in each region")
+	cloudwatchNamespace     = flag.String("cloudwatch_namespace", cloudwatch.DefaultNamespace, "CloudWatch namespace for run metrics")
+	cloudwatchVolumeMetrics = flag.Bool("cloudwatch_volume_metrics", false, "Also publish snapshot counts per volume to CloudWatch")
+
+	// Notification flags. The subject and message templates apply to every notifier.
+	notifySubject   = flag.String("sns_subject", "", "Notification subject template. If set, it overrides the default subject.")
+	notifyMessage   = flag.String("sns_message", "", "Notification message template. If set, it overrides the default message.")
+	emailMessage    = flag.String("email_message", "", "Message template for email recipients (SES and SNS email subscribers).")
+	smsMessage      = flag.String("sms_message", "", "Message template for SNS SMS subscribers.")
+	notifyPerRegion = flag.Bool("notify_per_region", false, "Send notifications as each region completes instead of a single summary\n\tafter all regions complete.")
 
 	// SNS alert flags
-	snsTopic   = flag.String("sns_topic", "", "Optional SNS ARN topic. Triggers an alert for each completed region.")
-	snsRegion  = flag.String("sns_region", "", "AWS region for SNS topic. If not set, this value is determined using\n\tthe host machine's EC2 metadata.")
-	snsSubject = flag.String("sns_subject", "", "SNS subject. If set, it overrides the default subject.")
-	snsMessage = flag.String("sns_message", "", "SNS message. If set, it overrides the default message.")
+	snsTopic        = flag.String("sns_topic", "", "Optional SNS ARN topic. Triggers an alert once all regions complete.")
+	snsPolicy       = flag.String("sns_policy", "always", "When to alert -sns_topic: always, on-change, on-partial-failure or on-failure.")
+	snsWarningTopic = flag.String("sns_warning_topic", "", "Optional SNS ARN topic alerted only for runs with warning or error severity.")
+	snsErrorTopic   = flag.String("sns_error_topic", "", "Optional SNS ARN topic alerted only for runs with error severity.")
+	snsRegion       = flag.String("sns_region", "", "AWS region for SNS topics. If not set, this value is determined using\n\tthe host machine's EC2 metadata.")
+
+	// SES email flags
+	sesFrom   = flag.String("ses_from", "", "Optional SES verified sender address. Requires -ses_to.")
+	sesTo     = flag.String("ses_to", "", "Recipient email addresses (comma delimited) for SES alerts.")
+	sesPolicy = flag.String("ses_policy", "always", "When to send SES alerts: always, on-change, on-partial-failure or on-failure.")
+	sesRegion = flag.String("ses_region", "", "AWS region for SES. If not set, this value is determined using\n\tthe host machine's EC2 metadata.")
+
+	// Webhook flags
+	webhookURL    = flag.String("webhook_url", "", "Optional URL to POST a JSON summary of the run to.")
+	webhookSecret = flag.String("webhook_secret", "", "Shared secret used to sign webhook payloads with HMAC-SHA256.")
+	webhookPolicy = flag.String("webhook_policy", "always", "When to POST to -webhook_url: always, on-change, on-partial-failure or on-failure.")
+	slackURL      = flag.String("slack_webhook_url", "", "Optional Slack incoming webhook URL.")
+	slackPolicy   = flag.String("slack_policy", "always", "When to post to Slack: always, on-change, on-partial-failure or on-failure.")
+
+	// Heartbeat flags
+	heartbeatURL = flag.String("heartbeat_url", "", "Optional dead man's switch URL (e.g. https://hc-ping.com/<uuid>). Pinged at\n\tURL/start when a run begins, then URL on success or URL/fail on failure.")
+
+	// Audit log flags
+	auditLog = newAuditLogFlags(flag.CommandLine)
 )
 
 func init() {
 	// log output in JSON formatt
 	log.SetFormatter(&log.JSONFormatter{})
+	flag.Usage = usage
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Parse()
 
 	if *appVersion {
@@ -66,10 +152,11 @@ func main() {
 
 	var machine metadata.Machine
 
-	if *regions == "" || (*snsTopic != "" && *snsRegion == "") {
+	snsEnabled := *snsTopic != "" || *snsWarningTopic != "" || *snsErrorTopic != ""
+	if *regions == "" || (snsEnabled && *snsRegion == "") || (*sesFrom != "" && *sesRegion == "") {
 		machine = metadata.Machine{}
 		if err := machine.LoadFromMetadata(); err != nil {
-			log.Fatal("can't get EC2 metadata, must set -regions (and -sns_region) explicitly")
+			log.Fatal("can't get EC2 metadata, must set -regions (and -sns_region, -ses_region) explicitly")
 		}
 		if *regions == "" {
 			*regions = machine.Region
@@ -77,31 +164,196 @@ func main() {
 		if *snsRegion == "" {
 			*snsRegion = machine.Region
 		}
+		if *sesRegion == "" {
+			*sesRegion = machine.Region
+		}
+	}
+
+	warnBelowRetentionFloor(*retainCount)
+
+	alerts := newNotifications()
+	tagging := newSnapshotTagging()
+
+	var verification *ebs.VerifyOptions
+	if *verify {
+		opts := verifyOptions(regionList(*regions, *excludeRegions))
+		verification = &opts
+	}
+
+	var metrics *prometheus.Metrics
+	if *metricsAddr != "" || *metricsTextfile != "" {
+		metrics = prometheus.New()
+	}
+
+	if *interval <= 0 {
+		if *metricsAddr != "" {
+			log.Fatal("-metrics_addr requires -interval")
+		}
+
+		if *metricsTextfile != "" {
+			if err := metrics.LoadTextfile(*metricsTextfile); err != nil {
+				log.Printf("can't load previous metrics from %s: %v", *metricsTextfile, err)
+			}
+		}
+
+		if err := snapshotRegions(alerts, metrics, tagging, verification); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *metricsAddr != "" {
+		http.Handle("/metrics", metrics)
+		go func() {
+			log.Fatal(http.ListenAndServe(*metricsAddr, nil))
+		}()
+	}
+
+	log.Printf("Running every %s", *interval)
+	ticker := time.NewTicker(*interval)
+	for {
+		if err := snapshotRegions(alerts, metrics, tagging, verification); err != nil {
+			log.Print(err)
+		}
+		<-ticker.C
+	}
+}
+
+// warnBelowRetentionFloor logs an attempt to retain fewer snapshots than the compiled in
+// retention floor, which is enforced instead
+func warnBelowRetentionFloor(retain int) {
+	if count, age := ebs.RetentionFloor(); retain < count {
+		log.Printf("-retain=%d is below the retention floor of %d snapshots and %s, which is enforced instead", retain, count, age)
+	}
+}
+
+// runObserver adds each region's report to the run as it completes. Notifications are sent
+// once Run or Verify has returned, so that a slow destination doesn't hold up the callback.
+type runObserver struct {
+	ebs.NopObserver
+	run *report.Report
+}
+
+func (o *runObserver) OnRunCompleted(result *report.Region) {
+	o.run.Add(result)
+}
+
+// snapshotRegions snapshots the volumes in every region, or runs restore verification
+// drills if verification is set, then publishes metrics and sends notifications for the
+// run. It returns an error if any operation failed.
+func snapshotRegions(alerts *notifications, metrics *prometheus.Metrics, tagging *snapshotTagging, verification *ebs.VerifyOptions) error {
+	if verification != nil {
+		log.Print("Starting Restore Verification On " + time.Now().Format(time.RFC822))
+	} else {
+		log.Print("Starting Snapshot Process On " + time.Now().Format(time.RFC822))
+	}
+
+	// regions are resolved on every run so that newly enabled regions are picked up in daemon mode
+	regionNames, err := resolveRegions(*regions, *excludeRegions)
+	if err != nil {
+		return err
+	}
+
+	logger := auditLog.open(regionNames[0])
+	defer closeAuditLog(logger)
+
+	var heartbeat *notify.Heartbeat
+	if *heartbeatURL != "" {
+		heartbeat = &notify.Heartbeat{URL: *heartbeatURL}
+		if err := heartbeat.Start(); err != nil {
+			log.Printf("can't send heartbeat start signal: %v", err)
+		}
 	}
 
-	log.Print("Starting Snapshot Process On " + time.Now().Format(time.RFC822))
+	run := report.New()
+	run.Policy = *policy
+	if alerts.enabled() {
+		run.Account = accountID(regionNames[0])
+	}
+
+	var notifyFailures int32
 
 	var wg sync.WaitGroup
-	for _, region := range strings.Split(*regions, ",") {
+	for _, region := range regionNames {
 		wg.Add(1)
 		go func(region string) {
 			defer wg.Done()
 
-			mgr := ebs.NewSnapshotManager(region, "", *copyTags, *retainCount, *debug)
-			snapshotCount := mgr.SnapshotVolumes()
+			mgr, err := ebs.NewSnapshotManager(region, "", *copyTags, *retainCount, *debug)
+			awserror.HandleError(err)
+			mgr.Policy = *policy
+			mgr.RetentionPeriod = *retainFor
+			mgr.BackupKeyFromNameAndDevice = *backupKeyNameDevice
+			mgr.IncludeUnattached = *includeUnattached
+			mgr.RequireEncryption = *requireEncryption
+			mgr.KmsKeyID = *kmsKeyID
+			tagging.apply(mgr)
+			if logger != nil {
+				mgr.SetAuditLog(logger)
+			}
 
-			if *snsTopic != "" {
-				if *snsSubject == "" {
-					*snsSubject = fmt.Sprintf("EBS Snapshots Completed (%s)", region)
-				}
+			mgr.Observers = append(mgr.Observers, &runObserver{run: run})
 
-				if *snsMessage == "" {
-					*snsMessage = fmt.Sprintf("%d snapshots completed at %s", snapshotCount, time.Now().Format(time.RFC822))
-				}
-				sns.SendMessage(*snsRegion, *snsTopic, *snsSubject, *snsMessage)
+			var result *report.Region
+			if verification != nil {
+				result = mgr.Verify(*verification)
+			} else {
+				result = mgr.Run()
+			}
+
+			if *notifyPerRegion {
+				atomic.AddInt32(&notifyFailures, int32(alerts.send(run.ForRegion(result))))
 			}
 		}(region)
 	}
 
 	wg.Wait()
+	run.Finish()
+
+	var errs []string
+
+	if *cloudwatchMetrics {
+		publisher := &cloudwatch.Publisher{Namespace: *cloudwatchNamespace, VolumeMetrics: *cloudwatchVolumeMetrics}
+		if err := publisher.Publish(run); err != nil {
+			awserror.LogError(err)
+			errs = append(errs, "can't publish CloudWatch metrics")
+		}
+	}
+
+	if metrics != nil {
+		metrics.Record(run)
+		if *metricsTextfile != "" {
+			if err := metrics.WriteTextfile(*metricsTextfile); err != nil {
+				errs = append(errs, fmt.Sprintf("can't write metrics to %s: %v", *metricsTextfile, err))
+			}
+		}
+	}
+
+	if !*notifyPerRegion {
+		atomic.AddInt32(&notifyFailures, int32(alerts.send(run)))
+	}
+
+	if heartbeat != nil {
+		notification := &notify.Notification{Subject: run.Subject(), Message: run.Message(), Severity: run.Severity(), Report: run}
+		if err := heartbeat.Notify(notification); err != nil {
+			errs = append(errs, fmt.Sprintf("can't send heartbeat: %v", err))
+		}
+	}
+
+	if run.Failed() {
+		if verification != nil {
+			errs = append(errs, fmt.Sprintf("%d restore verifications failed", run.Failures()))
+		} else {
+			errs = append(errs, fmt.Sprintf("%d snapshot operations failed", run.Failures()))
+		}
+	}
+
+	if notifyFailures > 0 {
+		errs = append(errs, fmt.Sprintf("%d notifications failed", notifyFailures))
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
 }
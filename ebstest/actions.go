@@ -0,0 +1,579 @@
+package ebstest
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// copiedSnapshotVolumeID is the volume ID AWS reports for snapshots created by CopySnapshot
+const copiedSnapshotVolumeID = "vol-ffffffff"
+
+// actions are the supported EC2 API actions. Each is called with the server locked and
+// returns the output struct of the action or an error response.
+var actions = map[string]func(s *Server, p params) (interface{}, *apiError){
+	"AttachVolume":              (*Server).attachVolume,
+	"CopySnapshot":              (*Server).copySnapshot,
+	"CreateSnapshot":            (*Server).createSnapshot,
+	"CreateTags":                (*Server).createTags,
+	"CreateVolume":              (*Server).createVolume,
+	"DeleteSnapshot":            (*Server).deleteSnapshot,
+	"DeleteTags":                (*Server).deleteTags,
+	"DeleteVolume":              (*Server).deleteVolume,
+	"DescribeInstances":         (*Server).describeInstances,
+	"DescribeRegions":           (*Server).describeRegions,
+	"DescribeSnapshotAttribute": (*Server).describeSnapshotAttribute,
+	"DescribeSnapshots":         (*Server).describeSnapshots,
+	"DescribeVolumes":           (*Server).describeVolumes,
+	"DetachVolume":              (*Server).detachVolume,
+	"ModifySnapshotAttribute":   (*Server).modifySnapshotAttribute,
+	"ResetSnapshotAttribute":    (*Server).resetSnapshotAttribute,
+}
+
+func (s *Server) describeVolumes(p params) (interface{}, *apiError) {
+	ids := p.list("VolumeId")
+	for _, id := range ids {
+		if _, ok := s.volumes[id]; !ok {
+			return nil, notFound("InvalidVolume.NotFound", "volume", id)
+		}
+	}
+
+	var volumes []*ec2.Volume
+	for _, v := range s.sortedVolumes() {
+		if len(ids) > 0 && !contains(ids, *v.VolumeId) {
+			continue
+		}
+		ok, err := matches(p.filters(), v.Tags, volumeFields(v))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			volumes = append(volumes, v)
+		}
+	}
+
+	start, end, nextToken, err := s.page(p, len(volumes))
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.DescribeVolumesOutput{Volumes: volumes[start:end], NextToken: nextToken}, nil
+}
+
+func volumeFields(v *ec2.Volume) fields {
+	return func(name string) ([]string, bool) {
+		var attachments []string
+		for _, attachment := range v.Attachments {
+			switch name {
+			case "attachment.status":
+				attachments = append(attachments, aws.StringValue(attachment.State))
+			case "attachment.instance-id":
+				attachments = append(attachments, aws.StringValue(attachment.InstanceId))
+			case "attachment.device":
+				attachments = append(attachments, aws.StringValue(attachment.Device))
+			}
+		}
+
+		switch name {
+		case "volume-id":
+			return []string{*v.VolumeId}, true
+		case "status":
+			return []string{aws.StringValue(v.State)}, true
+		case "availability-zone":
+			return []string{aws.StringValue(v.AvailabilityZone)}, true
+		case "encrypted":
+			return []string{boolString(v.Encrypted)}, true
+		case "size":
+			return []string{strconv.FormatInt(aws.Int64Value(v.Size), 10)}, true
+		case "snapshot-id":
+			return []string{aws.StringValue(v.SnapshotId)}, true
+		case "volume-type":
+			return []string{aws.StringValue(v.VolumeType)}, true
+		case "attachment.status", "attachment.instance-id", "attachment.device":
+			return attachments, true
+		}
+		return nil, false
+	}
+}
+
+func (s *Server) describeSnapshots(p params) (interface{}, *apiError) {
+	ids := p.list("SnapshotId")
+	for _, id := range ids {
+		if _, ok := s.snapshots[id]; !ok {
+			return nil, notFound("InvalidSnapshot.NotFound", "snapshot", id)
+		}
+	}
+
+	var owners []string
+	for _, owner := range p.list("Owner") {
+		if owner == "self" {
+			owner = s.Account
+		}
+		owners = append(owners, owner)
+	}
+
+	var snapshots []*ec2.Snapshot
+	for _, snap := range s.sortedSnapshots() {
+		if len(ids) > 0 && !contains(ids, *snap.SnapshotId) {
+			continue
+		}
+		if len(owners) > 0 && !contains(owners, aws.StringValue(snap.OwnerId)) {
+			continue
+		}
+		ok, err := matches(p.filters(), snap.Tags, snapshotFields(snap))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			snapshots = append(snapshots, snap)
+		}
+	}
+
+	start, end, nextToken, err := s.page(p, len(snapshots))
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.DescribeSnapshotsOutput{Snapshots: snapshots[start:end], NextToken: nextToken}, nil
+}
+
+func snapshotFields(snap *ec2.Snapshot) fields {
+	return func(name string) ([]string, bool) {
+		switch name {
+		case "snapshot-id":
+			return []string{*snap.SnapshotId}, true
+		case "volume-id":
+			return []string{aws.StringValue(snap.VolumeId)}, true
+		case "status":
+			return []string{aws.StringValue(snap.State)}, true
+		case "encrypted":
+			return []string{boolString(snap.Encrypted)}, true
+		case "owner-id":
+			return []string{aws.StringValue(snap.OwnerId)}, true
+		case "description":
+			return []string{aws.StringValue(snap.Description)}, true
+		case "volume-size":
+			return []string{strconv.FormatInt(aws.Int64Value(snap.VolumeSize), 10)}, true
+		}
+		return nil, false
+	}
+}
+
+func (s *Server) createSnapshot(p params) (interface{}, *apiError) {
+	v, ok := s.volumes[p.get("VolumeId")]
+	if !ok {
+		return nil, notFound("InvalidVolume.NotFound", "volume", p.get("VolumeId"))
+	}
+
+	snap := &ec2.Snapshot{
+		SnapshotId:  aws.String(s.newID("snap")),
+		VolumeId:    v.VolumeId,
+		VolumeSize:  v.Size,
+		Encrypted:   aws.Bool(aws.BoolValue(v.Encrypted)),
+		KmsKeyId:    v.KmsKeyId,
+		Description: aws.String(p.get("Description")),
+		OwnerId:     aws.String(s.Account),
+		StartTime:   aws.Time(s.now()),
+		State:       aws.String(ec2.SnapshotStateCompleted),
+		Progress:    aws.String("100%"),
+	}
+	s.snapshots[*snap.SnapshotId] = snap
+	return copySnapshot(snap), nil
+}
+
+func (s *Server) copySnapshot(p params) (interface{}, *apiError) {
+	source, ok := s.snapshots[p.get("SourceSnapshotId")]
+	if !ok {
+		return nil, notFound("InvalidSnapshot.NotFound", "snapshot", p.get("SourceSnapshotId"))
+	}
+	if p.get("SourceRegion") != s.Region {
+		return nil, invalidParameter("InvalidParameterValue", "Copies from region %s are not supported by the fake", p.get("SourceRegion"))
+	}
+
+	snap := &ec2.Snapshot{
+		SnapshotId:  aws.String(s.newID("snap")),
+		VolumeId:    aws.String(copiedSnapshotVolumeID),
+		VolumeSize:  source.VolumeSize,
+		Encrypted:   aws.Bool(aws.BoolValue(source.Encrypted) || p.bool("Encrypted")),
+		KmsKeyId:    source.KmsKeyId,
+		Description: aws.String(p.get("Description")),
+		OwnerId:     aws.String(s.Account),
+		StartTime:   aws.Time(s.now()),
+		State:       aws.String(ec2.SnapshotStateCompleted),
+		Progress:    aws.String("100%"),
+	}
+	if kmsKeyID := p.get("KmsKeyId"); kmsKeyID != "" {
+		snap.KmsKeyId = aws.String(kmsKeyID)
+	}
+	s.snapshots[*snap.SnapshotId] = snap
+	return &ec2.CopySnapshotOutput{SnapshotId: snap.SnapshotId}, nil
+}
+
+func (s *Server) deleteSnapshot(p params) (interface{}, *apiError) {
+	id := p.get("SnapshotId")
+	if _, ok := s.snapshots[id]; !ok {
+		return nil, notFound("InvalidSnapshot.NotFound", "snapshot", id)
+	}
+
+	delete(s.snapshots, id)
+	delete(s.shares, id)
+	return &ec2.DeleteSnapshotOutput{}, nil
+}
+
+// taggedResource returns a pointer to the tags of the resource with the ID
+func (s *Server) taggedResource(id string) (*[]*ec2.Tag, *apiError) {
+	switch {
+	case strings.HasPrefix(id, "vol-"):
+		if v, ok := s.volumes[id]; ok {
+			return &v.Tags, nil
+		}
+		return nil, notFound("InvalidVolume.NotFound", "volume", id)
+	case strings.HasPrefix(id, "snap-"):
+		if snap, ok := s.snapshots[id]; ok {
+			return &snap.Tags, nil
+		}
+		return nil, notFound("InvalidSnapshot.NotFound", "snapshot", id)
+	case strings.HasPrefix(id, "i-"):
+		if instance, ok := s.instances[id]; ok {
+			return &instance.Tags, nil
+		}
+		return nil, notFound("InvalidInstanceID.NotFound", "instance ID", id)
+	}
+	return nil, invalidParameter("InvalidID", "The ID '%s' is not valid", id)
+}
+
+func (s *Server) createTags(p params) (interface{}, *apiError) {
+	var resources []*[]*ec2.Tag
+	for _, id := range p.list("ResourceId") {
+		tags, err := s.taggedResource(id)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, tags)
+	}
+
+	for _, tags := range resources {
+		for _, tag := range p.tags("Tag") {
+			*tags = setTag(*tags, *tag.Key, aws.StringValue(tag.Value))
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (s *Server) deleteTags(p params) (interface{}, *apiError) {
+	var resources []*[]*ec2.Tag
+	for _, id := range p.list("ResourceId") {
+		tags, err := s.taggedResource(id)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, tags)
+	}
+
+	deleted := p.tags("Tag")
+	for _, tags := range resources {
+		var kept []*ec2.Tag
+		for _, tag := range *tags {
+			if len(deleted) > 0 && !tagDeleted(deleted, tag) {
+				kept = append(kept, tag)
+			}
+		}
+		*tags = kept
+	}
+	return &ec2.DeleteTagsOutput{}, nil
+}
+
+// tagDeleted returns true if the tag has the key of a deleted tag and the deleted tag has no
+// value or the same value
+func tagDeleted(deleted []*ec2.Tag, tag *ec2.Tag) bool {
+	for _, d := range deleted {
+		if *d.Key == *tag.Key && (d.Value == nil || *d.Value == aws.StringValue(tag.Value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) describeSnapshotAttribute(p params) (interface{}, *apiError) {
+	id := p.get("SnapshotId")
+	if _, ok := s.snapshots[id]; !ok {
+		return nil, notFound("InvalidSnapshot.NotFound", "snapshot", id)
+	}
+
+	output := &ec2.DescribeSnapshotAttributeOutput{SnapshotId: aws.String(id)}
+	switch p.get("Attribute") {
+	case ec2.SnapshotAttributeNameCreateVolumePermission:
+		output.CreateVolumePermissions = append([]*ec2.CreateVolumePermission{}, s.shares[id]...)
+	case ec2.SnapshotAttributeNameProductCodes:
+		output.ProductCodes = []*ec2.ProductCode{}
+	default:
+		return nil, invalidParameter("InvalidParameterValue", "Invalid attribute '%s'", p.get("Attribute"))
+	}
+	return output, nil
+}
+
+func (s *Server) modifySnapshotAttribute(p params) (interface{}, *apiError) {
+	id := p.get("SnapshotId")
+	if _, ok := s.snapshots[id]; !ok {
+		return nil, notFound("InvalidSnapshot.NotFound", "snapshot", id)
+	}
+	if attribute := p.get("Attribute"); attribute != "" && attribute != ec2.SnapshotAttributeNameCreateVolumePermission {
+		return nil, invalidParameter("InvalidParameterValue", "Invalid attribute '%s'", attribute)
+	}
+
+	add := p.permissions("CreateVolumePermission.Add")
+	remove := p.permissions("CreateVolumePermission.Remove")
+
+	var permissions []*ec2.CreateVolumePermission
+	for _, userID := range p.list("UserId") {
+		permissions = append(permissions, &ec2.CreateVolumePermission{UserId: aws.String(userID)})
+	}
+	for _, group := range p.list("UserGroup") {
+		permissions = append(permissions, &ec2.CreateVolumePermission{Group: aws.String(group)})
+	}
+	switch p.get("OperationType") {
+	case ec2.OperationTypeAdd:
+		add = append(add, permissions...)
+	case ec2.OperationTypeRemove:
+		remove = append(remove, permissions...)
+	}
+
+	for _, permission := range add {
+		if !hasPermission(s.shares[id], permission) {
+			s.shares[id] = append(s.shares[id], permission)
+		}
+	}
+
+	var kept []*ec2.CreateVolumePermission
+	for _, permission := range s.shares[id] {
+		if !hasPermission(remove, permission) {
+			kept = append(kept, permission)
+		}
+	}
+	s.shares[id] = kept
+
+	return &ec2.ModifySnapshotAttributeOutput{}, nil
+}
+
+func hasPermission(permissions []*ec2.CreateVolumePermission, permission *ec2.CreateVolumePermission) bool {
+	for _, p := range permissions {
+		if aws.StringValue(p.Group) == aws.StringValue(permission.Group) && aws.StringValue(p.UserId) == aws.StringValue(permission.UserId) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) resetSnapshotAttribute(p params) (interface{}, *apiError) {
+	id := p.get("SnapshotId")
+	if _, ok := s.snapshots[id]; !ok {
+		return nil, notFound("InvalidSnapshot.NotFound", "snapshot", id)
+	}
+
+	delete(s.shares, id)
+	return &ec2.ResetSnapshotAttributeOutput{}, nil
+}
+
+func (s *Server) createVolume(p params) (interface{}, *apiError) {
+	if p.get("AvailabilityZone") == "" {
+		return nil, invalidParameter("MissingParameter", "The request must contain the parameter AvailabilityZone")
+	}
+
+	size, err := p.int64("Size")
+	if err != nil {
+		return nil, err
+	}
+	iops, err := p.int64("Iops")
+	if err != nil {
+		return nil, err
+	}
+
+	v := &ec2.Volume{
+		VolumeId:         aws.String(s.newID("vol")),
+		AvailabilityZone: aws.String(p.get("AvailabilityZone")),
+		Size:             size,
+		Iops:             iops,
+		VolumeType:       aws.String(ec2.VolumeTypeGp2),
+		Encrypted:        aws.Bool(p.bool("Encrypted")),
+		KmsKeyId:         p.string("KmsKeyId"),
+		CreateTime:       aws.Time(s.now()),
+		State:            aws.String(ec2.VolumeStateAvailable),
+	}
+	if volumeType := p.get("VolumeType"); volumeType != "" {
+		v.VolumeType = aws.String(volumeType)
+	}
+
+	if snapshotID := p.get("SnapshotId"); snapshotID != "" {
+		snap, ok := s.snapshots[snapshotID]
+		if !ok {
+			return nil, notFound("InvalidSnapshot.NotFound", "snapshot", snapshotID)
+		}
+		v.SnapshotId = snap.SnapshotId
+		if v.Size == nil {
+			v.Size = snap.VolumeSize
+		}
+		if aws.BoolValue(snap.Encrypted) {
+			v.Encrypted = aws.Bool(true)
+			if v.KmsKeyId == nil {
+				v.KmsKeyId = snap.KmsKeyId
+			}
+		}
+	}
+
+	if v.Size == nil {
+		return nil, invalidParameter("MissingParameter", "The request must contain the parameter size or snapshotId")
+	}
+
+	s.volumes[*v.VolumeId] = v
+	return copyVolume(v), nil
+}
+
+func (s *Server) attachVolume(p params) (interface{}, *apiError) {
+	v, ok := s.volumes[p.get("VolumeId")]
+	if !ok {
+		return nil, notFound("InvalidVolume.NotFound", "volume", p.get("VolumeId"))
+	}
+	instance, ok := s.instances[p.get("InstanceId")]
+	if !ok {
+		return nil, notFound("InvalidInstanceID.NotFound", "instance ID", p.get("InstanceId"))
+	}
+	if aws.StringValue(v.State) != ec2.VolumeStateAvailable {
+		return nil, invalidParameter("IncorrectState", "%s is not 'available'.", *v.VolumeId)
+	}
+	if zone := aws.StringValue(instance.Placement.AvailabilityZone); zone != *v.AvailabilityZone {
+		return nil, invalidParameter("InvalidVolume.ZoneMismatch", "The volume '%s' is not in the same availability zone as instance '%s'", *v.VolumeId, *instance.InstanceId)
+	}
+
+	attachment := &ec2.VolumeAttachment{
+		VolumeId:   v.VolumeId,
+		InstanceId: instance.InstanceId,
+		Device:     aws.String(p.get("Device")),
+		AttachTime: aws.Time(s.now()),
+		State:      aws.String(ec2.VolumeAttachmentStateAttached),
+	}
+	v.Attachments = []*ec2.VolumeAttachment{attachment}
+	v.State = aws.String(ec2.VolumeStateInUse)
+	return awsutil.CopyOf(attachment), nil
+}
+
+func (s *Server) detachVolume(p params) (interface{}, *apiError) {
+	v, ok := s.volumes[p.get("VolumeId")]
+	if !ok {
+		return nil, notFound("InvalidVolume.NotFound", "volume", p.get("VolumeId"))
+	}
+	if len(v.Attachments) == 0 {
+		return nil, invalidParameter("IncorrectState", "Volume '%s' is in the 'available' state.", *v.VolumeId)
+	}
+
+	attachment := v.Attachments[0]
+	attachment.State = aws.String(ec2.VolumeAttachmentStateDetached)
+	v.Attachments = nil
+	v.State = aws.String(ec2.VolumeStateAvailable)
+	return attachment, nil
+}
+
+func (s *Server) deleteVolume(p params) (interface{}, *apiError) {
+	v, ok := s.volumes[p.get("VolumeId")]
+	if !ok {
+		return nil, notFound("InvalidVolume.NotFound", "volume", p.get("VolumeId"))
+	}
+	if aws.StringValue(v.State) != ec2.VolumeStateAvailable {
+		return nil, invalidParameter("VolumeInUse", "Volume %s is currently attached to %s", *v.VolumeId, aws.StringValue(v.Attachments[0].InstanceId))
+	}
+
+	delete(s.volumes, *v.VolumeId)
+	return &ec2.DeleteVolumeOutput{}, nil
+}
+
+func (s *Server) describeInstances(p params) (interface{}, *apiError) {
+	ids := p.list("InstanceId")
+	for _, id := range ids {
+		if _, ok := s.instances[id]; !ok {
+			return nil, notFound("InvalidInstanceID.NotFound", "instance ID", id)
+		}
+	}
+
+	var instanceIDs []string
+	for id := range s.instances {
+		instanceIDs = append(instanceIDs, id)
+	}
+	sort.Strings(instanceIDs)
+
+	var reservations []*ec2.Reservation
+	for _, id := range instanceIDs {
+		instance := &ec2.Instance{}
+		awsutil.Copy(instance, s.instances[id])
+		if len(ids) > 0 && !contains(ids, id) {
+			continue
+		}
+		ok, err := matches(p.filters(), instance.Tags, instanceFields(instance))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			reservations = append(reservations, &ec2.Reservation{
+				ReservationId: aws.String("r-" + strings.TrimPrefix(id, "i-")),
+				OwnerId:       aws.String(s.Account),
+				Instances:     []*ec2.Instance{instance},
+			})
+		}
+	}
+
+	start, end, nextToken, err := s.page(p, len(reservations))
+	if err != nil {
+		return nil, err
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: reservations[start:end], NextToken: nextToken}, nil
+}
+
+func instanceFields(instance *ec2.Instance) fields {
+	return func(name string) ([]string, bool) {
+		switch name {
+		case "instance-id":
+			return []string{*instance.InstanceId}, true
+		case "availability-zone":
+			return []string{aws.StringValue(instance.Placement.AvailabilityZone)}, true
+		case "instance-state-name":
+			return []string{aws.StringValue(instance.State.Name)}, true
+		}
+		return nil, false
+	}
+}
+
+func (s *Server) describeRegions(p params) (interface{}, *apiError) {
+	names := s.Regions
+	if len(names) == 0 {
+		names = []string{s.Region}
+	}
+
+	requested := p.list("RegionName")
+	var regions []*ec2.Region
+	for _, name := range names {
+		if len(requested) == 0 || contains(requested, name) {
+			regions = append(regions, &ec2.Region{RegionName: aws.String(name), Endpoint: aws.String("ec2." + name + ".amazonaws.com")})
+		}
+	}
+	return &ec2.DescribeRegionsOutput{Regions: regions}, nil
+}
+
+// setTag sets the value of the tag with the given key, appending it if it isn't present
+func setTag(tags []*ec2.Tag, key string, value string) []*ec2.Tag {
+	for _, tag := range tags {
+		if *tag.Key == key {
+			tag.Value = aws.String(value)
+			return tags
+		}
+	}
+	return append(tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package ebstest
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// apiError is an EC2 error response
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func invalidParameter(code string, format string, args ...interface{}) *apiError {
+	return &apiError{status: http.StatusBadRequest, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+func notFound(code string, resource string, id string) *apiError {
+	return invalidParameter(code, "The %s '%s' does not exist.", resource, id)
+}
+
+// params reads EC2 query protocol request parameters, where lists are flattened as
+// Name.1, Name.2 and structure members as Name.Member
+type params struct {
+	form url.Values
+}
+
+func (p params) get(name string) string {
+	return p.form.Get(name)
+}
+
+func (p params) string(name string) *string {
+	if _, ok := p.form[name]; !ok {
+		return nil
+	}
+	return aws.String(p.form.Get(name))
+}
+
+func (p params) bool(name string) bool {
+	return p.form.Get(name) == "true"
+}
+
+func (p params) int64(name string) (*int64, *apiError) {
+	if _, ok := p.form[name]; !ok {
+		return nil, nil
+	}
+	n, err := strconv.ParseInt(p.form.Get(name), 10, 64)
+	if err != nil {
+		return nil, invalidParameter("InvalidParameterValue", "Invalid value '%s' for %s", p.form.Get(name), name)
+	}
+	return aws.Int64(n), nil
+}
+
+// list returns the values of the list parameter name
+func (p params) list(name string) []string {
+	var values []string
+	for i := 1; ; i++ {
+		key := fmt.Sprintf("%s.%d", name, i)
+		if _, ok := p.form[key]; !ok {
+			return values
+		}
+		values = append(values, p.form.Get(key))
+	}
+}
+
+// count returns the number of items of the list parameter name whose member is set
+func (p params) count(name string, member string) int {
+	for n := 0; ; n++ {
+		if _, ok := p.form[fmt.Sprintf("%s.%d.%s", name, n+1, member)]; !ok {
+			return n
+		}
+	}
+}
+
+// tags returns the tags in the list parameter name. Tags without a Value parameter have a
+// nil value.
+func (p params) tags(name string) []*ec2.Tag {
+	var tags []*ec2.Tag
+	for i := 1; i <= p.count(name, "Key"); i++ {
+		prefix := fmt.Sprintf("%s.%d.", name, i)
+		tags = append(tags, &ec2.Tag{Key: aws.String(p.get(prefix + "Key")), Value: p.string(prefix + "Value")})
+	}
+	return tags
+}
+
+// permissions returns the createVolumePermission items in the list parameter name
+func (p params) permissions(name string) []*ec2.CreateVolumePermission {
+	var permissions []*ec2.CreateVolumePermission
+	for i := 1; ; i++ {
+		prefix := fmt.Sprintf("%s.%d.", name, i)
+		group, userID := p.string(prefix+"Group"), p.string(prefix+"UserId")
+		if group == nil && userID == nil {
+			return permissions
+		}
+		permissions = append(permissions, &ec2.CreateVolumePermission{Group: group, UserId: userID})
+	}
+}
+
+// filter is a Describe request filter
+type filter struct {
+	name   string
+	values []string
+}
+
+func (p params) filters() []filter {
+	var filters []filter
+	for i := 1; ; i++ {
+		prefix := fmt.Sprintf("Filter.%d.", i)
+		if _, ok := p.form[prefix+"Name"]; !ok {
+			return filters
+		}
+		filters = append(filters, filter{name: p.get(prefix + "Name"), values: p.list(prefix + "Value")})
+	}
+}
+
+// fields returns the values of a resource that filters match against, by filter name. Tag
+// filters are matched separately.
+type fields func(name string) (values []string, ok bool)
+
+// matches returns true if the resource matches every filter. A filter matches if any of its
+// values, which may contain * and ? wildcards, matches any of the resource's values.
+func matches(filters []filter, tags []*ec2.Tag, resource fields) (bool, *apiError) {
+	for _, f := range filters {
+		var actual []string
+		switch {
+		case f.name == "tag-key":
+			for _, tag := range tags {
+				actual = append(actual, *tag.Key)
+			}
+		case f.name == "tag-value":
+			for _, tag := range tags {
+				actual = append(actual, aws.StringValue(tag.Value))
+			}
+		case strings.HasPrefix(f.name, "tag:"):
+			for _, tag := range tags {
+				if *tag.Key == f.name[len("tag:"):] {
+					actual = append(actual, aws.StringValue(tag.Value))
+				}
+			}
+		default:
+			values, ok := resource(f.name)
+			if !ok {
+				return false, invalidParameter("InvalidParameterValue", "The filter '%s' is invalid", f.name)
+			}
+			actual = values
+		}
+
+		if !anyMatch(f.values, actual) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func anyMatch(patterns []string, values []string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if ok, _ := path.Match(pattern, value); ok || pattern == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// page returns the bounds of the requested page of n results and the token of the next page,
+// if any
+func (s *Server) page(p params, n int) (start int, end int, nextToken *string, err *apiError) {
+	if token := p.get("NextToken"); token != "" {
+		var convErr error
+		if start, convErr = strconv.Atoi(token); convErr != nil || start < 0 || start > n {
+			return 0, 0, nil, invalidParameter("InvalidParameterValue", "Invalid NextToken '%s'", token)
+		}
+	}
+
+	size := s.PageSize
+	maxResults, err := p.int64("MaxResults")
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if maxResults != nil && (size == 0 || int(*maxResults) < size) {
+		size = int(*maxResults)
+	}
+
+	end = n
+	if size > 0 && start+size < n {
+		end = start + size
+		nextToken = aws.String(strconv.Itoa(end))
+	}
+	return start, end, nextToken, nil
+}
+
+func boolString(b *bool) string {
+	return strconv.FormatBool(aws.BoolValue(b))
+}
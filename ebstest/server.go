@@ -0,0 +1,374 @@
+// Package ebstest provides a stateful, in-memory fake of the EC2 API for testing code that
+// manages EBS volumes and snapshots, such as the ebs package, without AWS credentials or
+// network access.
+//
+// The fake speaks the EC2 query protocol over HTTP, so it is used by pointing an AWS SDK
+// client at its URL:
+//
+//	srv := ebstest.NewServer("us-west-2")
+//	defer srv.Close()
+//	vol := srv.AddVolume(&ec2.Volume{Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1")}}})
+//	mgr, _ := ebs.NewSnapshotManager("us-west-2", srv.URL, true, 3, false)
+//	mgr.Run()
+//	snapshots := srv.Snapshots()
+//
+// It tracks volumes, snapshots, instances, tags and snapshot permissions across requests,
+// supports the filters and pagination used by this project, and can inject errors and
+// throttling into any action. Snapshots complete as soon as they are created.
+package ebstest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/private/protocol/xml/xmlutil"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// DefaultAccount is the AWS account ID that owns the fake's snapshots unless Account is set
+const DefaultAccount = "123456789012"
+
+// ThrottleCode is the error code returned by throttled requests, which the AWS SDK retries
+const ThrottleCode = "RequestLimitExceeded"
+
+// Server is a fake EC2 endpoint for a single region. Its exported fields must be set before
+// the first request. It is safe for concurrent use.
+type Server struct {
+	// The URL of the fake, to use as the EC2 endpoint
+	URL string
+
+	// The region the fake serves. Volumes and instances default to its "a" availability zone.
+	Region string
+
+	// The account ID that owns the fake's snapshots, matched by the "self" owner
+	Account string
+
+	// The regions returned by DescribeRegions. Defaults to Region.
+	Regions []string
+
+	// If set, Describe responses are limited to PageSize results even when the request sets
+	// no MaxResults, so that callers' pagination is exercised
+	PageSize int
+
+	// If set, the time of new snapshots, volumes and attachments. By default each is one
+	// second after the last, starting at the time the server was created, so that snapshots
+	// sort in the order they were created.
+	Now func() time.Time
+
+	server    *httptest.Server
+	mu        sync.Mutex
+	clock     time.Time
+	lastID    int
+	volumes   map[string]*ec2.Volume
+	snapshots map[string]*ec2.Snapshot
+	instances map[string]*ec2.Instance
+	shares    map[string][]*ec2.CreateVolumePermission
+	failures  map[string][]*apiError
+	calls     map[string]int
+}
+
+// NewServer starts and returns a new fake EC2 endpoint for the region. Close it when done.
+func NewServer(region string) *Server {
+	s := &Server{
+		Region:    region,
+		Account:   DefaultAccount,
+		clock:     time.Now().UTC().Truncate(time.Second),
+		volumes:   make(map[string]*ec2.Volume),
+		snapshots: make(map[string]*ec2.Snapshot),
+		instances: make(map[string]*ec2.Instance),
+		shares:    make(map[string][]*ec2.CreateVolumePermission),
+		failures:  make(map[string][]*apiError),
+		calls:     make(map[string]int),
+	}
+	s.server = httptest.NewServer(s)
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// AddVolume adds a copy of the volume and returns it. A volume ID, the "a" availability zone,
+// an 8 GiB gp2 size and type, a creation time, and a state of in-use if it has attachments or
+// available otherwise are filled in if not set. Attachments default to the attached state.
+func (s *Server) AddVolume(volume *ec2.Volume) *ec2.Volume {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v := copyVolume(volume)
+	if v.VolumeId == nil {
+		v.VolumeId = aws.String(s.newID("vol"))
+	}
+	if v.AvailabilityZone == nil {
+		v.AvailabilityZone = aws.String(s.Region + "a")
+	}
+	if v.Size == nil {
+		v.Size = aws.Int64(8)
+	}
+	if v.VolumeType == nil {
+		v.VolumeType = aws.String(ec2.VolumeTypeGp2)
+	}
+	if v.Encrypted == nil {
+		v.Encrypted = aws.Bool(false)
+	}
+	if v.CreateTime == nil {
+		v.CreateTime = aws.Time(s.now())
+	}
+	for _, attachment := range v.Attachments {
+		attachment.VolumeId = v.VolumeId
+		if attachment.State == nil {
+			attachment.State = aws.String(ec2.VolumeAttachmentStateAttached)
+		}
+	}
+	if v.State == nil {
+		v.State = aws.String(ec2.VolumeStateAvailable)
+		if len(v.Attachments) > 0 {
+			v.State = aws.String(ec2.VolumeStateInUse)
+		}
+	}
+
+	s.volumes[*v.VolumeId] = v
+	return copyVolume(v)
+}
+
+// AddSnapshot adds a copy of the snapshot and returns it. A snapshot ID, the completed state,
+// the server's account as owner and a start time are filled in if not set.
+func (s *Server) AddSnapshot(snapshot *ec2.Snapshot) *ec2.Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := copySnapshot(snapshot)
+	if snap.SnapshotId == nil {
+		snap.SnapshotId = aws.String(s.newID("snap"))
+	}
+	if snap.State == nil {
+		snap.State = aws.String(ec2.SnapshotStateCompleted)
+		snap.Progress = aws.String("100%")
+	}
+	if snap.OwnerId == nil {
+		snap.OwnerId = aws.String(s.Account)
+	}
+	if snap.Encrypted == nil {
+		snap.Encrypted = aws.Bool(false)
+	}
+	if snap.StartTime == nil {
+		snap.StartTime = aws.Time(s.now())
+	}
+
+	s.snapshots[*snap.SnapshotId] = snap
+	return copySnapshot(snap)
+}
+
+// AddInstance adds a copy of the instance and returns it. An instance ID, the running state
+// and the "a" availability zone are filled in if not set.
+func (s *Server) AddInstance(instance *ec2.Instance) *ec2.Instance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := &ec2.Instance{}
+	awsutil.Copy(i, instance)
+	if i.InstanceId == nil {
+		i.InstanceId = aws.String(s.newID("i"))
+	}
+	if i.State == nil {
+		i.State = &ec2.InstanceState{Code: aws.Int64(16), Name: aws.String(ec2.InstanceStateNameRunning)}
+	}
+	if i.Placement == nil {
+		i.Placement = &ec2.Placement{AvailabilityZone: aws.String(s.Region + "a")}
+	}
+
+	s.instances[*i.InstanceId] = i
+	out := &ec2.Instance{}
+	awsutil.Copy(out, i)
+	return out
+}
+
+// Volume returns a copy of the volume, or nil if it doesn't exist
+func (s *Server) Volume(volumeID string) *ec2.Volume {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if v, ok := s.volumes[volumeID]; ok {
+		return copyVolume(v)
+	}
+	return nil
+}
+
+// Volumes returns copies of all volumes, sorted by creation time
+func (s *Server) Volumes() []*ec2.Volume {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedVolumes()
+}
+
+// Snapshot returns a copy of the snapshot, or nil if it doesn't exist
+func (s *Server) Snapshot(snapshotID string) *ec2.Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if snap, ok := s.snapshots[snapshotID]; ok {
+		return copySnapshot(snap)
+	}
+	return nil
+}
+
+// Snapshots returns copies of all snapshots, oldest first
+func (s *Server) Snapshots() []*ec2.Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedSnapshots()
+}
+
+// SnapshotPermissions returns the createVolumePermission of the snapshot
+func (s *Server) SnapshotPermissions(snapshotID string) []*ec2.CreateVolumePermission {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var permissions []*ec2.CreateVolumePermission
+	for _, p := range s.shares[snapshotID] {
+		permissions = append(permissions, &ec2.CreateVolumePermission{Group: p.Group, UserId: p.UserId})
+	}
+	return permissions
+}
+
+// Calls returns how many requests have been made for the action, e.g. "DeleteSnapshot",
+// including failed ones
+func (s *Server) Calls(action string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[action]
+}
+
+// Fail makes the next n requests for the action fail with the given error code and message,
+// e.g. InvalidSnapshot.InUse, without changing any state
+func (s *Server) Fail(action string, n int, code string, message string) {
+	s.inject(action, n, &apiError{status: http.StatusBadRequest, code: code, message: message})
+}
+
+// Throttle makes the next n requests for the action fail with ThrottleCode. The AWS SDK
+// retries throttled requests after a backoff of around a second.
+func (s *Server) Throttle(action string, n int) {
+	s.inject(action, n, &apiError{status: http.StatusServiceUnavailable, code: ThrottleCode, message: "Request limit exceeded."})
+}
+
+func (s *Server) inject(action string, n int, err *apiError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.failures[action] = append(s.failures[action], err)
+	}
+}
+
+// ServeHTTP handles an EC2 query protocol request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	action := r.Form.Get("Action")
+
+	s.mu.Lock()
+	s.calls[action]++
+	requestID := s.newID("req")
+
+	var output interface{}
+	var err *apiError
+	if failures := s.failures[action]; len(failures) > 0 {
+		err = failures[0]
+		s.failures[action] = failures[1:]
+	} else if handler, ok := actions[action]; ok {
+		output, err = handler(s, params{r.Form})
+	} else {
+		err = invalidParameter("InvalidAction", "The action %s is not valid for this web service.", action)
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("X-Amzn-Requestid", requestID)
+	w.Header().Set("Content-Type", "text/xml;charset=UTF-8")
+
+	if err != nil {
+		w.WriteHeader(err.status)
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<Response><Errors><Error><Code>%s</Code><Message>%s</Message></Error></Errors><RequestID>%s</RequestID></Response>`,
+			err.code, xmlEscape(err.message), requestID)
+		return
+	}
+
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<%sResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><requestId>%s</requestId>`, action, requestID)
+	// BuildXML writes the output's members without an enclosing element
+	xmlutil.BuildXML(output, xml.NewEncoder(w))
+	fmt.Fprintf(w, "</%sResponse>", action)
+}
+
+// now returns the time of a new resource
+func (s *Server) now() time.Time {
+	if s.Now != nil {
+		return s.Now().UTC()
+	}
+	s.clock = s.clock.Add(time.Second)
+	return s.clock
+}
+
+// newID returns a new unique resource ID with the prefix, e.g. snap-00000001
+func (s *Server) newID(prefix string) string {
+	s.lastID++
+	return fmt.Sprintf("%s-%08x", prefix, s.lastID)
+}
+
+func (s *Server) sortedVolumes() []*ec2.Volume {
+	volumes := make([]*ec2.Volume, 0, len(s.volumes))
+	for _, v := range s.volumes {
+		volumes = append(volumes, copyVolume(v))
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		if !volumes[i].CreateTime.Equal(*volumes[j].CreateTime) {
+			return volumes[i].CreateTime.Before(*volumes[j].CreateTime)
+		}
+		return *volumes[i].VolumeId < *volumes[j].VolumeId
+	})
+	return volumes
+}
+
+func (s *Server) sortedSnapshots() []*ec2.Snapshot {
+	snapshots := make([]*ec2.Snapshot, 0, len(s.snapshots))
+	for _, snap := range s.snapshots {
+		snapshots = append(snapshots, copySnapshot(snap))
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		if !snapshots[i].StartTime.Equal(*snapshots[j].StartTime) {
+			return snapshots[i].StartTime.Before(*snapshots[j].StartTime)
+		}
+		return *snapshots[i].SnapshotId < *snapshots[j].SnapshotId
+	})
+	return snapshots
+}
+
+func copyVolume(volume *ec2.Volume) *ec2.Volume {
+	v := &ec2.Volume{}
+	awsutil.Copy(v, volume)
+	return v
+}
+
+func copySnapshot(snapshot *ec2.Snapshot) *ec2.Snapshot {
+	snap := &ec2.Snapshot{}
+	awsutil.Copy(snap, snapshot)
+	return snap
+}
+
+func xmlEscape(text string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(text))
+	return buf.String()
+}
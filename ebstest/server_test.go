@@ -0,0 +1,142 @@
+package ebstest
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newClient(t *testing.T, srv *Server) *ec2.EC2 {
+	sess, err := session.NewSession(aws.NewConfig().
+		WithRegion(srv.Region).
+		WithEndpoint(srv.URL).
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	assert.NoError(t, err)
+	return ec2.New(sess)
+}
+
+func TestSnapshotLifecycle(t *testing.T) {
+	srv := NewServer("us-west-1")
+	defer srv.Close()
+	client := newClient(t, srv)
+
+	volume := srv.AddVolume(&ec2.Volume{
+		Size:        aws.Int64(20),
+		Tags:        []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("data")}},
+		Attachments: []*ec2.VolumeAttachment{{InstanceId: aws.String("i-1"), Device: aws.String("/dev/sdf")}},
+	})
+	srv.AddVolume(&ec2.Volume{})
+
+	attached, err := client.DescribeVolumes(&ec2.DescribeVolumesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("attachment.status"), Values: []*string{aws.String("attached")}}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, attached.Volumes, 1)
+	assert.Equal(t, *volume.VolumeId, *attached.Volumes[0].VolumeId)
+	assert.Equal(t, "data", *attached.Volumes[0].Tags[0].Value)
+
+	snapshot, err := client.CreateSnapshot(&ec2.CreateSnapshotInput{VolumeId: volume.VolumeId, Description: aws.String("backup")})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(20), *snapshot.VolumeSize)
+	assert.Equal(t, ec2.SnapshotStateCompleted, *snapshot.State)
+
+	_, err = client.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{snapshot.SnapshotId},
+		Tags:      []*ec2.Tag{{Key: aws.String("Policy"), Value: aws.String("daily")}},
+	})
+	assert.NoError(t, err)
+
+	tagged, err := client.DescribeSnapshots(&ec2.DescribeSnapshotsInput{
+		OwnerIds: []*string{aws.String("self")},
+		Filters:  []*ec2.Filter{{Name: aws.String("tag:Policy"), Values: []*string{aws.String("daily")}}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, tagged.Snapshots, 1)
+	assert.Equal(t, *volume.VolumeId, *tagged.Snapshots[0].VolumeId)
+
+	_, err = client.ModifySnapshotAttribute(&ec2.ModifySnapshotAttributeInput{
+		SnapshotId: snapshot.SnapshotId,
+		Attribute:  aws.String(ec2.SnapshotAttributeNameCreateVolumePermission),
+		CreateVolumePermission: &ec2.CreateVolumePermissionModifications{
+			Add: []*ec2.CreateVolumePermission{{Group: aws.String("all")}, {UserId: aws.String("111111111111")}},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, srv.SnapshotPermissions(*snapshot.SnapshotId), 2)
+
+	attribute, err := client.DescribeSnapshotAttribute(&ec2.DescribeSnapshotAttributeInput{
+		SnapshotId: snapshot.SnapshotId,
+		Attribute:  aws.String(ec2.SnapshotAttributeNameCreateVolumePermission),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, attribute.CreateVolumePermissions, 2)
+
+	_, err = client.DeleteSnapshot(&ec2.DeleteSnapshotInput{SnapshotId: snapshot.SnapshotId})
+	assert.NoError(t, err)
+	assert.Nil(t, srv.Snapshot(*snapshot.SnapshotId))
+	assert.Empty(t, srv.Snapshots())
+	assert.Equal(t, 1, srv.Calls("DeleteSnapshot"))
+
+	_, err = client.DeleteSnapshot(&ec2.DeleteSnapshotInput{SnapshotId: snapshot.SnapshotId})
+	assert.Equal(t, "InvalidSnapshot.NotFound", err.(awserr.Error).Code())
+}
+
+func TestDescribeSnapshotsPagination(t *testing.T) {
+	srv := NewServer("us-west-1")
+	srv.PageSize = 2
+	defer srv.Close()
+	client := newClient(t, srv)
+
+	volume := srv.AddVolume(&ec2.Volume{})
+	for i := 0; i < 5; i++ {
+		srv.AddSnapshot(&ec2.Snapshot{VolumeId: volume.VolumeId})
+	}
+
+	pages := 0
+	var snapshots []*ec2.Snapshot
+	err := client.DescribeSnapshotsPages(&ec2.DescribeSnapshotsInput{}, func(page *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+		pages++
+		snapshots = append(snapshots, page.Snapshots...)
+		return true
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, pages)
+	assert.Len(t, snapshots, 5)
+	assert.True(t, snapshots[0].StartTime.Before(*snapshots[4].StartTime))
+}
+
+func TestInjectedErrorsAndThrottling(t *testing.T) {
+	srv := NewServer("us-west-1")
+	defer srv.Close()
+	client := newClient(t, srv)
+
+	snapshot := srv.AddSnapshot(&ec2.Snapshot{VolumeId: aws.String("vol-1")})
+
+	srv.Fail("DeleteSnapshot", 1, "InvalidSnapshot.InUse", "The snapshot is in use by ami-1")
+	_, err := client.DeleteSnapshot(&ec2.DeleteSnapshotInput{SnapshotId: snapshot.SnapshotId})
+	assert.Equal(t, "InvalidSnapshot.InUse", err.(awserr.Error).Code())
+	assert.NotNil(t, srv.Snapshot(*snapshot.SnapshotId))
+
+	srv.Throttle("DeleteSnapshot", 1)
+	_, err = client.DeleteSnapshot(&ec2.DeleteSnapshotInput{SnapshotId: snapshot.SnapshotId})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, srv.Calls("DeleteSnapshot"))
+	assert.Nil(t, srv.Snapshot(*snapshot.SnapshotId))
+}
+
+func TestInvalidFilter(t *testing.T) {
+	srv := NewServer("us-west-1")
+	defer srv.Close()
+	client := newClient(t, srv)
+	srv.AddVolume(&ec2.Volume{})
+
+	_, err := client.DescribeVolumes(&ec2.DescribeVolumesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("no-such-filter"), Values: []*string{aws.String("x")}}},
+	})
+	assert.Equal(t, "InvalidParameterValue", err.(awserr.Error).Code())
+}
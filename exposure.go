@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+	"github.com/healthcareblocks/ebs_snapshotter/ebs"
+)
+
+// regionExposure holds the exposed snapshots of a single region for output
+type regionExposure struct {
+	Region    string                 `json:"region"`
+	Snapshots []ebs.SnapshotExposure `json:"snapshots"`
+}
+
+// exposureCommand reports the managed snapshots, or all snapshots, in each region that are
+// public or shared with unapproved accounts, optionally removing those permissions. It exits with auditExitCode
+// if any exposed snapshot remains.
+func exposureCommand(args []string) {
+	flags := flag.NewFlagSet("exposure", flag.ExitOnError)
+	regions := flags.String("regions", "", "AWS EC2 regions (comma delimited) to check, or \"all\" for every enabled region.\n\tIf not set this value is determined using the host machine's EC2 metadata.")
+	excludeRegions := flags.String("exclude_regions", "", "AWS EC2 regions (comma delimited) to skip, e.g. with -regions=all")
+	approved := flags.String("approved_accounts", "", "AWS account IDs (comma delimited) snapshots may be shared with")
+	remediate := flags.Bool("remediate", false, "Remove public and unapproved shares from exposed snapshots")
+	allSnapshots := flags.Bool("all_snapshots", false, "Check every snapshot owned by the account, not only the snapshots of the volumes\n\ta snapshot run would select and those tagged by ebs_snapshotter")
+	includeUnattached := flags.Bool("include_unattached", false, "Check the snapshots of unattached (available) volumes too, as when snapshotting\n\twith -include_unattached")
+	format := flags.String("format", "table", "Output format: table or json")
+	debug := flags.Bool("d", false, "Turns on AWS request profiling")
+	auditLog := newAuditLogFlags(flags)
+	flags.Parse(args)
+
+	if *format != "table" && *format != "json" {
+		log.Fatalf("unknown -format %q, must be table or json", *format)
+	}
+
+	regionNames := regionList(*regions, *excludeRegions)
+	logger := auditLog.open(regionNames[0])
+
+	var results []regionExposure
+	for _, region := range regionNames {
+		mgr, err := ebs.NewSnapshotManager(region, "", false, 1, *debug)
+		awserror.HandleError(err)
+		mgr.IncludeUnattached = *includeUnattached
+		if logger != nil {
+			mgr.SetAuditLog(logger)
+		}
+		exposures, err := mgr.AuditExposure(ebs.ExposureOptions{
+			ApprovedAccounts: splitList(*approved),
+			Remediate:        *remediate,
+			AllSnapshots:     *allSnapshots,
+		})
+		if err != nil {
+			closeAuditLog(logger)
+			awserror.HandleError(err)
+		}
+		results = append(results, regionExposure{Region: region, Snapshots: exposures})
+	}
+	closeAuditLog(logger)
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "REGION\tSNAPSHOT\tVOLUME\tPUBLIC\tUNAPPROVED ACCOUNTS\tSTATUS")
+		for _, result := range results {
+			for _, exposure := range result.Snapshots {
+				status := "exposed"
+				if exposure.Remediated {
+					status = "remediated"
+				} else if exposure.Error != "" {
+					status = "remediation failed: " + exposure.Error
+				}
+
+				accounts := strings.Join(exposure.Accounts, ",")
+				if accounts == "" {
+					accounts = "-"
+				}
+
+				fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\n",
+					result.Region, exposure.SnapshotID, exposure.VolumeID, exposure.Public, accounts, status)
+			}
+		}
+		w.Flush()
+	}
+
+	for _, result := range results {
+		for _, exposure := range result.Snapshots {
+			if !exposure.Remediated {
+				os.Exit(auditExitCode)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+	"github.com/healthcareblocks/ebs_snapshotter/ebs"
+)
+
+// regionSnapshots holds the listings of a single region for output
+type regionSnapshots struct {
+	Region  string                `json:"region"`
+	Volumes []ebs.VolumeSnapshots `json:"volumes"`
+}
+
+// listCommand prints the snapshots of each volume, showing which the retention
+// policy would keep or delete
+func listCommand(args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	regions := flags.String("regions", "", "AWS EC2 regions (comma delimited) to list snapshots for, or \"all\" for every\n\tenabled region. If not set this value is determined using the host machine's EC2 metadata.")
+	volumes := flags.String("volumes", "", "Volume IDs (comma delimited) to list snapshots for. If not set, all snapshotted\n\tvolumes are listed.")
+	retainCount := flags.Int("retain", 7, "Retention policy used to mark snapshots as keep or delete")
+	backupKeyNameDevice := flags.Bool("backup_key_name_device", false, "Group volumes without an ebs_snapshotter:backup_key tag by their Name tag and device")
+	requireEncryption := flags.Bool("require_encryption", false, "Count unencrypted snapshots and their pending encrypted copies once, as when\n\tsnapshotting with -require_encryption")
+	format := flags.String("format", "table", "Output format: table or json")
+	excludeRegions := flags.String("exclude_regions", "", "AWS EC2 regions (comma delimited) to skip, e.g. with -regions=all")
+	debug := flags.Bool("d", false, "Turns on AWS request profiling")
+	flags.Parse(args)
+	warnBelowRetentionFloor(*retainCount)
+
+	if *format != "table" && *format != "json" {
+		log.Fatalf("unknown -format %q, must be table or json", *format)
+	}
+
+	var volumeIDs []string
+	if *volumes != "" {
+		volumeIDs = strings.Split(*volumes, ",")
+	}
+
+	var results []regionSnapshots
+	for _, region := range regionList(*regions, *excludeRegions) {
+		mgr, err := ebs.NewSnapshotManager(region, "", false, *retainCount, *debug)
+		awserror.HandleError(err)
+		mgr.BackupKeyFromNameAndDevice = *backupKeyNameDevice
+		mgr.RequireEncryption = *requireEncryption
+		listings, err := mgr.ListSnapshots(volumeIDs)
+		awserror.HandleError(err)
+		results = append(results, regionSnapshots{Region: region, Volumes: listings})
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	for _, result := range results {
+		for _, volume := range result.Volumes {
+			fmt.Printf("%s %s", result.Region, volume.VolumeID)
+			if volume.Name != "" {
+				fmt.Printf(" (%s)", volume.Name)
+			}
+			fmt.Println()
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "  SNAPSHOT\tSTARTED\tSTATE\tPROGRESS\tSIZE (GiB)\tENCRYPTED\tRETENTION\tTAGS")
+			for _, snapshot := range volume.Snapshots {
+				retention := "keep"
+				if snapshot.Expired {
+					retention = "delete"
+				}
+
+				fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%d\t%t\t%s\t%s\n",
+					aws.StringValue(snapshot.SnapshotId),
+					aws.TimeValue(snapshot.StartTime).Format(time.RFC3339),
+					aws.StringValue(snapshot.State),
+					aws.StringValue(snapshot.Progress),
+					aws.Int64Value(snapshot.VolumeSize),
+					aws.BoolValue(snapshot.Encrypted),
+					retention,
+					formatTags(snapshot.Tags))
+			}
+			w.Flush()
+			fmt.Println()
+		}
+	}
+}
+
+// formatTags renders tags as a sorted, comma delimited list of key=value pairs
+func formatTags(tags []*ec2.Tag) string {
+	pairs := make([]string, len(tags))
+	for i, tag := range tags {
+		pairs[i] = aws.StringValue(tag.Key) + "=" + aws.StringValue(tag.Value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
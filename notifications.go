@@ -0,0 +1,144 @@
+package main
+
+import (
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/healthcareblocks/ebs_snapshotter/notify"
+	"github.com/healthcareblocks/ebs_snapshotter/report"
+	"github.com/healthcareblocks/ebs_snapshotter/ses"
+	"github.com/healthcareblocks/ebs_snapshotter/sns"
+	"github.com/healthcareblocks/ebs_snapshotter/sts"
+)
+
+// notifications renders alerts from the notification flag templates and sends them
+// with every notifier configured on the command line. Templates that are not set
+// fall back to the report's default subject and message.
+type notifications struct {
+	notifiers []notify.Notifier
+
+	subject *report.Template
+	message *report.Template
+	email   *report.Template
+	sms     *report.Template
+}
+
+// newNotifications configures notifiers and parses the notification templates from
+// flags, exiting if any are invalid so that mistakes are caught before snapshots are taken
+func newNotifications() *notifications {
+	n := &notifications{
+		subject: mustParseTemplate("sns_subject", *notifySubject),
+		message: mustParseTemplate("sns_message", *notifyMessage),
+		email:   mustParseTemplate("email_message", *emailMessage),
+		sms:     mustParseTemplate("sms_message", *smsMessage),
+	}
+
+	if *snsTopic != "" {
+		n.add(&sns.Notifier{Region: *snsRegion, Topic: *snsTopic}, mustParsePolicy("sns_policy", *snsPolicy))
+	}
+
+	if *snsWarningTopic != "" {
+		n.add(&sns.Notifier{Region: *snsRegion, Topic: *snsWarningTopic}, notify.MinSeverity(report.Warning))
+	}
+
+	if *snsErrorTopic != "" {
+		n.add(&sns.Notifier{Region: *snsRegion, Topic: *snsErrorTopic}, notify.MinSeverity(report.Error))
+	}
+
+	if *sesFrom != "" {
+		if *sesTo == "" {
+			log.Fatal("-ses_to is required when -ses_from is set")
+		}
+		n.add(&ses.Notifier{Region: *sesRegion, From: *sesFrom, To: strings.Split(*sesTo, ",")}, mustParsePolicy("ses_policy", *sesPolicy))
+	}
+
+	if *webhookURL != "" {
+		n.add(&notify.Webhook{URL: *webhookURL, Secret: *webhookSecret}, mustParsePolicy("webhook_policy", *webhookPolicy))
+	}
+
+	if *slackURL != "" {
+		n.add(&notify.Slack{URL: *slackURL}, mustParsePolicy("slack_policy", *slackPolicy))
+	}
+
+	return n
+}
+
+// add registers a notifier that is only notified when policy matches the run
+func (n *notifications) add(notifier notify.Notifier, policy notify.Policy) {
+	n.notifiers = append(n.notifiers, &notify.Filter{Notifier: notifier, Policy: policy})
+}
+
+// enabled returns true if at least one notifier is configured
+func (n *notifications) enabled() bool {
+	return len(n.notifiers) > 0
+}
+
+// send renders a notification for the report and delivers it with each notifier,
+// returning the number of notifiers that failed
+func (n *notifications) send(r *report.Report) int {
+	if !n.enabled() {
+		return 0
+	}
+
+	notification := &notify.Notification{
+		Subject:  render(n.subject, r, r.Subject()),
+		Message:  render(n.message, r, r.Message()),
+		Severity: r.Severity(),
+		Bodies:   map[string]string{},
+		Report:   r,
+	}
+	if n.email != nil {
+		notification.Bodies["email"] = render(n.email, r, notification.Message)
+	}
+	if n.sms != nil {
+		notification.Bodies["sms"] = render(n.sms, r, notification.Message)
+	}
+
+	return notify.Send(n.notifiers, notification)
+}
+
+func mustParsePolicy(name string, value string) notify.Policy {
+	policy, err := notify.ParsePolicy(value)
+	if err != nil {
+		log.Fatalf("invalid -%s: %v", name, err)
+	}
+	return policy
+}
+
+func mustParseTemplate(name string, text string) *report.Template {
+	if text == "" {
+		return nil
+	}
+
+	tmpl, err := report.ParseTemplate(name, text)
+	if err != nil {
+		log.Fatalf("invalid -%s template: %v", name, err)
+	}
+	return tmpl
+}
+
+// render executes tmpl against the report, returning fallback if tmpl is not set or
+// fails to render
+func render(tmpl *report.Template, r *report.Report, fallback string) string {
+	if tmpl == nil {
+		return fallback
+	}
+
+	text, err := tmpl.Render(r)
+	if err != nil {
+		log.Printf("can't render notification template: %v", err)
+		return fallback
+	}
+	return text
+}
+
+// accountID returns the AWS account ID of the host's credentials, or an empty string
+// if it can't be determined
+func accountID(region string) string {
+	identity, err := sts.CallerIdentity(region)
+	if err != nil {
+		log.Printf("can't determine AWS account: %v", err)
+		return ""
+	}
+	return identity.Account
+}
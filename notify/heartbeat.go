@@ -0,0 +1,39 @@
+package notify
+
+import (
+	"errors"
+	"strings"
+)
+
+// Heartbeat pings a dead man's switch monitoring service, such as healthchecks.io, so
+// that a run that never happens is noticed. Unlike other notifiers it should be sent
+// for every run regardless of policy: Start is called when a run begins and Notify
+// when it ends.
+//
+// Signals are sent as POST requests to URL + "/start", URL on success, and URL + "/fail"
+// if the run had any failures. The run summary is sent as the request body.
+type Heartbeat struct {
+	// The ping URL. This parameter is required.
+	URL string
+}
+
+// Start signals that a run has begun
+func (h *Heartbeat) Start() error {
+	return h.ping("/start", nil)
+}
+
+// Notify signals that a run succeeded or failed, sending the notification's message as the body
+func (h *Heartbeat) Notify(n *Notification) error {
+	signal := ""
+	if n.Report != nil && n.Report.Failed() {
+		signal = "/fail"
+	}
+	return h.ping(signal, []byte(n.Message))
+}
+
+func (h *Heartbeat) ping(signal string, body []byte) error {
+	if h.URL == "" {
+		return errors.New("heartbeat URL is required")
+	}
+	return postContent(strings.TrimSuffix(h.URL, "/")+signal, "text/plain", body, nil)
+}
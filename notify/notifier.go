@@ -0,0 +1,100 @@
+// Package notify sends the outcome of a snapshot run to one or more destinations,
+// such as an HTTP webhook or a Slack channel. Amazon SNS and SES notifiers are
+// provided by the sns and ses packages.
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/healthcareblocks/ebs_snapshotter/report"
+)
+
+// Timeout is the maximum time allowed for an HTTP based notifier to complete its request
+const Timeout = 30 * time.Second
+
+// Notification is the rendered content of an alert for a snapshot run
+type Notification struct {
+	// A short summary of the run
+	Subject string
+
+	// The full text of the alert
+	Message string
+
+	// How important the run's outcome is
+	Severity report.Severity
+
+	// Optional message bodies for specific protocols, keyed by protocol name
+	// (e.g. "email", "sms"). Notifiers fall back to Message when their protocol has no body.
+	Bodies map[string]string
+
+	// The run the notification describes
+	Report *report.Report
+}
+
+// Body returns the message body for the given protocol, or Message if none was set
+func (n *Notification) Body(protocol string) string {
+	if body := n.Bodies[protocol]; body != "" {
+		return body
+	}
+	return n.Message
+}
+
+// Notifier delivers a Notification to a destination
+type Notifier interface {
+	Notify(n *Notification) error
+}
+
+// Send delivers the notification with each notifier, logging any failures. It returns
+// the number of notifiers that failed.
+func Send(notifiers []Notifier, n *Notification) (failed int) {
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(n); err != nil {
+			log.Printf("can't send notification via %s: %v", name(notifier), err)
+			failed++
+		}
+	}
+	return failed
+}
+
+// name returns the type of a notifier for logging, unwrapping any Filter
+func name(notifier Notifier) string {
+	if filter, ok := notifier.(*Filter); ok {
+		return name(filter.Notifier)
+	}
+	return fmt.Sprintf("%T", notifier)
+}
+
+var client = &http.Client{Timeout: Timeout}
+
+// post sends body to url as JSON with any additional headers, returning an error if
+// the response status is not 2xx
+func post(url string, body []byte, headers map[string]string) error {
+	return postContent(url, "application/json", body, headers)
+}
+
+func postContent(url string, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/healthcareblocks/ebs_snapshotter/report"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookSignsPayload(t *testing.T) {
+	var body []byte
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer server.Close()
+
+	webhook := &Webhook{URL: server.URL, Secret: "s3cret"}
+	assert.NoError(t, webhook.Notify(testNotification()))
+
+	var payload WebhookPayload
+	assert.NoError(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, "subject", payload.Subject)
+	assert.Equal(t, 1, payload.SnapshotsCreated)
+	assert.Equal(t, 1, payload.Failures)
+	assert.Equal(t, report.Error, payload.Severity)
+	assert.Equal(t, "us-east-1", payload.Report.Regions[0].Name)
+	assert.Equal(t, "sha256="+Sign("s3cret", body), signature)
+}
+
+func TestSlackColorsFailedRuns(t *testing.T) {
+	var msg slackMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&msg)
+	}))
+	defer server.Close()
+
+	slack := &Slack{URL: server.URL}
+	assert.NoError(t, slack.Notify(testNotification()))
+	assert.Equal(t, "danger", msg.Attachments[0].Color)
+	assert.Equal(t, "message", msg.Attachments[0].Text)
+}
+
+func TestSendCountsFailedNotifiers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	notifiers := []Notifier{&Webhook{URL: server.URL}, &Slack{}}
+	assert.Equal(t, 2, Send(notifiers, testNotification()))
+}
+
+func testNotification() *Notification {
+	region := report.NewRegion("us-east-1")
+	region.Created = []string{"snap-1"}
+	region.AddFailure(report.Volume{ID: "vol-1"}, errors.New("throttled"))
+
+	r := report.New()
+	r.Add(region)
+	r.Finish()
+
+	return &Notification{Subject: "subject", Message: "message", Severity: r.Severity(), Report: r}
+}
+
+func TestPolicyMatchesSeverity(t *testing.T) {
+	succeeded := report.NewRegion("us-east-1")
+	succeeded.AddVolume(report.Volume{ID: "vol-1", SnapshotID: "snap-1"})
+
+	partial := report.NewRegion("us-east-1")
+	partial.AddVolume(report.Volume{ID: "vol-1", SnapshotID: "snap-1"})
+	partial.AddVolume(report.Volume{ID: "vol-2", Error: "throttled"})
+
+	failed := report.NewRegion("us-east-1")
+	failed.AddVolume(report.Volume{ID: "vol-2", Error: "throttled"})
+
+	pruned := report.NewRegion("us-east-1")
+	pruned.AddVolume(report.Volume{ID: "vol-1", SnapshotID: "snap-2", Deleted: []string{"snap-1"}})
+
+	cases := []struct {
+		region *report.Region
+		policy Policy
+		want   bool
+	}{
+		{succeeded, Always, true},
+		{succeeded, OnChange, false},
+		{succeeded, OnPartialFailure, false},
+		{pruned, OnChange, true},
+		{pruned, OnPartialFailure, false},
+		{partial, OnChange, false},
+		{partial, OnPartialFailure, true},
+		{partial, OnFailure, false},
+		{failed, OnPartialFailure, true},
+		{failed, OnFailure, true},
+	}
+
+	for _, c := range cases {
+		r := report.New()
+		r.Add(c.region)
+		assert.Equal(t, c.want, c.policy.Matches(r), "%s with severity %s", c.policy, r.Severity())
+	}
+}
+
+func TestParsePolicyRejectsUnknownNames(t *testing.T) {
+	policy, err := ParsePolicy("on-failure")
+	assert.NoError(t, err)
+	assert.Equal(t, OnFailure, policy)
+
+	_, err = ParsePolicy("sometimes")
+	assert.Error(t, err)
+}
+
+func TestHeartbeatSignalsStartAndFailure(t *testing.T) {
+	var paths []string
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		contents, _ := ioutil.ReadAll(r.Body)
+		body = string(contents)
+	}))
+	defer server.Close()
+
+	heartbeat := &Heartbeat{URL: server.URL + "/ping/abc/"}
+	assert.NoError(t, heartbeat.Start())
+	assert.NoError(t, heartbeat.Notify(testNotification()))
+	assert.NoError(t, heartbeat.Notify(&Notification{Message: "ok", Report: report.New()}))
+
+	assert.Equal(t, []string{"/ping/abc/start", "/ping/abc/fail", "/ping/abc"}, paths)
+	assert.Equal(t, "ok", body)
+}
@@ -0,0 +1,73 @@
+package notify
+
+import (
+	"fmt"
+
+	"github.com/healthcareblocks/ebs_snapshotter/report"
+)
+
+// Policy decides whether a run warrants a notification
+type Policy string
+
+const (
+	// Always notifies after every run
+	Always Policy = "always"
+
+	// OnChange notifies when snapshots were deleted or volume tags changed. Failures are
+	// covered by OnPartialFailure and OnFailure.
+	OnChange Policy = "on-change"
+
+	// OnPartialFailure notifies when any volume or region failed (Warning or Error severity)
+	OnPartialFailure Policy = "on-partial-failure"
+
+	// OnFailure notifies only when a region could not be processed or no volume
+	// succeeded (Error severity)
+	OnFailure Policy = "on-failure"
+)
+
+// ParsePolicy returns the Policy with the given name
+func ParsePolicy(name string) (Policy, error) {
+	switch policy := Policy(name); policy {
+	case Always, OnChange, OnPartialFailure, OnFailure:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown notification policy %q, must be one of: always, on-change, on-partial-failure, on-failure", name)
+}
+
+// Matches returns true if the report warrants a notification under the policy
+func (p Policy) Matches(r *report.Report) bool {
+	switch p {
+	case OnChange:
+		return r.Changed()
+	case OnPartialFailure:
+		return r.Severity() >= report.Warning
+	case OnFailure:
+		return r.Severity() >= report.Error
+	}
+	return true
+}
+
+// MinSeverity returns a Policy matching reports of the given severity or higher
+func MinSeverity(severity report.Severity) Policy {
+	switch severity {
+	case report.Warning:
+		return OnPartialFailure
+	case report.Error:
+		return OnFailure
+	}
+	return Always
+}
+
+// Filter wraps a Notifier so that it is only notified when its Policy matches
+type Filter struct {
+	Notifier Notifier
+	Policy   Policy
+}
+
+// Notify forwards the notification if the policy matches its report
+func (f *Filter) Notify(n *Notification) error {
+	if n.Report != nil && !f.Policy.Matches(n.Report) {
+		return nil
+	}
+	return f.Notifier.Notify(n)
+}
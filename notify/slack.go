@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/healthcareblocks/ebs_snapshotter/report"
+)
+
+// Slack posts a message to a Slack incoming webhook.
+// See https://api.slack.com/incoming-webhooks
+type Slack struct {
+	// The incoming webhook URL. This parameter is required.
+	URL string
+}
+
+type slackMessage struct {
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Fallback string `json:"fallback"`
+	Color    string `json:"color"`
+	Title    string `json:"title"`
+	Text     string `json:"text"`
+}
+
+// slackColors maps severities to Slack attachment colors
+var slackColors = map[report.Severity]string{
+	report.Info:    "good",
+	report.Warning: "warning",
+	report.Error:   "danger",
+}
+
+// Notify posts the notification as a Slack attachment, colored by severity
+func (s *Slack) Notify(n *Notification) error {
+	if s.URL == "" {
+		return errors.New("Slack webhook URL is required")
+	}
+
+	body, err := json.Marshal(&slackMessage{
+		Attachments: []slackAttachment{
+			{
+				Fallback: n.Subject,
+				Color:    slackColors[n.Severity],
+				Title:    n.Subject,
+				Text:     n.Body("slack"),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return post(s.URL, body, nil)
+}
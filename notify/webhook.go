@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/healthcareblocks/ebs_snapshotter/report"
+)
+
+// SignatureHeader is the HTTP header containing the HMAC-SHA256 signature of a
+// webhook payload, in the form "sha256=<hex digest>"
+const SignatureHeader = "X-Ebs-Snapshotter-Signature"
+
+// Webhook posts a JSON payload describing the run to an HTTP endpoint
+type Webhook struct {
+	// The endpoint URL. This parameter is required.
+	URL string
+
+	// An optional shared secret. If set, the payload is signed with HMAC-SHA256
+	// and the signature is sent in the SignatureHeader.
+	Secret string
+}
+
+// WebhookPayload is the JSON body posted by a Webhook
+type WebhookPayload struct {
+	Subject          string          `json:"subject"`
+	Message          string          `json:"message"`
+	Severity         report.Severity `json:"severity"`
+	SnapshotsCreated int             `json:"snapshots_created"`
+	SnapshotsDeleted int             `json:"snapshots_deleted"`
+	Failures         int             `json:"failures"`
+	Report           *report.Report  `json:"report"`
+}
+
+// Notify posts the notification to the webhook URL
+func (w *Webhook) Notify(n *Notification) error {
+	if w.URL == "" {
+		return errors.New("webhook URL is required")
+	}
+
+	payload := &WebhookPayload{
+		Subject:  n.Subject,
+		Message:  n.Message,
+		Severity: n.Severity,
+		Report:   n.Report,
+	}
+	if n.Report != nil {
+		payload.SnapshotsCreated = n.Report.SnapshotsCreated()
+		payload.SnapshotsDeleted = n.Report.SnapshotsDeleted()
+		payload.Failures = n.Report.Failures()
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{}
+	if w.Secret != "" {
+		headers[SignatureHeader] = "sha256=" + Sign(w.Secret, body)
+	}
+
+	return post(w.URL, body, headers)
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body using secret, so receivers
+// can verify a webhook payload
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
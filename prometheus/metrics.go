@@ -0,0 +1,232 @@
+// Package prometheus exposes snapshot run metrics in the Prometheus text exposition format,
+// either over HTTP or as a node_exporter textfile collector file.
+//
+// See https://prometheus.io/docs/instrumenting/exposition_formats/
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/healthcareblocks/ebs_snapshotter/report"
+)
+
+// ContentType is the HTTP content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4"
+
+const (
+	snapshotsCreated = "ebs_snapshotter_snapshots_created_total"
+	snapshotsFailed  = "ebs_snapshotter_snapshots_failed_total"
+	snapshotsPruned  = "ebs_snapshotter_snapshots_pruned_total"
+	lastSuccess      = "ebs_snapshotter_last_success_timestamp_seconds"
+	lastRun          = "ebs_snapshotter_last_run_timestamp_seconds"
+	runDuration      = "ebs_snapshotter_run_duration_seconds"
+	newestSnapshot   = "ebs_snapshotter_newest_snapshot_timestamp_seconds"
+	counterType      = "counter"
+	gaugeType        = "gauge"
+)
+
+// family describes a metric family in the exposition output
+type family struct {
+	name string
+	kind string
+	help string
+}
+
+// families are written in this order
+var families = []family{
+	{snapshotsCreated, counterType, "Snapshots created, by region and policy."},
+	{snapshotsFailed, counterType, "Volumes that failed to be snapshotted or pruned, plus failures affecting the whole region, by region and policy."},
+	{snapshotsPruned, counterType, "Snapshots deleted by the retention policy, by region and policy."},
+	{lastSuccess, gaugeType, "Unix time of the last run without failures, by policy."},
+	{lastRun, gaugeType, "Unix time of the last run, by policy."},
+	{runDuration, gaugeType, "Duration of the last run in seconds, by policy."},
+	{newestSnapshot, gaugeType, "Unix time of each volume's newest snapshot, by region, policy and volume."},
+}
+
+// Metrics accumulates the outcome of snapshot runs. Counters grow across runs. Snapshot
+// times are exported as timestamps rather than ages, so that alerts computing
+// time() - ebs_snapshotter_newest_snapshot_timestamp_seconds keep working when runs stop
+// and a textfile goes stale. It is safe to record and write Metrics from multiple goroutines.
+type Metrics struct {
+	mu sync.Mutex
+
+	// samples holds values keyed by metric name, then by rendered label set
+	samples map[string]map[string]float64
+}
+
+// New returns a new, empty Metrics pointer
+func New() *Metrics {
+	return &Metrics{
+		samples: map[string]map[string]float64{},
+	}
+}
+
+// Record adds the outcome of a run to the metrics
+func (m *Metrics) Record(r *report.Report) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, region := range r.Regions {
+		regionLabels := labels("policy", r.Policy, "region", region.Name)
+
+		for _, volume := range region.Volumes {
+			if !volume.NewestSnapshot.IsZero() {
+				m.set(newestSnapshot, labels("policy", r.Policy, "region", region.Name, "volume_id", volume.ID), unix(volume.NewestSnapshot))
+			}
+		}
+
+		m.add(snapshotsCreated, regionLabels, float64(len(region.Created)))
+		m.add(snapshotsFailed, regionLabels, float64(region.SnapshotsFailed()))
+		m.add(snapshotsPruned, regionLabels, float64(len(region.Deleted)))
+	}
+
+	finished := r.StartTime.Add(r.Duration)
+	policyLabels := labels("policy", r.Policy)
+	m.set(lastRun, policyLabels, unix(finished))
+	m.set(runDuration, policyLabels, r.Duration.Seconds())
+	if !r.Failed() {
+		m.set(lastSuccess, policyLabels, unix(finished))
+	}
+}
+
+// WriteTo writes the metrics in the text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	buf := bufio.NewWriter(w)
+	var written int64
+	for _, f := range families {
+		values := m.samples[f.name]
+		if len(values) == 0 {
+			continue
+		}
+
+		n, _ := fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+		written += int64(n)
+
+		labelSets := make([]string, 0, len(values))
+		for labelSet := range values {
+			labelSets = append(labelSets, labelSet)
+		}
+		sort.Strings(labelSets)
+
+		for _, labelSet := range labelSets {
+			n, _ := fmt.Fprintf(buf, "%s%s %s\n", f.name, labelSet, strconv.FormatFloat(values[labelSet], 'f', -1, 64))
+			written += int64(n)
+		}
+	}
+
+	return written, buf.Flush()
+}
+
+// ServeHTTP writes the metrics in response to a Prometheus scrape
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	m.WriteTo(w)
+}
+
+// WriteTextfile atomically writes the metrics to path for the node_exporter textfile
+// collector. The file is written to a temporary file in the same directory and renamed,
+// so node_exporter never reads a partial file.
+func (m *Metrics) WriteTextfile(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := m.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadTextfile restores counters, the last success timestamps and the newest snapshot
+// timestamps from a file previously
+// written by WriteTextfile, so they carry over between one-shot runs. A missing file is
+// not an error.
+func (m *Metrics) LoadTextfile(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sep := strings.LastIndex(line, " ")
+		if sep < 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[sep+1:], 64)
+		if err != nil {
+			continue
+		}
+
+		name, labelSet := line[:sep], ""
+		if brace := strings.Index(name, "{"); brace >= 0 {
+			name, labelSet = name[:brace], name[brace:]
+		}
+
+		switch name {
+		case snapshotsCreated, snapshotsFailed, snapshotsPruned, lastSuccess, newestSnapshot:
+			m.set(name, labelSet, value)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (m *Metrics) add(name string, labelSet string, value float64) {
+	m.set(name, labelSet, m.samples[name][labelSet]+value)
+}
+
+func (m *Metrics) set(name string, labelSet string, value float64) {
+	if m.samples[name] == nil {
+		m.samples[name] = map[string]float64{}
+	}
+	m.samples[name][labelSet] = value
+}
+
+// labels renders name/value pairs as a Prometheus label set, e.g. {policy="daily"}
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%s", pairs[i], strconv.Quote(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func unix(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
@@ -0,0 +1,99 @@
+package prometheus
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/healthcareblocks/ebs_snapshotter/report"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteToRendersExpositionFormat(t *testing.T) {
+	m := New()
+	m.Record(testReport(false))
+
+	var buf bytes.Buffer
+	_, err := m.WriteTo(&buf)
+	assert.NoError(t, err)
+
+	out := buf.String()
+	assert.Contains(t, out, "# TYPE ebs_snapshotter_snapshots_created_total counter\n")
+	assert.Contains(t, out, `ebs_snapshotter_snapshots_created_total{policy="daily",region="us-east-1"} 1`)
+	assert.Contains(t, out, `ebs_snapshotter_snapshots_pruned_total{policy="daily",region="us-east-1"} 2`)
+	assert.Contains(t, out, `ebs_snapshotter_last_success_timestamp_seconds{policy="daily"}`)
+	assert.Contains(t, out, `ebs_snapshotter_newest_snapshot_timestamp_seconds{policy="daily",region="us-east-1",volume_id="vol-1"} 1483228800`)
+}
+
+func TestTextfileCarriesCountersAcrossRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prometheus")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ebs_snapshotter.prom")
+
+	first := New()
+	first.Record(testReport(false))
+	assert.NoError(t, first.WriteTextfile(path))
+
+	second := New()
+	assert.NoError(t, second.LoadTextfile(path))
+	second.Record(testReport(true))
+	assert.NoError(t, second.WriteTextfile(path))
+
+	contents, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(contents), `ebs_snapshotter_snapshots_created_total{policy="daily",region="us-east-1"} 2`)
+	assert.Contains(t, string(contents), `ebs_snapshotter_snapshots_failed_total{policy="daily",region="us-east-1"} 1`)
+	assert.Contains(t, string(contents), `ebs_snapshotter_last_success_timestamp_seconds{policy="daily"}`)
+	assert.Contains(t, string(contents), `ebs_snapshotter_newest_snapshot_timestamp_seconds{policy="daily",region="us-east-1",volume_id="vol-1"} 1483228800`)
+}
+
+func TestTextfileKeepsNewestSnapshotOfSkippedVolumes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prometheus")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ebs_snapshotter.prom")
+
+	first := New()
+	first.Record(testReport(false))
+	assert.NoError(t, first.WriteTextfile(path))
+
+	region := report.NewRegion("us-east-1")
+	region.AddFailure(report.Volume{}, errors.New("AuthFailure"))
+	r := report.New()
+	r.Policy = "daily"
+	r.Add(region)
+
+	second := New()
+	assert.NoError(t, second.LoadTextfile(path))
+	second.Record(r)
+
+	var buf bytes.Buffer
+	_, err = second.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `ebs_snapshotter_newest_snapshot_timestamp_seconds{policy="daily",region="us-east-1",volume_id="vol-1"} 1483228800`)
+	assert.Contains(t, buf.String(), `ebs_snapshotter_snapshots_failed_total{policy="daily",region="us-east-1"} 1`)
+}
+
+func testReport(failed bool) *report.Report {
+	region := report.NewRegion("us-east-1")
+	region.AddVolume(report.Volume{
+		ID:             "vol-1",
+		SnapshotID:     "snap-3",
+		Deleted:        []string{"snap-1", "snap-2"},
+		NewestSnapshot: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	if failed {
+		region.AddVolume(report.Volume{ID: "vol-2", Error: "throttled"})
+	}
+
+	r := report.New()
+	r.Policy = "daily"
+	r.Add(region)
+	r.Finish()
+	return r
+}
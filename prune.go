@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+	"github.com/healthcareblocks/ebs_snapshotter/ebs"
+)
+
+// pruneCommand deletes the snapshots in each region whose expiry tag has passed, whichever
+// volume they belong to
+func pruneCommand(args []string) {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	regions := flags.String("regions", "", "AWS EC2 regions (comma delimited) to prune, or \"all\" for every enabled region.\n\tIf not set this value is determined using the host machine's EC2 metadata.")
+	excludeRegions := flags.String("exclude_regions", "", "AWS EC2 regions (comma delimited) to skip, e.g. with -regions=all")
+	dryRun := flags.Bool("dry_run", false, "Print the expired snapshots without deleting them")
+	debug := flags.Bool("d", false, "Turns on AWS request profiling")
+	auditLog := newAuditLogFlags(flags)
+	flags.Parse(args)
+
+	regionNames := regionList(*regions, *excludeRegions)
+	logger := auditLog.open(regionNames[0])
+	defer closeAuditLog(logger)
+
+	now := time.Now()
+	for _, region := range regionNames {
+		mgr, err := ebs.NewSnapshotManager(region, "", false, 1, *debug)
+		awserror.HandleError(err)
+		if logger != nil {
+			mgr.SetAuditLog(logger)
+		}
+		expired, err := mgr.PruneExpired(now, *dryRun)
+		for _, snapshotID := range expired {
+			if *dryRun {
+				fmt.Printf("%s %s expired, would delete\n", region, snapshotID)
+			} else {
+				fmt.Printf("%s %s deleted\n", region, snapshotID)
+			}
+		}
+		if err != nil {
+			closeAuditLog(logger)
+			awserror.HandleError(err)
+		}
+	}
+}
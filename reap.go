@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+	"github.com/healthcareblocks/ebs_snapshotter/ebs"
+)
+
+// reapCommand takes a final snapshot of volumes that have been unattached for too long,
+// optionally deleting them, and prints what was done
+func reapCommand(args []string) {
+	flags := flag.NewFlagSet("reap", flag.ExitOnError)
+	regions := flags.String("regions", "", "AWS EC2 regions (comma delimited) to reap, or \"all\" for every enabled region.\n\tIf not set this value is determined using the host machine's EC2 metadata.")
+	excludeRegions := flags.String("exclude_regions", "", "AWS EC2 regions (comma delimited) to skip, e.g. with -regions=all")
+	unattachedDays := flags.Int("unattached_days", 30, "Reap volumes that have been unattached for longer than this many days")
+	deleteVolumes := flags.Bool("delete", false, "Delete each volume once its final snapshot completes")
+	dryRun := flags.Bool("dry_run", false, "List the volumes that would be reaped without snapshotting or deleting them")
+	requireEncryption := flags.Bool("require_encryption", false, "Replace the final snapshots of unencrypted volumes with encrypted copies, only\n\tdeleting a volume once its copy completes")
+	kmsKeyID := flags.String("kms_key_id", "", "KMS key ID or ARN used to encrypt copies with -require_encryption. If not set, the\n\taccount's default EBS key is used.")
+	debug := flags.Bool("d", false, "Turns on AWS request profiling")
+	auditLog := newAuditLogFlags(flags)
+	flags.Parse(args)
+
+	if *unattachedDays < 1 {
+		log.Fatal("-unattached_days should be greater than 0")
+	}
+
+	opts := ebs.ReapOptions{
+		UnattachedFor: time.Duration(*unattachedDays) * 24 * time.Hour,
+		Delete:        *deleteVolumes,
+		DryRun:        *dryRun,
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REGION\tVOLUME\tNAME\tUNATTACHED SINCE\tFINAL SNAPSHOT\tSTATUS")
+
+	regionNames := regionList(*regions, *excludeRegions)
+	logger := auditLog.open(regionNames[0])
+
+	failed := false
+	for _, region := range regionNames {
+		mgr, err := ebs.NewSnapshotManager(region, "", true, 1, *debug)
+		awserror.HandleError(err)
+		mgr.RequireEncryption = *requireEncryption
+		mgr.KmsKeyID = *kmsKeyID
+		if logger != nil {
+			mgr.SetAuditLog(logger)
+		}
+		reaped, err := mgr.ReapUnattached(opts)
+		if err != nil {
+			closeAuditLog(logger)
+			awserror.HandleError(err)
+		}
+
+		for _, volume := range reaped {
+			status := "snapshotted"
+			switch {
+			case *dryRun && *deleteVolumes:
+				status = "would snapshot and delete"
+			case *dryRun:
+				status = "would snapshot"
+			case volume.Error != "":
+				status = "failed: " + volume.Error
+				failed = true
+			case volume.Deleted:
+				status = "snapshotted and deleted"
+			case volume.Unencrypted:
+				status = "snapshotted, not yet encrypted"
+			}
+
+			snapshotID := volume.SnapshotID
+			if snapshotID == "" {
+				snapshotID = "-"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				region, volume.VolumeID, volume.Name, volume.UnattachedSince.Format(time.RFC3339), snapshotID, status)
+		}
+	}
+	w.Flush()
+	closeAuditLog(logger)
+
+	if failed {
+		os.Exit(1)
+	}
+}
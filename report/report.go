@@ -0,0 +1,479 @@
+// Package report summarizes the outcome of a snapshot run across one or more regions
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Volume holds the outcome of snapshotting a single EBS volume during the run
+type Volume struct {
+	// The EBS volume ID
+	ID string `json:"id"`
+
+	// The value of the volume's Name tag, if any
+	Name string `json:"name,omitempty"`
+
+	// The ID of the snapshot created for the volume, if any
+	SnapshotID string `json:"snapshot_id,omitempty"`
+
+	// IDs of the volume's older snapshots removed by the retention policy
+	Deleted []string `json:"deleted,omitempty"`
+
+	// Start time of the volume's oldest snapshot remaining after retention was applied
+	OldestSnapshot time.Time `json:"oldest_snapshot"`
+
+	// Start time of the volume's newest completed snapshot remaining after retention was
+	// applied. The snapshot created during the run is only included if it has completed.
+	NewestSnapshot time.Time `json:"newest_snapshot"`
+
+	// IDs of the volume's remaining snapshots that are not encrypted, reported when
+	// encryption is required
+	Unencrypted []string `json:"unencrypted,omitempty"`
+
+	// Whether the tags copied to the new snapshot differ from those on the volume's
+	// previous snapshot
+	TagsChanged bool `json:"tags_changed,omitempty"`
+
+	// A description of the first error encountered for the volume, if any
+	Error string `json:"error,omitempty"`
+}
+
+// Failed returns true if an error was encountered while processing the volume
+func (v Volume) Failed() bool {
+	return v.Error != ""
+}
+
+// String returns the volume ID, followed by its name if it has one
+func (v Volume) String() string {
+	if v.Name == "" {
+		return v.ID
+	}
+	return fmt.Sprintf("%s (%s)", v.ID, v.Name)
+}
+
+// Failure describes an operation that could not be completed for a volume
+type Failure struct {
+	// The EBS volume being processed when the failure occurred. Empty if the
+	// failure was not specific to a volume.
+	Volume Volume `json:"volume"`
+
+	// A description of the underlying error
+	Error string `json:"error"`
+}
+
+// Verification holds the outcome of a restore verification drill for a single volume,
+// in which its newest completed snapshot is restored to a temporary volume
+type Verification struct {
+	// The EBS volume whose snapshot was verified
+	Volume Volume `json:"volume"`
+
+	// The ID and start time of the snapshot that was restored
+	SnapshotID   string    `json:"snapshot_id,omitempty"`
+	SnapshotTime time.Time `json:"snapshot_time"`
+
+	// The ID of the temporary volume created from the snapshot
+	RestoredVolumeID string `json:"restored_volume_id,omitempty"`
+
+	// Whether the check command was run against the restored volume and succeeded
+	Checked bool `json:"checked"`
+
+	// How long the drill took, from creating the temporary volume to deleting it
+	Duration time.Duration `json:"duration"`
+
+	// A description of the first error encountered during the drill, if any
+	Error string `json:"error,omitempty"`
+}
+
+// Failed returns true if the snapshot could not be restored or failed its check
+func (v Verification) Failed() bool {
+	return v.Error != ""
+}
+
+// Region holds the outcome of snapshotting the volumes of a single EC2 region
+type Region struct {
+	// The EC2 region name, e.g. us-east-1
+	Name string `json:"name"`
+
+	// Outcome of each volume selected for snapshotting
+	Volumes []Volume `json:"volumes"`
+
+	// IDs of the snapshots created during the run
+	Created []string `json:"created"`
+
+	// IDs of the older snapshots removed by the retention policy
+	Deleted []string `json:"deleted"`
+
+	// Outcome of each restore verification drill
+	Verifications []Verification `json:"verifications,omitempty"`
+
+	// Operations that failed during the run
+	Failures []Failure `json:"failures"`
+
+	// How long the region took to process
+	Duration time.Duration `json:"duration"`
+}
+
+// NewRegion returns a new Region pointer for the given region name
+func NewRegion(name string) *Region {
+	return &Region{Name: name}
+}
+
+// AddVolume records the outcome of a volume, adding its snapshots to the region totals
+// and its error, if any, to the region's failures
+func (region *Region) AddVolume(volume Volume) {
+	region.Volumes = append(region.Volumes, volume)
+
+	if volume.SnapshotID != "" {
+		region.Created = append(region.Created, volume.SnapshotID)
+	}
+	region.Deleted = append(region.Deleted, volume.Deleted...)
+
+	if volume.Failed() {
+		region.Failures = append(region.Failures, Failure{
+			Volume: Volume{ID: volume.ID, Name: volume.Name},
+			Error:  volume.Error,
+		})
+	}
+}
+
+// AddVerification records the outcome of a restore verification drill, adding its
+// error, if any, to the region's failures
+func (region *Region) AddVerification(verification Verification) {
+	region.Verifications = append(region.Verifications, verification)
+
+	if verification.Failed() {
+		region.Failures = append(region.Failures, Failure{
+			Volume: verification.Volume,
+			Error:  "restore verification failed: " + verification.Error,
+		})
+	}
+}
+
+// AddFailure records an error that occurred while processing a volume
+func (region *Region) AddFailure(volume Volume, err error) {
+	region.Failures = append(region.Failures, Failure{Volume: volume, Error: err.Error()})
+}
+
+// Failed returns true if any operation in the region failed
+func (region *Region) Failed() bool {
+	return len(region.Failures) > 0
+}
+
+// SnapshotsFailed returns the number of volumes that failed, plus one for each failure that
+// wasn't specific to a volume, such as the region's volumes not being described
+func (region *Region) SnapshotsFailed() (count int) {
+	for _, volume := range region.Volumes {
+		if volume.Failed() {
+			count++
+		}
+	}
+	for _, failure := range region.Failures {
+		if failure.Volume.ID == "" {
+			count++
+		}
+	}
+	return count
+}
+
+// Unencrypted returns the IDs of the unencrypted snapshots reported for the region's volumes
+func (region *Region) Unencrypted() (ids []string) {
+	for _, volume := range region.Volumes {
+		ids = append(ids, volume.Unencrypted...)
+	}
+	return ids
+}
+
+// Severity returns Error if the region could not be processed or no volume succeeded,
+// Warning if only some volumes failed or unencrypted snapshots were reported, and Info
+// otherwise
+func (region *Region) Severity() Severity {
+	if !region.Failed() {
+		if len(region.Unencrypted()) > 0 {
+			return Warning
+		}
+		return Info
+	}
+
+	for _, failure := range region.Failures {
+		if failure.Volume.ID == "" {
+			return Error
+		}
+	}
+
+	for _, volume := range region.Volumes {
+		if !volume.Failed() {
+			return Warning
+		}
+	}
+
+	for _, verification := range region.Verifications {
+		if !verification.Failed() {
+			return Warning
+		}
+	}
+	return Error
+}
+
+// Changed returns true if snapshots were deleted or a volume's tags changed since its
+// previous snapshot
+func (region *Region) Changed() bool {
+	if len(region.Deleted) > 0 {
+		return true
+	}
+
+	for _, volume := range region.Volumes {
+		if volume.TagsChanged {
+			return true
+		}
+	}
+	return false
+}
+
+// Report holds the outcome of a snapshot run across all regions. It is safe to
+// add regions to a Report from multiple goroutines.
+type Report struct {
+	// The AWS account ID the run was performed in, if known
+	Account string `json:"account,omitempty"`
+
+	// The name of the backup policy the run applied
+	Policy string `json:"policy,omitempty"`
+
+	// When the run started
+	StartTime time.Time `json:"start_time"`
+
+	// Total duration of the run, set by Finish
+	Duration time.Duration `json:"duration"`
+
+	// Outcome of each region in the run, sorted by region name once Finish is called
+	Regions []*Region `json:"regions"`
+
+	mu sync.Mutex
+}
+
+// New returns a new Report pointer with its StartTime set to the current time
+func New() *Report {
+	return &Report{StartTime: time.Now()}
+}
+
+// Add appends the outcome of a region to the report
+func (r *Report) Add(region *Region) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Regions = append(r.Regions, region)
+}
+
+// Finish records the total duration of the run and orders the regions by name
+func (r *Report) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Duration = time.Since(r.StartTime)
+	sort.Slice(r.Regions, func(i, j int) bool { return r.Regions[i].Name < r.Regions[j].Name })
+}
+
+// ForRegion returns a Report containing only the given region, for notifications sent
+// as each region completes
+func (r *Report) ForRegion(region *Region) *Report {
+	return &Report{
+		Account:   r.Account,
+		Policy:    r.Policy,
+		StartTime: r.StartTime,
+		Duration:  region.Duration,
+		Regions:   []*Region{region},
+	}
+}
+
+// SnapshotsCreated returns the number of snapshots created across all regions
+func (r *Report) SnapshotsCreated() (count int) {
+	for _, region := range r.Regions {
+		count += len(region.Created)
+	}
+	return count
+}
+
+// SnapshotsDeleted returns the number of snapshots deleted across all regions
+func (r *Report) SnapshotsDeleted() (count int) {
+	for _, region := range r.Regions {
+		count += len(region.Deleted)
+	}
+	return count
+}
+
+// Verifications returns the number of restore verification drills across all regions
+func (r *Report) Verifications() (count int) {
+	for _, region := range r.Regions {
+		count += len(region.Verifications)
+	}
+	return count
+}
+
+// VerificationsFailed returns the number of failed restore verification drills across
+// all regions
+func (r *Report) VerificationsFailed() (count int) {
+	for _, region := range r.Regions {
+		for _, verification := range region.Verifications {
+			if verification.Failed() {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Failures returns the number of failed operations across all regions
+func (r *Report) Failures() (count int) {
+	for _, region := range r.Regions {
+		count += len(region.Failures)
+	}
+	return count
+}
+
+// Failed returns true if any operation in any region failed
+func (r *Report) Failed() bool {
+	return r.Failures() > 0
+}
+
+// Severity returns the highest severity of any region in the report
+func (r *Report) Severity() (severity Severity) {
+	for _, region := range r.Regions {
+		if region.Severity() > severity {
+			severity = region.Severity()
+		}
+	}
+	return severity
+}
+
+// Changed returns true if any region deleted snapshots or saw volume tag changes
+func (r *Report) Changed() bool {
+	for _, region := range r.Regions {
+		if region.Changed() {
+			return true
+		}
+	}
+	return false
+}
+
+// RegionNames returns the names of all regions in the report
+func (r *Report) RegionNames() []string {
+	names := make([]string, len(r.Regions))
+	for i, region := range r.Regions {
+		names[i] = region.Name
+	}
+	return names
+}
+
+// MaxSubjectLength is the longest subject SNS accepts. Subject lists the regions of the
+// run only if they fit.
+const MaxSubjectLength = 100
+
+// Subject returns a default notification subject summarizing the run
+func (r *Report) Subject() string {
+	subject := r.subject(strings.Join(r.RegionNames(), ", "))
+	if len(subject) > MaxSubjectLength {
+		subject = r.subject(fmt.Sprintf("%d regions", len(r.Regions)))
+	}
+	return subject
+}
+
+func (r *Report) subject(regions string) string {
+	if r.verifyOnly() {
+		if r.Failed() {
+			return fmt.Sprintf("EBS Restore Verification Failed (%s)", regions)
+		}
+		return fmt.Sprintf("EBS Restore Verification Passed (%s)", regions)
+	}
+	if r.Failed() {
+		return fmt.Sprintf("EBS Snapshots Completed With Errors (%s)", regions)
+	}
+	return fmt.Sprintf("EBS Snapshots Completed (%s)", regions)
+}
+
+// Message returns a default notification message summarizing the run, with
+// counts, deleted snapshots and failures for each region
+func (r *Report) Message() string {
+	if r.verifyOnly() {
+		return r.verificationMessage()
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d snapshots completed, %d deleted, %d failures in %s (started %s)\n",
+		r.SnapshotsCreated(), r.SnapshotsDeleted(), r.Failures(),
+		r.Duration.Round(time.Second), r.StartTime.Format(time.RFC822))
+
+	for _, region := range r.Regions {
+		fmt.Fprintf(&buf, "\n%s: %d created, %d deleted, %d failures in %s",
+			region.Name, len(region.Created), len(region.Deleted), len(region.Failures),
+			region.Duration.Round(time.Second))
+
+		if len(region.Deleted) > 0 {
+			fmt.Fprintf(&buf, "\n  deleted: %s", strings.Join(region.Deleted, ", "))
+		}
+
+		if unencrypted := region.Unencrypted(); len(unencrypted) > 0 {
+			fmt.Fprintf(&buf, "\n  unencrypted: %s", strings.Join(unencrypted, ", "))
+		}
+
+		for _, failure := range region.Failures {
+			if failure.Volume.ID == "" {
+				fmt.Fprintf(&buf, "\n  failed: %s", failure.Error)
+			} else {
+				fmt.Fprintf(&buf, "\n  failed: %s: %s", failure.Volume, failure.Error)
+			}
+		}
+	}
+
+	return buf.String()
+}
+
+// verifyOnly returns true if the report holds restore verification drills rather than
+// snapshots
+func (r *Report) verifyOnly() bool {
+	if r.Verifications() == 0 {
+		return false
+	}
+
+	for _, region := range r.Regions {
+		if len(region.Volumes) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// verificationMessage summarizes the restore verification drills for each region
+func (r *Report) verificationMessage() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d of %d snapshots restored successfully in %s (started %s)\n",
+		r.Verifications()-r.VerificationsFailed(), r.Verifications(),
+		r.Duration.Round(time.Second), r.StartTime.Format(time.RFC822))
+
+	for _, region := range r.Regions {
+		fmt.Fprintf(&buf, "\n%s:", region.Name)
+
+		for _, verification := range region.Verifications {
+			status := "restored"
+			if verification.Checked {
+				status = "restored and checked"
+			}
+			if verification.Failed() {
+				status = "failed: " + verification.Error
+			}
+
+			fmt.Fprintf(&buf, "\n  %s: %s from %s (%s) %s",
+				verification.Volume, verification.SnapshotID,
+				verification.SnapshotTime.Format(time.RFC822), verification.Duration.Round(time.Second), status)
+		}
+
+		for _, failure := range region.Failures {
+			if failure.Volume.ID == "" {
+				fmt.Fprintf(&buf, "\n  failed: %s", failure.Error)
+			}
+		}
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,110 @@
+package report
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportTotalsAcrossRegions(t *testing.T) {
+	r := New()
+
+	east := NewRegion("us-east-1")
+	east.Created = []string{"snap-1", "snap-2"}
+	east.Deleted = []string{"snap-0"}
+	r.Add(east)
+
+	west := NewRegion("us-west-2")
+	west.Created = []string{"snap-3"}
+	west.AddFailure(Volume{ID: "vol-1a2b3c4d", Name: "Data Volume"}, errors.New("throttled"))
+	r.Add(west)
+
+	r.Finish()
+
+	assert.Equal(t, 3, r.SnapshotsCreated())
+	assert.Equal(t, 1, r.SnapshotsDeleted())
+	assert.Equal(t, 1, r.Failures())
+	assert.True(t, r.Failed())
+	assert.Equal(t, "EBS Snapshots Completed With Errors (us-east-1, us-west-2)", r.Subject())
+	assert.Contains(t, r.Message(), "us-west-2: 1 created, 0 deleted, 1 failures")
+	assert.Contains(t, r.Message(), "failed: vol-1a2b3c4d (Data Volume): throttled")
+	assert.Contains(t, r.Message(), "deleted: snap-0")
+}
+
+func TestFinishOrdersRegionsByName(t *testing.T) {
+	r := New()
+	r.Add(NewRegion("us-west-2"))
+	r.Add(NewRegion("eu-west-1"))
+	r.Finish()
+
+	assert.Equal(t, []string{"eu-west-1", "us-west-2"}, r.RegionNames())
+	assert.Equal(t, "EBS Snapshots Completed (eu-west-1, us-west-2)", r.Subject())
+}
+
+func TestUnencryptedSnapshotsRaiseSeverity(t *testing.T) {
+	r := New()
+
+	region := NewRegion("us-east-1")
+	region.AddVolume(Volume{ID: "vol-1", SnapshotID: "snap-2", Unencrypted: []string{"snap-1"}})
+	r.Add(region)
+	r.Finish()
+
+	assert.False(t, r.Failed())
+	assert.Equal(t, Warning, r.Severity())
+	assert.Contains(t, r.Message(), "unencrypted: snap-1")
+}
+
+func TestVerificationReport(t *testing.T) {
+	r := New()
+
+	region := NewRegion("us-east-1")
+	region.AddVerification(Verification{Volume: Volume{ID: "vol-1"}, SnapshotID: "snap-1", Checked: true})
+	region.AddVerification(Verification{Volume: Volume{ID: "vol-2"}, SnapshotID: "snap-2", Error: "check failed"})
+	r.Add(region)
+	r.Finish()
+
+	assert.Equal(t, 2, r.Verifications())
+	assert.Equal(t, 1, r.VerificationsFailed())
+	assert.Equal(t, Warning, r.Severity())
+	assert.Equal(t, "EBS Restore Verification Failed (us-east-1)", r.Subject())
+	assert.Contains(t, r.Message(), "1 of 2 snapshots restored successfully")
+	assert.Contains(t, r.Message(), "vol-1: snap-1")
+	assert.Contains(t, r.Message(), "restored and checked")
+	assert.Contains(t, r.Message(), "failed: check failed")
+}
+
+func TestTemplateRendersReport(t *testing.T) {
+	r := New()
+	r.Account = "123456789012"
+
+	region := NewRegion("us-east-1")
+	region.Volumes = []Volume{{ID: "vol-1", Name: "db"}, {ID: "vol-2"}}
+	region.Created = []string{"snap-1"}
+	region.AddFailure(region.Volumes[1], errors.New("throttled"))
+	r.Add(region)
+	r.Finish()
+
+	tmpl, err := ParseTemplate("test", `{{.Account}}: {{join .RegionNames ","}} {{.SnapshotsCreated}}/{{len (index .Regions 0).Volumes}}{{range .Regions}}{{range .Failures}} {{.Volume}} {{.Error}}{{end}}{{end}}`)
+	assert.NoError(t, err)
+
+	text, err := tmpl.Render(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "123456789012: us-east-1 1/2 vol-2 throttled", text)
+}
+
+func TestParseTemplateRejectsInvalidSyntax(t *testing.T) {
+	_, err := ParseTemplate("test", "{{.Account")
+	assert.Error(t, err)
+}
+
+func TestSubjectSummarizesManyRegions(t *testing.T) {
+	r := New()
+	for _, name := range []string{"ap-northeast-1", "ap-southeast-2", "eu-central-1", "eu-west-1", "sa-east-1", "us-east-1", "us-west-2"} {
+		r.Add(NewRegion(name))
+	}
+	r.Finish()
+
+	assert.Equal(t, "EBS Snapshots Completed (7 regions)", r.Subject())
+	assert.True(t, len(r.Subject()) <= MaxSubjectLength)
+}
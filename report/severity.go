@@ -0,0 +1,43 @@
+package report
+
+import "fmt"
+
+// Severity classifies the outcome of a run so that notifications can be routed by importance
+type Severity int
+
+const (
+	// Info indicates every operation succeeded
+	Info Severity = iota
+
+	// Warning indicates some volumes failed while others succeeded
+	Warning
+
+	// Error indicates a region could not be processed or no volume succeeded
+	Error
+)
+
+var severityNames = []string{"info", "warning", "error"}
+
+// String returns the lower case name of the severity
+func (s Severity) String() string {
+	if s < Info || s > Error {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severityNames[s]
+}
+
+// MarshalText encodes the severity by name, e.g. in JSON payloads
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a severity from its name
+func (s *Severity) UnmarshalText(text []byte) error {
+	for i, name := range severityNames {
+		if string(text) == name {
+			*s = Severity(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown severity %q", text)
+}
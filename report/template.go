@@ -0,0 +1,48 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateFuncs are available to notification templates in addition to the
+// text/template builtins
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"round": func(d time.Duration) time.Duration {
+		return d.Round(time.Second)
+	},
+	"time": func(t time.Time) string {
+		return t.Format(time.RFC822)
+	},
+}
+
+// Template renders notification text from a Report
+type Template struct {
+	tmpl *template.Template
+}
+
+// ParseTemplate parses text as a Go text/template that is rendered against a Report.
+// Besides the Report fields and methods, templates can use the join, round and time functions:
+//
+//	{{.SnapshotsCreated}} snapshots in {{round .Duration}} for account {{.Account}}
+//	{{range .Regions}}{{range .Failures}}{{.Volume}}: {{.Error}}
+//	{{end}}{{end}}
+func ParseTemplate(name string, text string) (*Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{tmpl: tmpl}, nil
+}
+
+// Render executes the template against the report
+func (t *Template) Render(r *Report) (string, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, r); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
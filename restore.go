@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+	"github.com/healthcareblocks/ebs_snapshotter/ebs"
+)
+
+// restoreCommand creates a new volume from a snapshot, selected by ID or by volume
+// and point in time, optionally attaching it to an instance
+func restoreCommand(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	region := flags.String("region", "", "AWS EC2 region of the snapshot. If not set this value is determined using\n\tthe host machine's EC2 metadata.")
+	snapshotID := flags.String("snapshot", "", "Snapshot ID to restore. Either -snapshot or -volume is required.")
+	volumeID := flags.String("volume", "", "Original volume ID. Its newest completed snapshot at or before -at is restored.")
+	at := flags.String("at", "", "Point in time to restore -volume to (RFC3339, e.g. 2017-03-01T09:00:00Z). Defaults to now.")
+	az := flags.String("az", "", "Availability zone to create the volume in. This value is required.")
+	volumeType := flags.String("type", "", "Volume type. Defaults to the type of the original volume.")
+	iops := flags.Int64("iops", 0, "Provisioned IOPS for io1, io2 and gp3 volumes. Defaults to the IOPS of the original volume.")
+	throughput := flags.Int64("throughput", 0, "Throughput in MiB/s for gp3 volumes. Defaults to the throughput of the original volume.")
+	instanceID := flags.String("instance", "", "Optional instance ID to attach the volume to")
+	device := flags.String("device", "", "Device name to attach the volume at, e.g. /dev/sdf. Required with -instance.")
+	wait := flags.Bool("wait", false, "Wait until the volume is available, or attached if -instance is set")
+	debug := flags.Bool("d", false, "Turns on AWS request profiling")
+	auditLog := newAuditLogFlags(flags)
+	flags.Parse(args)
+
+	if *snapshotID == "" && *volumeID == "" {
+		log.Fatal("-snapshot or -volume is required")
+	}
+
+	if *az == "" {
+		log.Fatal("-az is required")
+	}
+
+	if *instanceID != "" && *device == "" {
+		log.Fatal("-device is required with -instance")
+	}
+
+	opts := ebs.RestoreOptions{
+		SnapshotID:       *snapshotID,
+		VolumeID:         *volumeID,
+		AvailabilityZone: *az,
+		VolumeType:       *volumeType,
+		Iops:             *iops,
+		Throughput:       *throughput,
+		InstanceID:       *instanceID,
+		Device:           *device,
+		Wait:             *wait,
+	}
+
+	if *at != "" {
+		pointInTime, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			log.Fatalf("invalid -at: %v", err)
+		}
+		opts.PointInTime = pointInTime
+	}
+
+	if *region == allRegions || strings.Contains(*region, ",") {
+		log.Fatal("-region must be a single region")
+	}
+
+	restoreRegion := regionList(*region, "")[0]
+	mgr, err := ebs.NewSnapshotManager(restoreRegion, "", false, 1, *debug)
+	awserror.HandleError(err)
+	logger := auditLog.open(restoreRegion)
+	if logger != nil {
+		mgr.SetAuditLog(logger)
+	}
+
+	volume, err := mgr.RestoreVolume(opts)
+	closeAuditLog(logger)
+	awserror.HandleError(err)
+
+	fmt.Printf("Restored %s to volume %s in %s\n",
+		aws.StringValue(volume.SnapshotId), aws.StringValue(volume.VolumeId), aws.StringValue(volume.AvailabilityZone))
+}
@@ -0,0 +1,70 @@
+// Package ses implements functionality for sending email via Amazon SES
+//
+// Note: this package relies on the AWS SDK, thus the host environment should
+// either have an associated IAM role or user with the SES:SendEmail permission,
+// and the sender address must be verified in SES.
+package ses
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/healthcareblocks/ebs_snapshotter/notify"
+)
+
+// Notifier emails notifications via SES. The notification's "email" body is used
+// if set, otherwise its Message.
+type Notifier struct {
+	// The AWS region SES is called in. This parameter is required.
+	Region string
+
+	// The verified sender address. This parameter is required.
+	From string
+
+	// The recipient addresses. At least one is required.
+	To []string
+}
+
+// Notify sends the notification as a plain text email
+func (n *Notifier) Notify(notification *notify.Notification) error {
+	return SendEmail(n.Region, n.From, n.To, notification.Subject, notification.Body("email"))
+}
+
+// SendEmail sends a plain text email from a verified SES sender.
+// See http://docs.aws.amazon.com/sdk-for-go/api/service/ses.html#type-SendEmailInput
+func SendEmail(region string, from string, to []string, subject string, body string) error {
+	if region == "" {
+		return errors.New("SES region is required")
+	}
+
+	if from == "" {
+		return errors.New("SES sender is required")
+	}
+
+	if len(to) == 0 {
+		return errors.New("SES recipient is required")
+	}
+
+	params := &ses.SendEmailInput{
+		Source: aws.String(from),
+		Destination: &ses.Destination{
+			ToAddresses: aws.StringSlice(to),
+		},
+		Message: &ses.Message{
+			Subject: &ses.Content{Data: aws.String(subject)},
+			Body: &ses.Body{
+				Text: &ses.Content{Data: aws.String(body)},
+			},
+		},
+	}
+
+	sess, err := session.NewSession(aws.NewConfig().WithRegion(region))
+	if err != nil {
+		return err
+	}
+
+	_, err = ses.New(sess).SendEmail(params)
+	return err
+}
@@ -5,43 +5,129 @@
 package sns
 
 import (
+	"encoding/json"
+	"errors"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/healthcareblocks/ebs_snapshotter/awserror"
+	"github.com/healthcareblocks/ebs_snapshotter/notify"
 )
 
+// Notifier publishes notifications to an SNS topic. Protocol specific bodies in a
+// notification (e.g. "email", "sms") are sent using a JSON message structure.
+type Notifier struct {
+	// The AWS region of the SNS topic. This parameter is required.
+	Region string
+
+	// The SNS topic ARN. This parameter is required.
+	Topic string
+}
+
+// Notify publishes the notification to the Notifier's topic
+func (n *Notifier) Notify(notification *notify.Notification) error {
+	if notification.Message == "" {
+		return errors.New("SNS message is required")
+	}
+
+	if len(notification.Bodies) == 0 {
+		return publish(n.Region, n.Topic, notification.Subject, &sns.PublishInput{
+			Message: aws.String(notification.Message),
+		})
+	}
+
+	messages := map[string]string{"default": notification.Message}
+	for protocol, body := range notification.Bodies {
+		messages[protocol] = body
+	}
+	return publishStructured(n.Region, n.Topic, notification.Subject, messages)
+}
+
 // SendMessage sends an SNS message to an SNS region.
 // See http://docs.aws.amazon.com/sdk-for-go/api/service/sns.html#type-PublishInput
 func SendMessage(region string, topic string, subject string, message string) {
+	if message == "" {
+		log.Fatal("SNS message is required")
+	}
+
+	awserror.HandleError(publish(region, topic, subject, &sns.PublishInput{
+		Message: aws.String(message),
+	}))
+}
+
+// SendStructuredMessage sends an SNS message with a separate body for each transport
+// protocol, keyed by protocol name (e.g. "email", "sms"). A "default" body is required
+// and is delivered to any protocol without its own body.
+// See http://docs.aws.amazon.com/sns/latest/dg/PublishTopic.html
+func SendStructuredMessage(region string, topic string, subject string, messages map[string]string) {
+	if messages["default"] == "" {
+		log.Fatal("SNS default message is required")
+	}
+
+	awserror.HandleError(publishStructured(region, topic, subject, messages))
+}
+
+func publishStructured(region string, topic string, subject string, messages map[string]string) error {
+	body, err := json.Marshal(messages)
+	if err != nil {
+		return err
+	}
+
+	return publish(region, topic, subject, &sns.PublishInput{
+		Message:          aws.String(string(body)),
+		MessageStructure: aws.String("json"),
+	})
+}
+
+func publish(region string, topic string, subject string, params *sns.PublishInput) error {
 	if region == "" {
-		log.Fatal("SNS region is required")
+		return errors.New("SNS region is required")
 	}
 
 	if topic == "" {
-		log.Fatal("SNS topic is required")
+		return errors.New("SNS topic is required")
 	}
 
 	if subject == "" {
-		log.Fatal("SNS subject is required")
+		return errors.New("SNS subject is required")
 	}
 
-	if message == "" {
-		log.Fatal("SNS message is required")
-	}
+	params.Subject = aws.String(subjectLine(subject))
+	params.TargetArn = aws.String(topic)
 
-	params := &sns.PublishInput{
-		Subject:   aws.String(subject),
-		Message:   aws.String(message),
-		TargetArn: aws.String(topic),
+	sess, err := session.NewSession(aws.NewConfig().WithRegion(region))
+	if err != nil {
+		return err
 	}
 
-	sess, sessErr := session.NewSession(aws.NewConfig().WithRegion(region))
-	awserror.HandleError(sessErr)
-
 	sns := sns.New(sess)
 
-	_, publishErr := sns.Publish(params)
-	awserror.HandleError(publishErr)
+	_, err = sns.Publish(params)
+	return err
+}
+
+// maxSubjectLength is the longest subject SNS accepts
+const maxSubjectLength = 100
+
+// subjectLine makes a subject acceptable to SNS, which rejects subjects that are longer than
+// 100 characters or contain line breaks or non-ASCII characters, as custom templates can.
+// Control characters are replaced by spaces and other non-ASCII characters by "?".
+func subjectLine(subject string) string {
+	line := make([]rune, 0, len(subject))
+	for _, r := range subject {
+		switch {
+		case r < ' ' || r == 0x7f:
+			r = ' '
+		case r > '~':
+			r = '?'
+		}
+		line = append(line, r)
+	}
+
+	if len(line) > maxSubjectLength {
+		line = append(line[:maxSubjectLength-3], '.', '.', '.')
+	}
+	return string(line)
 }
@@ -0,0 +1,19 @@
+package sns
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubjectLineIsPrintableASCII(t *testing.T) {
+	assert.Equal(t, "EBS snapshots: us-east-1", subjectLine("EBS snapshots: us-east-1"))
+	assert.Equal(t, "Backups  prod caf? ?", subjectLine("Backups\r\nprod café ✓"))
+
+	subject := subjectLine(strings.Repeat("é", 150))
+	assert.True(t, utf8.ValidString(subject))
+	assert.Equal(t, maxSubjectLength, len(subject))
+	assert.Equal(t, strings.Repeat("?", 97)+"...", subject)
+}
@@ -0,0 +1,38 @@
+// Package sts looks up the AWS identity the host environment is authenticated as
+//
+// Note: GetCallerIdentity does not require any IAM permissions.
+package sts
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Identity describes the AWS account and principal making requests
+type Identity struct {
+	// The 12 digit AWS account ID
+	Account string
+
+	// The ARN of the calling user or role
+	Arn string
+}
+
+// CallerIdentity returns the identity of the credentials used by the AWS SDK.
+// See http://docs.aws.amazon.com/STS/latest/APIReference/API_GetCallerIdentity.html
+func CallerIdentity(region string) (*Identity, error) {
+	sess, err := session.NewSession(aws.NewConfig().WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Account: aws.StringValue(resp.Account),
+		Arn:     aws.StringValue(resp.Arn),
+	}, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"text/template"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/healthcareblocks/ebs_snapshotter/ebs"
+)
+
+// snapshotTagging holds the snapshot tag and description settings parsed from flags
+type snapshotTagging struct {
+	tags        []*ec2.Tag
+	include     []string
+	exclude     []string
+	instance    []string
+	attachment  bool
+	description *template.Template
+	name        *template.Template
+}
+
+// newSnapshotTagging parses the snapshot tag flags, exiting if any are invalid so that
+// mistakes are caught before snapshots are taken
+func newSnapshotTagging() *snapshotTagging {
+	t := &snapshotTagging{
+		include:     splitList(*copyTagsInclude),
+		exclude:     splitList(*copyTagsExclude),
+		instance:    splitList(*copyInstanceTags),
+		attachment:  *attachmentTags,
+		description: mustParseSnapshotTemplate("description", *snapshotDescription),
+		name:        mustParseSnapshotTemplate("name", *snapshotName),
+	}
+
+	for _, pair := range splitList(*snapshotTags) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			log.Fatalf("invalid -tags %q, must be key=value pairs", pair)
+		}
+		t.tags = append(t.tags, &ec2.Tag{Key: aws.String(parts[0]), Value: aws.String(parts[1])})
+	}
+
+	return t
+}
+
+// apply configures the snapshot manager with the tag settings
+func (t *snapshotTagging) apply(mgr *ebs.SnapshotManager) {
+	mgr.Tags = t.tags
+	mgr.IncludeTagKeys = t.include
+	mgr.ExcludeTagKeys = t.exclude
+	mgr.InstanceTagKeys = t.instance
+	mgr.AttachmentTags = t.attachment
+	mgr.DescriptionTemplate = t.description
+	mgr.NameTemplate = t.name
+}
+
+func mustParseSnapshotTemplate(name string, text string) *template.Template {
+	if text == "" {
+		return nil
+	}
+
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		log.Fatalf("invalid -%s template: %v", name, err)
+	}
+	return tmpl
+}
+
+// splitList splits a comma delimited list, returning nil if list is empty
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, ",")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/healthcareblocks/ebs_snapshotter/ebs"
+)
+
+// verifyOptions returns the restore verification drill options from the -verify flags,
+// exiting if they are invalid
+func verifyOptions(regionNames []string) ebs.VerifyOptions {
+	if *cloudwatchMetrics || *metricsAddr != "" || *metricsTextfile != "" {
+		log.Fatal("-verify can't be combined with -cloudwatch, -metrics_addr or -metrics_textfile")
+	}
+
+	if *verifySample < 0 {
+		log.Fatal("-verify_sample can't be negative")
+	}
+
+	opts := ebs.VerifyOptions{Sample: *verifySample}
+
+	if *verifyInstance != "" {
+		if len(regionNames) > 1 {
+			log.Fatal("-verify_instance requires a single region")
+		}
+		opts.InstanceID = *verifyInstance
+		opts.Device = *verifyDevice
+	}
+
+	if *verifyCommand != "" {
+		if *verifyInstance == "" {
+			log.Fatal("-verify_command requires -verify_instance")
+		}
+		opts.Check = runVerifyCommand
+	}
+
+	return opts
+}
+
+// runVerifyCommand runs -verify_command with a shell on this host, which is expected to be
+// the verifier instance. The restored volume is described to the command with environment
+// variables.
+func runVerifyCommand(volume *ec2.Volume, snapshot *ec2.Snapshot, device string) error {
+	cmd := exec.Command("/bin/sh", "-c", *verifyCommand)
+	cmd.Env = append(os.Environ(),
+		"EBS_VOLUME_ID="+aws.StringValue(volume.VolumeId),
+		"EBS_SNAPSHOT_ID="+aws.StringValue(snapshot.SnapshotId),
+		"EBS_SOURCE_VOLUME_ID="+aws.StringValue(snapshot.VolumeId),
+		"EBS_DEVICE="+device,
+	)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return nil
+}